@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"Dehash/internal/sqlite"
+	"fmt"
+	"github.com/spf13/cobra"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Cache purge command flags
+	purgeExpiredOnly bool
+
+	// Cache command
+	cacheCmd = &cobra.Command{
+		Use:   "cache",
+		Short: "Manage cached API responses",
+		Long: `Manage the search responses cached in the local database. Repeated requests are
+answered from the cache without spending credits until the response expires (see --cache-ttl,
+--no-cache and --refresh of the query command).`,
+	}
+
+	// Cache list command
+	cacheListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List cached responses",
+		Run: func(cmd *cobra.Command, args []string) {
+			cached, err := sqlite.ListCachedResponses(cmd.Context())
+			if err != nil {
+				exitWithError(err)
+			}
+
+			if len(cached) == 0 {
+				fmt.Println("No cached responses found.")
+				return
+			}
+
+			// Print table header and separator line
+			widths := []int{12, 19, 19, 5, 6, 7, 40}
+			formatStr := "%-12s %-19s %-19s %-5s %-6s %-7s %s\n"
+			fmt.Printf(formatStr, "Key", "Cached", "Expires", "Page", "Size", "Entries", "Query")
+			separator := ""
+			for _, width := range widths {
+				separator += strings.Repeat("-", width) + " "
+			}
+			fmt.Println(separator)
+
+			expired := 0
+			for _, response := range cached {
+				expires := response.ExpiresAt.Format("2006-01-02 15:04:05")
+				if response.Expired() {
+					expires = "expired"
+					expired++
+				}
+				fmt.Printf(formatStr,
+					response.Key[:12],
+					response.CreatedAt.Format("2006-01-02 15:04:05"),
+					expires,
+					strconv.Itoa(response.Page),
+					strconv.Itoa(response.Size),
+					strconv.Itoa(response.Entries),
+					truncate(response.Query, 60),
+				)
+			}
+			fmt.Printf("\n%d cached responses, %d expired\n", len(cached), expired)
+		},
+	}
+
+	// Cache purge command
+	cachePurgeCmd = &cobra.Command{
+		Use:   "purge",
+		Short: "Delete cached responses",
+		Run: func(cmd *cobra.Command, args []string) {
+			deleted, err := sqlite.PurgeCache(cmd.Context(), purgeExpiredOnly)
+			if err != nil {
+				exitWithError(err)
+			}
+			fmt.Printf("Deleted %d cached responses\n", deleted)
+		},
+	}
+)
+
+func init() {
+	// Add subcommands to cache command
+	cacheCmd.AddCommand(cacheListCmd)
+	cacheCmd.AddCommand(cachePurgeCmd)
+
+	cachePurgeCmd.Flags().BoolVar(&purgeExpiredOnly, "expired", false, "Only delete expired responses")
+}
@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"Dehash/internal/badger"
+	"Dehash/pkg/dehashed"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultHTTPTimeout bounds the wait for an API response unless --timeout or the timeout key is set
+	DefaultHTTPTimeout = 2 * time.Minute
+
+	// DefaultUserAgent is sent with API requests unless --user-agent or the user_agent key is set
+	DefaultUserAgent = "dehasher/v1.0"
+)
+
+// configKey is a stored setting providing the default of the global flag of the same meaning
+type configKey struct {
+	Name        string
+	Flag        string
+	Description string
+	apply       func(config *dehashed.HTTPConfig, value string) error
+}
+
+// configKeys are the settings managed by the config command
+var configKeys = []configKey{
+	{
+		Name:        "proxy",
+		Flag:        "proxy",
+		Description: "Proxy URL for API requests (http, https or socks5)",
+		apply: func(config *dehashed.HTTPConfig, value string) error {
+			if _, err := dehashed.NewTransport(dehashed.HTTPConfig{Proxy: value}); err != nil {
+				return err
+			}
+			config.Proxy = value
+			return nil
+		},
+	},
+	{
+		Name:        "timeout",
+		Flag:        "timeout",
+		Description: "Time to wait for the API to answer a request, e.g. 30s or 5m",
+		apply: func(config *dehashed.HTTPConfig, value string) error {
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout < 0 {
+				return fmt.Errorf("invalid duration %q", value)
+			}
+			config.Timeout = timeout
+			return nil
+		},
+	},
+	{
+		Name:        "ca_cert",
+		Flag:        "ca-cert",
+		Description: "PEM file of an additional trusted CA",
+		apply: func(config *dehashed.HTTPConfig, value string) error {
+			if _, err := os.Stat(value); err != nil {
+				return err
+			}
+			config.CACertFile = value
+			return nil
+		},
+	},
+	{
+		Name:        "insecure",
+		Flag:        "insecure",
+		Description: "Skip TLS certificate verification (true or false)",
+		apply: func(config *dehashed.HTTPConfig, value string) error {
+			insecure, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid boolean %q", value)
+			}
+			config.Insecure = insecure
+			return nil
+		},
+	},
+	{
+		Name:        "user_agent",
+		Flag:        "user-agent",
+		Description: "User-Agent header sent with API requests",
+		apply: func(config *dehashed.HTTPConfig, value string) error {
+			config.UserAgent = value
+			return nil
+		},
+	},
+}
+
+// findConfigKey returns the config key with the given name
+func findConfigKey(name string) (configKey, error) {
+	for _, key := range configKeys {
+		if key.Name == name {
+			return key, nil
+		}
+	}
+	names := make([]string, len(configKeys))
+	for i, key := range configKeys {
+		names[i] = key.Name
+	}
+	return configKey{}, fmt.Errorf("unknown config key %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+var (
+	// Config command
+	configCmd = &cobra.Command{
+		Use:   "config",
+		Short: "Manage stored settings",
+		Long: `Manage the settings stored in the local keystore. Each key is the default of the
+global flag of the same name, so a flag given on the command line takes precedence.`,
+	}
+
+	// Config set command
+	configSetCmd = &cobra.Command{
+		Use:   "set [key] [value]",
+		Short: "Store a setting",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			key, err := findConfigKey(args[0])
+			if err != nil {
+				exitWithError(err)
+			}
+			if err := key.apply(&dehashed.HTTPConfig{}, args[1]); err != nil {
+				exitWithError(fmt.Errorf("invalid value for %s: %w", key.Name, err))
+			}
+			if err := badger.StoreConfig(key.Name, args[1]); err != nil {
+				exitWithError(err)
+			}
+			fmt.Printf("Stored %s\n", key.Name)
+		},
+	}
+
+	// Config get command
+	configGetCmd = &cobra.Command{
+		Use:   "get [key]",
+		Short: "Show a stored setting",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			key, err := findConfigKey(args[0])
+			if err != nil {
+				exitWithError(err)
+			}
+			fmt.Println(badger.GetConfig(key.Name))
+		},
+	}
+
+	// Config unset command
+	configUnsetCmd = &cobra.Command{
+		Use:   "unset [key]",
+		Short: "Remove a stored setting",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			key, err := findConfigKey(args[0])
+			if err != nil {
+				exitWithError(err)
+			}
+			if err := badger.DeleteConfig(key.Name); err != nil {
+				exitWithError(err)
+			}
+			fmt.Printf("Removed %s\n", key.Name)
+		},
+	}
+
+	// Config list command
+	configListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List all settings",
+		Run: func(cmd *cobra.Command, args []string) {
+			formatStr := "%-12s %-30s %s\n"
+			fmt.Printf(formatStr, "Key", "Value", "Description")
+			fmt.Println(strings.Repeat("-", 12) + " " + strings.Repeat("-", 30) + " " + strings.Repeat("-", 50))
+			for _, key := range configKeys {
+				value := badger.GetConfig(key.Name)
+				if value == "" {
+					value = "(unset)"
+				}
+				fmt.Printf(formatStr, key.Name, truncate(value, 30), key.Description)
+			}
+		},
+	}
+)
+
+func init() {
+	// Add subcommands to config command
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configUnsetCmd)
+	configCmd.AddCommand(configListCmd)
+}
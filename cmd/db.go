@@ -5,12 +5,19 @@ import (
 	"Dehash/internal/files"
 	"Dehash/internal/sqlite"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// dbBatchSize is how many results are read from the database at a time
+const dbBatchSize = 500
+
 var (
 	// DB command flags
 	dbPath string
@@ -31,9 +38,31 @@ var (
 	domainDBQuery                string
 	limitResultsDB               int
 	exactMatchDBQuery            bool
+	prefixMatchDBQuery           bool
+	suffixMatchDBQuery           bool
+	globMatchDBQuery             bool
+	regexMatchDBQuery            bool
+	fieldMatchDBQuery            []string
 	outputFormatDB               string
 	nonEmptyFieldsDBQuery        string
 	displayFieldsDBQuery         string
+	runDBQuery                   []uint
+	sortDBQuery                  string
+	offsetDBQuery                int
+	pageDBQuery                  int
+	sinceDBQuery                 string
+	createdAfterDBQuery          string
+	createdBeforeDBQuery         string
+
+	// DB export command flags
+	limitExportDB     int
+	outputExportDB    string
+	formatExportDB    string
+	allExportDB       bool
+	credsOnlyExportDB bool
+
+	// DB runs command flags
+	limitRunsDB int
 
 	// DB command
 	dbCmd = &cobra.Command{
@@ -47,128 +76,186 @@ func init() {
 	// Add subcommands to db command
 	dbCmd.AddCommand(dbExportCmd)
 	dbCmd.AddCommand(dbQueryCmd)
+	dbCmd.AddCommand(dbRunsCmd)
+	dbCmd.AddCommand(dbSearchCmd)
 
 	// Add flags specific to db command
 	dbCmd.PersistentFlags().StringVarP(&dbPath, "db-path", "D", "", "Path to database (default: ~/.local/share/Dehasher/dehashed.db)")
 
 	// Add flags specific to db query command
-	dbQueryCmd.Flags().StringVarP(&usernameDBQuery, "username", "u", "", "Filter by username")
-	dbQueryCmd.Flags().StringVarP(&emailDBQuery, "email", "e", "", "Filter by email")
-	dbQueryCmd.Flags().StringVarP(&ipDBQuery, "ip", "i", "", "Filter by IP address")
-	dbQueryCmd.Flags().StringVarP(&passwordDBQuery, "password", "p", "", "Filter by password")
-	dbQueryCmd.Flags().StringVarP(&hashDBQuery, "hash", "H", "", "Filter by hashed password")
-	dbQueryCmd.Flags().StringVarP(&nameDBQuery, "name", "n", "", "Filter by name")
-	dbQueryCmd.Flags().StringVarP(&vinDBQuery, "vin", "v", "", "Filter by VIN")
-	dbQueryCmd.Flags().StringVarP(&licensePlateDBQuery, "license", "L", "", "Filter by license plate")
-	dbQueryCmd.Flags().StringVarP(&addressDBQuery, "address", "a", "", "Filter by address")
-	dbQueryCmd.Flags().StringVarP(&phoneDBQuery, "phone", "P", "", "Filter by phone number")
-	dbQueryCmd.Flags().StringVarP(&socialDBQuery, "social", "s", "", "Filter by social media handle")
-	dbQueryCmd.Flags().StringVarP(&cryptoCurrencyAddressDBQuery, "crypto", "c", "", "Filter by cryptocurrency address")
-	dbQueryCmd.Flags().StringVarP(&domainDBQuery, "domain", "d", "", "Filter by domain/URL")
-	dbQueryCmd.Flags().IntVarP(&limitResultsDB, "limit", "l", 100, "Limit number of results")
-	dbQueryCmd.Flags().BoolVarP(&exactMatchDBQuery, "exact", "x", false, "Use exact matching instead of partial matching")
-	dbQueryCmd.Flags().StringVarP(&outputFormatDB, "format", "f", "table", "Output format (json, table, simple)")
-	dbQueryCmd.Flags().StringVar(&nonEmptyFieldsDBQuery, "non-empty", "", "Filter for non-empty fields (comma-separated list, e.g., 'password,email')")
+	addFilterFlags(dbQueryCmd)
+	dbQueryCmd.Flags().IntVarP(&limitResultsDB, "limit", "l", 100, "Limit number of results, 0 for no limit")
+	dbQueryCmd.Flags().IntVar(&pageDBQuery, "page", 0, "Show this page of results, pages hold --limit results")
+	dbQueryCmd.Flags().StringVarP(&outputFormatDB, "format", "f", "table", "Output format (table, simple or json)")
 	dbQueryCmd.Flags().StringVar(&displayFieldsDBQuery, "display", "", "Fields to display in output (comma-separated list, e.g., 'username,email,password')")
+	dbQueryCmd.MarkFlagsMutuallyExclusive("offset", "page")
+
+	// Add flags specific to db export command
+	addFilterFlags(dbExportCmd)
+	dbExportCmd.Flags().IntVarP(&limitExportDB, "limit", "l", 0, "Limit number of results, 0 for no limit")
+	dbExportCmd.Flags().StringVarP(&outputExportDB, "output", "o", "dehasher_export", "File to export to, the extension of the format is added")
+	dbExportCmd.Flags().StringVarP(&formatExportDB, "format", "f", "", "File format (json, yaml, xml, txt), from the --output extension or json by default")
+	dbExportCmd.Flags().BoolVar(&allExportDB, "all", false, "Export every stored result, without filters")
+	dbExportCmd.Flags().BoolVarP(&credsOnlyExportDB, "creds-only", "C", false, "Export the stored credentials of the matching results")
+
+	// Add flags specific to db search command
+	dbSearchCmd.Flags().IntVarP(&limitResultsDB, "limit", "l", 100, "Limit number of results")
+	dbSearchCmd.Flags().StringVarP(&outputFormatDB, "format", "f", "table", "Output format (table, simple or json)")
+	dbSearchCmd.Flags().StringVar(&displayFieldsDBQuery, "display", "", "Fields to display in output (comma-separated list, e.g., 'username,email,password')")
+
+	// Add flags specific to db runs command
+	dbRunsCmd.Flags().IntVarP(&limitRunsDB, "limit", "l", 20, "Limit number of runs")
 }
 
 // DB export command
 var dbExportCmd = &cobra.Command{
 	Use:   "export",
 	Short: "Export database to file",
+	Long: `Export the results stored in the local database to a file, using the same filters as
+db query, or every stored result with --all. With --creds-only the stored credentials of the
+matching results are exported instead.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("Exporting database...")
-		// Create DBOptions with the provided parameters
-		options := &sqlite.DBOptions{
-			Username:       usernameDBQuery,
-			Email:          emailDBQuery,
-			IPAddress:      ipDBQuery,
-			Password:       passwordDBQuery,
-			HashedPassword: hashDBQuery,
-			Name:           nameDBQuery,
-			Limit:          limitResultsDB,
-			ExactMatch:     exactMatchDBQuery,
+		options, err := getDBOptions(limitExportDB)
+		if err != nil {
+			exitWithError(err)
 		}
 
-		// Parse non-empty fields if provided
-		if nonEmptyFieldsDBQuery != "" {
-			options.NonEmptyFields = strings.Split(nonEmptyFieldsDBQuery, ",")
+		// Check if at least one search parameter is provided, or everything is exported
+		if allExportDB && options.HasFilters() {
+			exitWithError(errors.New("--all cannot be combined with filters"))
 		}
-
-		// Parse display fields if provided
-		if displayFieldsDBQuery != "" {
-			options.DisplayFields = strings.Split(displayFieldsDBQuery, ",")
-		}
-
-		// Check if at least one search parameter is provided
-		if options.Username == "" && options.Email == "" && options.IPAddress == "" &&
-			options.Password == "" && options.HashedPassword == "" && options.Name == "" &&
-			len(options.NonEmptyFields) == 0 {
-			fmt.Println("Error: At least one search parameter is required.")
+		if !allExportDB && !options.HasFilters() {
 			cmd.Help()
-			return
+			exitWithError(errors.New("at least one search parameter is required, or --all to export every result"))
 		}
 
-		// Get the count of matching results
-		count, err := sqlite.GetResultsCount(options)
+		// Resolve the output file and format
+		outputFile, ft, err := getExportFile(outputExportDB, formatExportDB)
 		if err != nil {
-			fmt.Printf("Error counting results: %v\n", err)
-			return
+			exitWithError(err)
 		}
 
-		// Query the database
-		results, err := sqlite.QueryResults(options)
-		if err != nil {
-			fmt.Printf("Error querying database: %v\n", err)
-			return
+		fmt.Println("Exporting database...")
+		var count int
+		var path string
+		if credsOnlyExportDB {
+			count, path, err = exportCreds(cmd, options, outputFile, ft)
+		} else {
+			count, path, err = exportResults(cmd, options, outputFile, ft)
 		}
-		dhResults := sqlite.DehashedResults{Results: results}
-
-		fmt.Printf("Found %d results (showing %d):\n", count, len(results))
-
-		// Output results based on format
-		ft := files.GetFileType(outputFormatDB)
-		err = export.WriteToFile(dhResults, "dehasher_export", ft)
 		if err != nil {
 			zap.L().Error("write_to_file",
 				zap.String("message", "failed to write to file"),
 				zap.Error(err),
 			)
-			fmt.Printf("Error writing to file: %v\n", err)
+			exitWithError(fmt.Errorf("failed to export: %w", err))
+		}
+
+		if count == 0 {
+			fmt.Println("No results found.")
 			return
 		}
-		fmt.Printf("Exported successfully to file: dehasher_export%s\n", ft.Extension())
+		fmt.Printf("Exported %d entries successfully to file: %s\n", count, path)
 	},
 }
 
+// exportResults streams the matching results from the database into the output file
+func exportResults(cmd *cobra.Command, options *sqlite.DBOptions, outputFile string, ft files.FileType) (int, string, error) {
+	w, err := export.NewResultWriter(outputFile, ft, export.Create)
+	if err != nil {
+		return 0, "", err
+	}
+	it, err := sqlite.QueryResultsIter(cmd.Context(), options, dbBatchSize)
+	if err != nil {
+		return 0, "", err
+	}
+	defer it.Close()
+	err = writeRows(it, w)
+	return w.Count(), w.Path(), err
+}
+
+// exportCreds streams the stored credentials of the matching results into the output file
+func exportCreds(cmd *cobra.Command, options *sqlite.DBOptions, outputFile string, ft files.FileType) (int, string, error) {
+	w, err := export.NewCredsWriter(outputFile, ft, export.Create)
+	if err != nil {
+		return 0, "", err
+	}
+	it, err := sqlite.QueryCredsIter(cmd.Context(), options, dbBatchSize)
+	if err != nil {
+		return 0, "", err
+	}
+	defer it.Close()
+	err = writeRows(it, w)
+	return w.Count(), w.Path(), err
+}
+
+// writeRows writes every batch of rows to w and closes it
+func writeRows[T any](it *sqlite.Iterator[T], w *export.Writer[T]) error {
+	var err error
+	for err == nil && it.Next() {
+		for _, row := range it.Batch() {
+			if err = w.Write(row); err != nil {
+				break
+			}
+		}
+	}
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = it.Err()
+	}
+	return err
+}
+
+// getExportFile returns the export file without its extension and its format. Without a
+// format, the extension of the file is used when it names one.
+func getExportFile(outputFile, format string) (string, files.FileType, error) {
+	ext := filepath.Ext(outputFile)
+	if format == "" {
+		if ft, err := files.ParseFileType(ext); ext != "" && err == nil {
+			return strings.TrimSuffix(outputFile, ext), ft, nil
+		}
+		return outputFile, files.JSON, nil
+	}
+
+	ft, err := files.ParseFileType(format)
+	if err != nil {
+		return "", ft, err
+	}
+	if extType, err := files.ParseFileType(ext); ext != "" && err == nil && extType == ft {
+		outputFile = strings.TrimSuffix(outputFile, ext)
+	}
+	return outputFile, ft, nil
+}
+
+// parseOutputFormat returns the format results are printed in by db query and db search:
+// table, simple or json, the one file format printed to the terminal
+func parseOutputFormat(format string) (string, error) {
+	switch name := strings.ToLower(strings.TrimSpace(format)); name {
+	case "table", "simple":
+		return name, nil
+	}
+	ft, err := files.ParseFileType(strings.TrimSpace(format))
+	if err != nil || ft != files.JSON {
+		return "", fmt.Errorf("unsupported output format %q (table, simple or json), use db export for other file formats", format)
+	}
+	return "json", nil
+}
+
 // DB query command
 var dbQueryCmd = &cobra.Command{
 	Use:   "query",
 	Short: "Query local database",
 	Long:  `Query the local database for previously run dehasher queries based on various parameters.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		// Create DBOptions with the provided parameters
-		options := &sqlite.DBOptions{
-			Username:              usernameDBQuery,
-			Email:                 emailDBQuery,
-			IPAddress:             ipDBQuery,
-			Password:              passwordDBQuery,
-			HashedPassword:        hashDBQuery,
-			Name:                  nameDBQuery,
-			Vin:                   vinDBQuery,
-			LicensePlate:          licensePlateDBQuery,
-			Address:               addressDBQuery,
-			Phone:                 phoneDBQuery,
-			Social:                socialDBQuery,
-			CryptoCurrencyAddress: cryptoCurrencyAddressDBQuery,
-			Domain:                domainDBQuery,
-			Limit:                 limitResultsDB,
-			ExactMatch:            exactMatchDBQuery,
-		}
-
-		// Parse non-empty fields if provided
-		if nonEmptyFieldsDBQuery != "" {
-			options.NonEmptyFields = strings.Split(nonEmptyFieldsDBQuery, ",")
+		options, err := getDBOptions(limitResultsDB)
+		if err != nil {
+			exitWithError(err)
+		}
+		format, err := parseOutputFormat(outputFormatDB)
+		if err != nil {
+			exitWithError(err)
 		}
 
 		// Parse display fields if provided
@@ -176,174 +263,488 @@ var dbQueryCmd = &cobra.Command{
 			options.DisplayFields = strings.Split(displayFieldsDBQuery, ",")
 		}
 
+		// Parse the page if provided
+		if pageDBQuery != 0 {
+			if pageDBQuery < 1 || options.Limit < 1 {
+				exitWithError(errors.New("--page must be at least 1 and needs a --limit of at least 1"))
+			}
+			options.Offset = (pageDBQuery - 1) * options.Limit
+		}
+
 		// Check if at least one search parameter is provided
-		if options.Username == "" && options.Email == "" && options.IPAddress == "" &&
-			options.Password == "" && options.HashedPassword == "" && options.Name == "" &&
-			options.Vin == "" && options.LicensePlate == "" && options.Address == "" &&
-			options.Phone == "" && options.Social == "" && options.CryptoCurrencyAddress == "" && options.Domain == "" &&
-			len(options.NonEmptyFields) == 0 {
-			fmt.Println("Error: At least one search parameter is required.")
+		if !options.HasFilters() {
 			cmd.Help()
-			return
+			exitWithError(errors.New("at least one search parameter is required"))
 		}
 
 		// Get the count of matching results
-		count, err := sqlite.GetResultsCount(options)
+		count, err := sqlite.GetResultsCount(cmd.Context(), options)
 		if err != nil {
-			fmt.Printf("Error counting results: %v\n", err)
+			exitWithError(err)
+		}
+
+		// Display the results
+		shown := max(0, int(count)-options.Offset)
+		if options.Limit > 0 {
+			shown = min(shown, options.Limit)
+		}
+		if options.Offset > 0 && shown > 0 {
+			fmt.Printf("Found %d results (showing %d-%d):\n", count, options.Offset+1, options.Offset+shown)
+		} else {
+			fmt.Printf("Found %d results (showing %d):\n", count, shown)
+		}
+
+		if shown == 0 {
+			fmt.Println("No results found.")
 			return
 		}
 
-		// Query the database
-		results, err := sqlite.QueryResults(options)
+		// Stream the results from the database, a batch at a time
+		it, err := sqlite.QueryResultsIter(cmd.Context(), options, dbBatchSize)
 		if err != nil {
-			fmt.Printf("Error querying database: %v\n", err)
-			return
+			exitWithError(err)
+		}
+		defer it.Close()
+
+		// Output results based on format
+		printer := newResultPrinter(options.DisplayFields, format, false)
+		for it.Next() {
+			if err := printer.print(it.Batch(), nil); err != nil {
+				exitWithError(fmt.Errorf("failed to format results: %w", err))
+			}
+		}
+		printer.close()
+		if err := it.Err(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// DB search command
+var dbSearchCmd = &cobra.Command{
+	Use:   "search <terms>",
+	Short: "Full-text search of the local database",
+	Long: `Search every field of the results stored in the local database, best matches first.
+
+Terms use the SQLite FTS5 query syntax: smith AND corp, "john smith", admin*, NOT test,
+"admin@corp.com" (punctuation needs quotes), or email:corp to search a single field.
+Matched terms are shown in [brackets].`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, err := parseOutputFormat(outputFormatDB)
+		if err != nil {
+			exitWithError(err)
+		}
+		matches, count, err := sqlite.SearchResults(cmd.Context(), strings.Join(args, " "), limitResultsDB)
+		if err != nil {
+			exitWithError(err)
 		}
 
 		// Display the results
-		fmt.Printf("Found %d results (showing %d):\n", count, len(results))
+		fmt.Printf("Found %d results (showing %d):\n", count, len(matches))
 
-		if len(results) == 0 {
+		if len(matches) == 0 {
 			fmt.Println("No results found.")
 			return
 		}
 
-		// Output results based on format
-		switch outputFormatDB {
-		case "json":
-			data, err := json.MarshalIndent(results, "", "  ")
+		// JSON output includes the rank and snippet of each match
+		if format == "json" {
+			data, err := json.MarshalIndent(matches, "", "  ")
 			if err != nil {
-				fmt.Printf("Error formatting results: %v\n", err)
-				return
+				exitWithError(fmt.Errorf("failed to format results: %w", err))
 			}
 			fmt.Println(string(data))
-		case "table":
-			// Determine which fields to display
-			type FieldInfo struct {
-				Name   string
-				Width  int
-				Getter func(result sqlite.Result) string
-			}
+			return
+		}
 
-			// Define all available fields
-			allFields := []FieldInfo{
-				{"Username", 20, func(r sqlite.Result) string { return truncate(arrayToString(r.Username), 20) }},
-				{"Email", 30, func(r sqlite.Result) string { return truncate(arrayToString(r.Email), 30) }},
-				{"IP Address", 15, func(r sqlite.Result) string { return truncate(arrayToString(r.IpAddress), 15) }},
-				{"Password", 20, func(r sqlite.Result) string { return truncate(arrayToString(r.Password), 20) }},
-				{"Hashed Password", 20, func(r sqlite.Result) string { return truncate(arrayToString(r.HashedPassword), 20) }},
-				{"Name", 20, func(r sqlite.Result) string { return truncate(arrayToString(r.Name), 20) }},
-				{"VIN", 20, func(r sqlite.Result) string { return truncate(arrayToString(r.Vin), 20) }},
-				{"License Plate", 15, func(r sqlite.Result) string { return truncate(arrayToString(r.LicensePlate), 15) }},
-				{"Address", 30, func(r sqlite.Result) string { return truncate(arrayToString(r.Address), 30) }},
-				{"Phone", 15, func(r sqlite.Result) string { return truncate(arrayToString(r.Phone), 15) }},
-				{"Social", 20, func(r sqlite.Result) string { return truncate(arrayToString(r.Social), 20) }},
-				{"Crypto Address", 20, func(r sqlite.Result) string { return truncate(arrayToString(r.CryptoCurrencyAddress), 20) }},
-				{"Domain/URL", 30, func(r sqlite.Result) string { return truncate(arrayToString(r.Url), 30) }},
-			}
+		var displayFields []string
+		if displayFieldsDBQuery != "" {
+			displayFields = strings.Split(displayFieldsDBQuery, ",")
+		}
+		results := make([]sqlite.Result, len(matches))
+		snippets := make([]string, len(matches))
+		for i, match := range matches {
+			results[i], snippets[i] = match.Result, match.Snippet
+		}
+		printer := newResultPrinter(displayFields, format, true)
+		if err := printer.print(results, snippets); err != nil {
+			exitWithError(fmt.Errorf("failed to format results: %w", err))
+		}
+		printer.close()
+	},
+}
 
-			// Select fields to display
-			var fieldsToDisplay []FieldInfo
-			if len(options.DisplayFields) > 0 {
-				// Use specified fields
-				for _, fieldName := range options.DisplayFields {
-					fieldName = strings.ToLower(strings.TrimSpace(fieldName))
-					for _, field := range allFields {
-						if strings.ToLower(field.Name) == fieldName ||
-							(fieldName == "ip" && strings.ToLower(field.Name) == "ip address") ||
-							(fieldName == "hash" && strings.ToLower(field.Name) == "hashed password") ||
-							(fieldName == "license" && strings.ToLower(field.Name) == "license plate") ||
-							(fieldName == "crypto" && strings.ToLower(field.Name) == "crypto address") ||
-							(fieldName == "url" && strings.ToLower(field.Name) == "domain/url") {
-							fieldsToDisplay = append(fieldsToDisplay, field)
-							break
-						}
-					}
+// fieldInfo is a column of the results table
+type fieldInfo struct {
+	Name   string
+	Width  int
+	Getter func(result sqlite.Result) string
+}
+
+// tableFields are the columns available to the results table, the first 6 are shown by default
+var tableFields = []fieldInfo{
+	{"Username", 20, func(r sqlite.Result) string { return truncate(arrayToString(r.Username), 20) }},
+	{"Email", 30, func(r sqlite.Result) string { return truncate(arrayToString(r.Email), 30) }},
+	{"IP Address", 15, func(r sqlite.Result) string { return truncate(arrayToString(r.IpAddress), 15) }},
+	{"Password", 20, func(r sqlite.Result) string { return truncate(arrayToString(r.Password), 20) }},
+	{"Hashed Password", 20, func(r sqlite.Result) string { return truncate(arrayToString(r.HashedPassword), 20) }},
+	{"Name", 20, func(r sqlite.Result) string { return truncate(arrayToString(r.Name), 20) }},
+	{"VIN", 20, func(r sqlite.Result) string { return truncate(arrayToString(r.Vin), 20) }},
+	{"License Plate", 15, func(r sqlite.Result) string { return truncate(arrayToString(r.LicensePlate), 15) }},
+	{"Address", 30, func(r sqlite.Result) string { return truncate(arrayToString(r.Address), 30) }},
+	{"Phone", 15, func(r sqlite.Result) string { return truncate(arrayToString(r.Phone), 15) }},
+	{"Social", 20, func(r sqlite.Result) string { return truncate(arrayToString(r.Social), 20) }},
+	{"Crypto Address", 20, func(r sqlite.Result) string { return truncate(arrayToString(r.CryptoCurrencyAddress), 20) }},
+	{"Domain/URL", 30, func(r sqlite.Result) string { return truncate(arrayToString(r.Url), 30) }},
+}
+
+// resultPrinter prints results in the table, json or simple format a batch at a time,
+// showing displayFields or the default fields. Results with snippets show them as their match.
+type resultPrinter struct {
+	format        string
+	displayFields []string
+	fields        []fieldInfo
+	formatStr     string
+	printed       int
+}
+
+func newResultPrinter(displayFields []string, format string, snippets bool) *resultPrinter {
+	p := &resultPrinter{format: format, displayFields: displayFields}
+	if format != "table" {
+		return p
+	}
+
+	// Select fields to display
+	if len(displayFields) > 0 {
+		// Use specified fields
+		for _, fieldName := range displayFields {
+			fieldName = strings.ToLower(strings.TrimSpace(fieldName))
+			for _, field := range tableFields {
+				if strings.ToLower(field.Name) == fieldName ||
+					(fieldName == "ip" && strings.ToLower(field.Name) == "ip address") ||
+					(fieldName == "hash" && strings.ToLower(field.Name) == "hashed password") ||
+					(fieldName == "license" && strings.ToLower(field.Name) == "license plate") ||
+					(fieldName == "crypto" && strings.ToLower(field.Name) == "crypto address") ||
+					(fieldName == "url" && strings.ToLower(field.Name) == "domain/url") {
+					p.fields = append(p.fields, field)
+					break
 				}
-			} else {
-				// Default fields (first 6)
-				fieldsToDisplay = allFields[:6]
 			}
+		}
+	} else {
+		// Default fields (first 6)
+		p.fields = tableFields[:6]
+	}
 
-			// Print table header
-			formatStr := ""
-			headerValues := []interface{}{}
-			for _, field := range fieldsToDisplay {
-				formatStr += "%-" + fmt.Sprintf("%d", field.Width) + "s "
-				headerValues = append(headerValues, field.Name)
-			}
-			fmt.Printf(formatStr+"\n", headerValues...)
+	// Show the match of each result last
+	if snippets {
+		p.fields = append(p.fields, fieldInfo{Name: "Match", Width: 50})
+	}
+	for _, field := range p.fields {
+		p.formatStr += "%-" + fmt.Sprintf("%d", field.Width) + "s "
+	}
+	return p
+}
 
-			// Print separator line
+// print prints a batch of results, along with their snippets when given
+func (p *resultPrinter) print(results []sqlite.Result, snippets []string) error {
+	switch p.format {
+	case "json":
+		// The batches are printed as a single array
+		for _, result := range results {
+			data, err := json.MarshalIndent(result, "  ", "  ")
+			if err != nil {
+				return err
+			}
+			if p.printed == 0 {
+				fmt.Print("[\n  ")
+			} else {
+				fmt.Print(",\n  ")
+			}
+			fmt.Print(string(data))
+			p.printed++
+		}
+	case "table":
+		// Print table header and separator line before the first result
+		if p.printed == 0 && len(results) > 0 {
+			headerValues := []interface{}{}
 			separator := ""
-			for _, field := range fieldsToDisplay {
+			for _, field := range p.fields {
+				headerValues = append(headerValues, field.Name)
 				separator += strings.Repeat("-", field.Width) + " "
 			}
+			fmt.Printf(p.formatStr+"\n", headerValues...)
 			fmt.Println(separator)
+		}
 
-			// Print each result
-			for _, result := range results {
-				rowValues := []interface{}{}
-				for _, field := range fieldsToDisplay {
-					rowValues = append(rowValues, field.Getter(result))
+		// Print each result
+		for i, result := range results {
+			rowValues := []interface{}{}
+			for _, field := range p.fields {
+				if field.Getter == nil {
+					rowValues = append(rowValues, truncate(snippets[i], field.Width))
+					continue
 				}
-				fmt.Printf(formatStr+"\n", rowValues...)
+				rowValues = append(rowValues, field.Getter(result))
 			}
-		default:
-			// Simple output
-			for i, result := range results {
-				fmt.Printf("Result %d:\n", i+1)
-
-				// Determine which fields to display
-				if len(options.DisplayFields) > 0 {
-					// Display only specified fields
-					for _, field := range options.DisplayFields {
-						field = strings.ToLower(strings.TrimSpace(field))
-						switch field {
-						case "username":
-							fmt.Printf("  Username: %s\n", result.Username)
-						case "email":
-							fmt.Printf("  Email: %s\n", result.Email)
-						case "ip", "ipaddress", "ip_address":
-							fmt.Printf("  IP Address: %s\n", result.IpAddress)
-						case "password":
-							fmt.Printf("  Password: %s\n", result.Password)
-						case "hash", "hashed_password":
-							fmt.Printf("  Hashed Password: %s\n", result.HashedPassword)
-						case "name":
-							fmt.Printf("  Name: %s\n", result.Name)
-						case "vin":
-							fmt.Printf("  VIN: %s\n", result.Vin)
-						case "license", "license_plate":
-							fmt.Printf("  License Plate: %s\n", result.LicensePlate)
-						case "address":
-							fmt.Printf("  Address: %s\n", result.Address)
-						case "phone":
-							fmt.Printf("  Phone: %s\n", result.Phone)
-						case "social":
-							fmt.Printf("  Social: %s\n", result.Social)
-						case "crypto", "cryptocurrency_address":
-							fmt.Printf("  Crypto Address: %s\n", result.CryptoCurrencyAddress)
-						case "domain", "url":
-							fmt.Printf("  Domain/URL: %s\n", result.Url)
-						}
+			fmt.Printf(p.formatStr+"\n", rowValues...)
+			p.printed++
+		}
+	default:
+		// Simple output
+		for i, result := range results {
+			p.printed++
+			fmt.Printf("Result %d:\n", p.printed)
+
+			// Determine which fields to display
+			if len(p.displayFields) > 0 {
+				// Display only specified fields
+				for _, field := range p.displayFields {
+					field = strings.ToLower(strings.TrimSpace(field))
+					switch field {
+					case "username":
+						fmt.Printf("  Username: %s\n", result.Username)
+					case "email":
+						fmt.Printf("  Email: %s\n", result.Email)
+					case "ip", "ipaddress", "ip_address":
+						fmt.Printf("  IP Address: %s\n", result.IpAddress)
+					case "password":
+						fmt.Printf("  Password: %s\n", result.Password)
+					case "hash", "hashed_password":
+						fmt.Printf("  Hashed Password: %s\n", result.HashedPassword)
+					case "name":
+						fmt.Printf("  Name: %s\n", result.Name)
+					case "vin":
+						fmt.Printf("  VIN: %s\n", result.Vin)
+					case "license", "license_plate":
+						fmt.Printf("  License Plate: %s\n", result.LicensePlate)
+					case "address":
+						fmt.Printf("  Address: %s\n", result.Address)
+					case "phone":
+						fmt.Printf("  Phone: %s\n", result.Phone)
+					case "social":
+						fmt.Printf("  Social: %s\n", result.Social)
+					case "crypto", "cryptocurrency_address":
+						fmt.Printf("  Crypto Address: %s\n", result.CryptoCurrencyAddress)
+					case "domain", "url":
+						fmt.Printf("  Domain/URL: %s\n", result.Url)
 					}
-				} else {
-					// Display default fields
-					fmt.Printf("  Username: %s\n", result.Username)
-					fmt.Printf("  Email: %s\n", result.Email)
-					fmt.Printf("  IP Address: %s\n", result.IpAddress)
-					fmt.Printf("  Password: %s\n", result.Password)
-					fmt.Printf("  Hashed Password: %s\n", result.HashedPassword)
-					fmt.Printf("  Name: %s\n", result.Name)
 				}
-				fmt.Println()
+			} else {
+				// Display default fields
+				fmt.Printf("  Username: %s\n", result.Username)
+				fmt.Printf("  Email: %s\n", result.Email)
+				fmt.Printf("  IP Address: %s\n", result.IpAddress)
+				fmt.Printf("  Password: %s\n", result.Password)
+				fmt.Printf("  Hashed Password: %s\n", result.HashedPassword)
+				fmt.Printf("  Name: %s\n", result.Name)
+			}
+			if snippets != nil {
+				fmt.Printf("  Match: %s\n", snippets[i])
 			}
+			fmt.Println()
+		}
+	}
+	return nil
+}
+
+// close ends the output once every batch is printed
+func (p *resultPrinter) close() {
+	if p.format == "json" && p.printed > 0 {
+		fmt.Println("\n]")
+	}
+}
+
+// DB runs command
+var dbRunsCmd = &cobra.Command{
+	Use:   "runs",
+	Short: "List past query runs",
+	Long:  `List past dehasher query runs with their status, progress and the number of stored results linked to each.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runs, err := sqlite.ListRuns(cmd.Context(), limitRunsDB)
+		if err != nil {
+			fmt.Printf("Error listing runs: %v\n", err)
+			return
+		}
+
+		if len(runs) == 0 {
+			fmt.Println("No runs found.")
+			return
+		}
+
+		// Print table header and separator line
+		widths := []int{6, 19, 9, 7, 9, 9, 8, 40}
+		formatStr := "%-6s %-19s %-9s %-7s %-9s %-9s %-8s %s\n"
+		fmt.Printf(formatStr, "ID", "Started", "Status", "Pages", "Retrieved", "Hits", "Balance", "Query")
+		separator := ""
+		for _, width := range widths {
+			separator += strings.Repeat("-", width) + " "
+		}
+		fmt.Println(separator)
+
+		for _, run := range runs {
+			fmt.Printf(formatStr,
+				strconv.FormatUint(uint64(run.ID), 10),
+				run.CreatedAt.Format("2006-01-02 15:04:05"),
+				run.Status,
+				strconv.Itoa(run.PagesCompleted),
+				strconv.Itoa(run.Retrieved),
+				strconv.FormatInt(run.Hits, 10),
+				strconv.Itoa(run.Balance),
+				truncate(run.Query, 60),
+			)
 		}
 	},
 }
 
+// addFilterFlags adds the flags selecting stored results, shared by db query and db export
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&usernameDBQuery, "username", "u", "", "Filter by username")
+	cmd.Flags().StringVarP(&emailDBQuery, "email", "e", "", "Filter by email")
+	cmd.Flags().StringVarP(&ipDBQuery, "ip", "i", "", "Filter by IP address")
+	cmd.Flags().StringVarP(&passwordDBQuery, "password", "p", "", "Filter by password")
+	cmd.Flags().StringVarP(&hashDBQuery, "hash", "H", "", "Filter by hashed password")
+	cmd.Flags().StringVarP(&nameDBQuery, "name", "n", "", "Filter by name")
+	cmd.Flags().StringVarP(&vinDBQuery, "vin", "v", "", "Filter by VIN")
+	cmd.Flags().StringVarP(&licensePlateDBQuery, "license", "L", "", "Filter by license plate")
+	cmd.Flags().StringVarP(&addressDBQuery, "address", "a", "", "Filter by address")
+	cmd.Flags().StringVarP(&phoneDBQuery, "phone", "P", "", "Filter by phone number")
+	cmd.Flags().StringVarP(&socialDBQuery, "social", "s", "", "Filter by social media handle")
+	cmd.Flags().StringVarP(&cryptoCurrencyAddressDBQuery, "crypto", "c", "", "Filter by cryptocurrency address")
+	cmd.Flags().StringVarP(&domainDBQuery, "domain", "d", "", "Filter by domain/URL")
+	cmd.Flags().BoolVarP(&exactMatchDBQuery, "exact", "x", false, "Use exact matching instead of partial matching")
+	cmd.Flags().BoolVar(&prefixMatchDBQuery, "prefix", false, "Match values starting with the filters")
+	cmd.Flags().BoolVar(&suffixMatchDBQuery, "suffix", false, "Match values ending with the filters (e.g., -e @example.com)")
+	cmd.Flags().BoolVar(&globMatchDBQuery, "glob", false, "Match values against glob patterns in the filters (* and ? wildcards, [...] sets)")
+	cmd.Flags().BoolVar(&regexMatchDBQuery, "regex", false, "Match whole values against regular expressions in the filters")
+	cmd.Flags().StringArrayVar(&fieldMatchDBQuery, "match", nil, "Match a single field another way, as field=mode with contains, exact, prefix, suffix, glob or regex (e.g., email=regex); repeatable")
+	cmd.Flags().StringVar(&nonEmptyFieldsDBQuery, "non-empty", "", "Filter for non-empty fields (comma-separated list, e.g., 'password,email')")
+	cmd.Flags().UintSliceVar(&runDBQuery, "run", nil, "Filter by the query runs that returned the results, comma-separated or repeated (see 'db runs')")
+	cmd.Flags().StringVar(&sinceDBQuery, "since", "", "Filter by results seen by a query run since a duration ago (e.g., 72h, 7d) or a date (e.g., 2025-01-31)")
+	cmd.Flags().StringVar(&createdAfterDBQuery, "created-after", "", "Filter by results stored since a duration ago (e.g., 72h, 7d) or a date (e.g., 2025-01-31)")
+	cmd.Flags().StringVar(&createdBeforeDBQuery, "created-before", "", "Filter by results stored before a duration ago (e.g., 72h, 7d) or a date (e.g., 2025-01-31)")
+	cmd.Flags().StringVar(&sortDBQuery, "sort", "", "Sort by fields, each optionally followed by :asc or :desc (e.g., email,created:desc)")
+	cmd.Flags().IntVar(&offsetDBQuery, "offset", 0, "Skip this many results")
+	cmd.MarkFlagsMutuallyExclusive("exact", "prefix", "suffix", "glob", "regex")
+}
+
+// getDBOptions creates DBOptions from the filter flags, see addFilterFlags
+func getDBOptions(limit int) (*sqlite.DBOptions, error) {
+	options := &sqlite.DBOptions{
+		Username:              usernameDBQuery,
+		Email:                 emailDBQuery,
+		IPAddress:             ipDBQuery,
+		Password:              passwordDBQuery,
+		HashedPassword:        hashDBQuery,
+		Name:                  nameDBQuery,
+		Vin:                   vinDBQuery,
+		LicensePlate:          licensePlateDBQuery,
+		Address:               addressDBQuery,
+		Phone:                 phoneDBQuery,
+		Social:                socialDBQuery,
+		CryptoCurrencyAddress: cryptoCurrencyAddressDBQuery,
+		Domain:                domainDBQuery,
+		Limit:                 limit,
+		Offset:                offsetDBQuery,
+		ExactMatch:            exactMatchDBQuery,
+		Match:                 getMatchMode(),
+		RunIDs:                runDBQuery,
+	}
+
+	// Parse non-empty fields if provided
+	if nonEmptyFieldsDBQuery != "" {
+		options.NonEmptyFields = strings.Split(nonEmptyFieldsDBQuery, ",")
+	}
+
+	// Parse since and the created date range if provided
+	var err error
+	if sinceDBQuery != "" {
+		if options.Since, err = parseSince(sinceDBQuery); err != nil {
+			return nil, err
+		}
+	}
+	if createdAfterDBQuery != "" {
+		if options.CreatedAfter, err = parseSince(createdAfterDBQuery); err != nil {
+			return nil, err
+		}
+	}
+	if createdBeforeDBQuery != "" {
+		if options.CreatedBefore, err = parseSince(createdBeforeDBQuery); err != nil {
+			return nil, err
+		}
+	}
+
+	// Parse per-field match modes and the sort order if provided
+	if options.FieldMatch, err = parseFieldMatch(fieldMatchDBQuery); err != nil {
+		return nil, err
+	}
+	if options.Sort, err = sqlite.ParseSort(sortDBQuery); err != nil {
+		return nil, err
+	}
+
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// getMatchMode returns how db query filters match values
+func getMatchMode() sqlite.MatchMode {
+	switch {
+	case exactMatchDBQuery:
+		return sqlite.MatchExact
+	case prefixMatchDBQuery:
+		return sqlite.MatchPrefix
+	case suffixMatchDBQuery:
+		return sqlite.MatchSuffix
+	case globMatchDBQuery:
+		return sqlite.MatchGlob
+	case regexMatchDBQuery:
+		return sqlite.MatchRegex
+	}
+	return sqlite.MatchContains
+}
+
+// parseFieldMatch parses field=mode pairs into the match mode of each field
+func parseFieldMatch(pairs []string) (map[string]sqlite.MatchMode, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	fieldMatch := make(map[string]sqlite.MatchMode, len(pairs))
+	for _, pair := range pairs {
+		field, name, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(field) == "" {
+			return nil, fmt.Errorf("invalid match %q, expected field=mode (e.g., email=regex)", pair)
+		}
+		mode, err := sqlite.ParseMatchMode(name)
+		if err != nil {
+			return nil, err
+		}
+		fieldMatch[field] = mode
+	}
+	return fieldMatch, nil
+}
+
+// parseSince parses a point in time given as a duration ago (e.g., 72h, 7d) or as a date
+func parseSince(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return time.Now().AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected a duration (e.g., 72h, 7d) or a date (e.g., 2025-01-31)", value)
+}
+
 // truncate truncates a string to the specified length and adds ellipsis if needed
 func truncate(s string, length int) string {
 	if len(s) <= length {
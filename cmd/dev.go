@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"Dehash/internal/mock"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+var (
+	// Mock server command flags
+	mockAddr         string
+	mockFixtures     string
+	mockBalance      int
+	mockWhoisCredits int
+	mockGenerate     int
+	mockFaults       []string
+	mockRetryAfter   time.Duration
+	mockLatency      time.Duration
+
+	// Dev command
+	devCmd = &cobra.Command{
+		Use:   "dev",
+		Short: "Development tools",
+		Long:  `Tools for developing and testing dehasher without access to the Dehashed API.`,
+	}
+
+	// Mock server command
+	devMockServerCmd = &cobra.Command{
+		Use:   "mock-server",
+		Short: "Serve a fake Dehashed API from fixture files",
+		Long: `Serve /v2/search, /v2/whois/search and /v2/whois/credits from fixture files, with
+pagination, balance tracking, API key checks and injected failures.
+
+Fixtures are read from search.json (a JSON array of entries) and whois.json (responses
+keyed by search type) in the --fixtures directory; built in fixtures are used for files
+that do not exist. Point the CLI at the server with --api-url, e.g.
+
+  dehasher dev mock-server --generate 25000 --fault 429:2
+  dehasher query --api-url http://127.0.0.1:8089 -k mock-api-key -e mock@example.com -E @generated.example`,
+		Run: func(cmd *cobra.Command, args []string) {
+			var faults []mock.Fault
+			for _, spec := range mockFaults {
+				fault, err := mock.ParseFault(spec)
+				if err != nil {
+					exitWithError(err)
+				}
+				faults = append(faults, fault)
+			}
+
+			fixtures, err := mock.LoadFixtures(mockFixtures)
+			if err != nil {
+				exitWithError(err)
+			}
+			fixtures.Generate(mockGenerate)
+
+			key := apiKey
+			if key == "" {
+				key = mock.DefaultAPIKey
+			}
+			server := mock.NewServer(mock.Config{
+				APIKey:       key,
+				Balance:      mockBalance,
+				WhoisCredits: mockWhoisCredits,
+				Faults:       faults,
+				RetryAfter:   mockRetryAfter,
+				Latency:      mockLatency,
+				Log:          os.Stdout,
+			}, fixtures)
+
+			listener, err := net.Listen("tcp", mockAddr)
+			if err != nil {
+				exitWithError(fmt.Errorf("failed to listen on %s: %w", mockAddr, err))
+			}
+			httpServer := &http.Server{Handler: server, ReadHeaderTimeout: 10 * time.Second}
+
+			// Shut down cleanly on Ctrl-C
+			ctx := cmd.Context()
+			go func() {
+				<-ctx.Done()
+				shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+				defer cancel()
+				httpServer.Shutdown(shutdownCtx)
+			}()
+
+			fmt.Printf("[*] Mock Dehashed API listening on http://%s\n", listener.Addr())
+			fmt.Printf("[*] %d Entries, %d Credits, %d WHOIS Credits, API key %q\n", len(fixtures.Entries), mockBalance, mockWhoisCredits, key)
+			fmt.Printf("[*] Use it with: dehasher --api-url http://%s -k %s ...\n", listener.Addr(), key)
+			if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				zap.L().Error("mock_server",
+					zap.String("message", "mock server failed"),
+					zap.Error(err),
+				)
+				exitWithError(err)
+			}
+			fmt.Println("\n[*] Mock server stopped")
+		},
+	}
+)
+
+func init() {
+	devCmd.AddCommand(devMockServerCmd)
+
+	devMockServerCmd.Flags().StringVar(&mockAddr, "addr", "127.0.0.1:8089", "Address to listen on")
+	devMockServerCmd.Flags().StringVar(&mockFixtures, "fixtures", "", "Directory containing search.json and whois.json fixtures")
+	devMockServerCmd.Flags().IntVar(&mockBalance, "balance", 1000, "Search credits available, one is spent per search")
+	devMockServerCmd.Flags().IntVar(&mockWhoisCredits, "whois-credits", 100, "WHOIS credits available, one is spent per WHOIS search")
+	devMockServerCmd.Flags().IntVar(&mockGenerate, "generate", 0, "Number of synthetic entries to add to the search fixtures")
+	devMockServerCmd.Flags().StringArrayVar(&mockFaults, "fault", nil, "Fail the next requests with a status, as status or status:count (e.g., 429:2); repeatable, applied in order")
+	devMockServerCmd.Flags().DurationVar(&mockRetryAfter, "retry-after", 0, "Retry-After delay sent with 429 faults")
+	devMockServerCmd.Flags().DurationVar(&mockLatency, "latency", 0, "Delay added to every response")
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"Dehash/internal/cassette"
+	"Dehash/internal/query"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// Exit codes returned by commands so scripts can react to the kind of failure
+const (
+	exitGeneral     = 1
+	exitAuth        = 2
+	exitCredits     = 3
+	exitRateLimited = 4
+	exitNetwork     = 5
+	exitInterrupted = 130
+)
+
+// errMissingCredentials is returned when no API key and email are set, it exits as an authentication failure
+var errMissingCredentials = fmt.Errorf("%w: API key and email are required, use the --key and --email flags or set them with the set-key and set-email commands", query.ErrUnauthorized)
+
+// exitCode maps an error returned by the query pipeline to a process exit code
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	case errors.Is(err, query.ErrUnauthorized):
+		return exitAuth
+	case errors.Is(err, query.ErrInsufficientCredits), errors.Is(err, query.ErrBudgetExceeded):
+		return exitCredits
+	case errors.Is(err, query.ErrRateLimited):
+		return exitRateLimited
+	case errors.Is(err, cassette.ErrNoInteraction):
+		return exitGeneral
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return exitNetwork
+	}
+
+	return exitGeneral
+}
+
+// exitWithError prints the error and exits with the code matching its kind
+func exitWithError(err error) {
+	fmt.Printf("\n[!] %v\n", err)
+	os.Exit(exitCode(err))
+}
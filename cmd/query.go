@@ -2,10 +2,18 @@ package cmd
 
 import (
 	"Dehash/internal/badger"
+	"Dehash/internal/cassette"
 	"Dehash/internal/query"
 	"Dehash/internal/sqlite"
 	"fmt"
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var (
@@ -32,31 +40,49 @@ var (
 	phoneQuery                 string
 	socialQuery                string
 	cryptoCurrencyAddressQuery string
+	maxRetries                 int
+	retryWait                  time.Duration
+	retryMaxWait               time.Duration
+	dryRun                     bool
+	budget                     int
+	inputFile                  string
+	inputField                 string
+	inputFormat                string
+	inputColumns               string
+	splitOutput                bool
+	concurrency                int
+	rateLimit                  float64
+	queryExpression            string
+	noCache                    bool
+	refreshCache               bool
+	cacheTTL                   time.Duration
+	offline                    bool
+	preferLocal                bool
+	maxLocalAge                string
 
 	// Query command
 	queryCmd = &cobra.Command{
 		Use:   "query",
 		Short: "Query the Dehashed API",
-		Long:  `Query the Dehashed API for emails, usernames, passwords, hashes, IP addresses, and names.`,
-		Run: func(cmd *cobra.Command, args []string) {
-			// Check if API key and email are provided
-			key := apiKey
-			email := apiEmail
+		Long: `Query the Dehashed API for emails, usernames, passwords, hashes, IP addresses, and names.
 
-			// If not provided as flags, try to get from stored values
-			if key == "" {
-				key = getStoredApiKey()
-			}
-			if email == "" {
-				email = getStoredApiEmail()
-			}
+Instead of the field flags, --query accepts a boolean expression of field:value terms
+combined with AND, OR, NOT and parentheses, e.g.
 
-			// Validate credentials
-			if key == "" || email == "" {
-				fmt.Println("API key and email are required. Use --key and --email flags or set them with set-key and set-email commands.")
-				return
-			}
+  email:"@corp.com" AND (username:admin OR username:root) AND NOT domain:test.corp.com
 
+Values are exact when plain or quoted, wildcards when they contain * or ?, and regular
+expressions when written as /pattern/. A query uses a single match mode.
+
+Exit codes:
+  1    general failure
+  2    authentication failure
+  3    insufficient credits or credit budget reached
+  4    rate limited
+  5    network failure
+  130  interrupted (partial results were saved)`,
+		PersistentPreRunE: setupHTTPClient,
+		Run: func(cmd *cobra.Command, args []string) {
 			// Create new QueryOptions
 			queryOptions := sqlite.NewQueryOptions(
 				maxRecords,
@@ -82,21 +108,123 @@ var (
 				printBalance,
 				credsOnly,
 			)
+			queryOptions.Budget = budget
+			queryOptions.Expression = queryExpression
+			if queryExpression != "" {
+				if err := checkExpressionFlags(cmd); err != nil {
+					exitWithError(err)
+				}
+			}
+
+			// Run every target of the input as a batch
+			if inputFile != "" {
+				runBatch(cmd, queryOptions)
+				return
+			}
 
 			// Create new Dehasher
-			dehasher := query.NewDehasher(queryOptions)
-			dehasher.SetClientCredentials(
-				key,
-			)
+			dehasher, err := query.NewDehasher(queryOptions)
+			if err != nil {
+				exitWithError(err)
+			}
+
+			dehasher.SetCachePolicy(getCachePolicy())
+			if dryRun {
+				if err := dehasher.DryRun(); err != nil {
+					exitWithError(err)
+				}
+				return
+			}
+
+			localPolicy, err := getLocalPolicy()
+			if err != nil {
+				exitWithError(err)
+			}
+			dehasher.SetLocalPolicy(localPolicy)
+			if !offline {
+				key, err := getQueryCredentials()
+				if err != nil {
+					exitWithError(err)
+				}
+				dehasher.SetClientCredentials(
+					key,
+				)
+			}
+			dehasher.SetRetryPolicy(query.NewRetryPolicy(maxRetries, retryWait, retryMaxWait))
+			dehasher.SetRateLimiter(query.NewRateLimiter(rateLimit, concurrency))
+			dehasher.SetConcurrency(concurrency)
 
 			// Start querying
-			dehasher.Start()
+			if err := dehasher.Start(cmd.Context()); err != nil {
+				zap.L().Error("query",
+					zap.String("message", "query failed"),
+					zap.Error(err),
+				)
+				exitWithError(err)
+			}
+			fmt.Println("\n[*] Completing Process")
+		},
+	}
+
+	// Query resume command
+	queryResumeCmd = &cobra.Command{
+		Use:   "resume [run-id]",
+		Short: "Resume an interrupted or failed query run",
+		Long:  `Resume a previous query run from its last successful page, merging the new results into the same output file.`,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runID, err := strconv.ParseUint(args[0], 10, 0)
+			if err != nil {
+				exitWithError(fmt.Errorf("invalid run id %q: %w", args[0], err))
+			}
+
+			run, err := sqlite.GetRun(cmd.Context(), uint(runID))
+			if err != nil {
+				exitWithError(err)
+			}
+			if cmd.Flags().Changed("budget") {
+				run.QueryOptions.Budget = budget
+			}
+
+			dehasher, err := query.ResumeDehasher(run)
+			if err != nil {
+				exitWithError(err)
+			}
+
+			dehasher.SetCachePolicy(getCachePolicy())
+			if dryRun {
+				if err := dehasher.DryRun(); err != nil {
+					exitWithError(err)
+				}
+				return
+			}
+
+			key, err := getQueryCredentials()
+			if err != nil {
+				exitWithError(err)
+			}
+			dehasher.SetClientCredentials(key)
+			dehasher.SetRetryPolicy(query.NewRetryPolicy(maxRetries, retryWait, retryMaxWait))
+			dehasher.SetRateLimiter(query.NewRateLimiter(rateLimit, concurrency))
+			dehasher.SetConcurrency(concurrency)
+
+			if err := dehasher.Start(cmd.Context()); err != nil {
+				zap.L().Error("query_resume",
+					zap.String("message", "resumed query failed"),
+					zap.Uint("run_id", run.ID),
+					zap.Error(err),
+				)
+				exitWithError(err)
+			}
 			fmt.Println("\n[*] Completing Process")
 		},
 	}
 )
 
 func init() {
+	// Add subcommands to query command
+	queryCmd.AddCommand(queryResumeCmd)
+
 	// Add flags specific to query command
 	queryCmd.Flags().IntVarP(&maxRecords, "max-records", "m", 30000, "Maximum amount of records to return")
 	queryCmd.Flags().IntVarP(&maxRequests, "max-requests", "r", -1, "Maximum number of requests to make")
@@ -120,9 +248,180 @@ func init() {
 	queryCmd.Flags().StringVarP(&cryptoCurrencyAddressQuery, "crypto", "B", "", "Crypto currency address query")
 	queryCmd.Flags().StringVarP(&hashQuery, "hash", "Q", "", "Hashed password query")
 	queryCmd.Flags().StringVarP(&nameQuery, "name", "N", "", "Name query")
+	queryCmd.Flags().StringVarP(&queryExpression, "query", "q", "", "Boolean query expression (e.g., 'email:@corp.com AND (username:admin OR username:root)')")
+	queryCmd.Flags().StringVar(&inputFile, "input", "", "File of targets to query one by one, or - for stdin")
+	queryCmd.Flags().StringVar(&inputField, "input-field", "email", "Field for input lines that do not name one as field:value")
+	queryCmd.Flags().StringVar(&inputFormat, "input-format", "", "Input format (lines, csv); detected from the file extension when empty")
+	queryCmd.Flags().StringVar(&inputColumns, "columns", "", "CSV column to field mapping by header name or index (e.g., 'Mail=email,2=username'); header names are used when empty")
+	queryCmd.Flags().BoolVar(&offline, "offline", false, "Answer from results stored by earlier runs only, never calling the API")
+	queryCmd.Flags().BoolVar(&preferLocal, "prefer-local", false, "Answer from results stored by earlier runs, calling the API only when none match")
+	queryCmd.Flags().StringVar(&maxLocalAge, "max-age", "", "Only use stored results a run has seen since a duration ago (e.g., 72h, 7d) or a date (e.g., 2025-01-31)")
+	queryCmd.Flags().BoolVar(&splitOutput, "split-output", false, "Write a separate output file for every input target")
+	queryCmd.PersistentFlags().IntVar(&maxRetries, "retries", query.DefaultMaxRetries, "Maximum number of retries for rate limited or failed requests")
+	queryCmd.PersistentFlags().DurationVar(&retryWait, "retry-wait", query.DefaultRetryBaseDelay, "Initial backoff delay between retries (doubled on each retry)")
+	queryCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Print the requests that would be sent and their estimated credit cost without sending them")
+	queryCmd.PersistentFlags().IntVar(&budget, "budget", 0, "Stop before the remaining balance drops below this many credits, checked before every request (0 disables)")
+	queryCmd.PersistentFlags().DurationVar(&retryMaxWait, "retry-max-wait", query.DefaultRetryMaxDelay, "Maximum backoff delay between retries; a longer Retry-After from the API fails the request")
+	queryCmd.PersistentFlags().IntVar(&concurrency, "concurrency", 1, "Number of pages, or batch targets, requested at the same time")
+	queryCmd.PersistentFlags().Float64Var(&rateLimit, "rate", 0, "Maximum requests per second shared by all workers (0 disables)")
+	queryCmd.PersistentFlags().BoolVar(&noCache, "no-cache", false, "Neither read nor store cached responses")
+	queryCmd.PersistentFlags().BoolVar(&refreshCache, "refresh", false, "Ignore cached responses and replace them with new ones")
+	queryCmd.PersistentFlags().DurationVar(&cacheTTL, "cache-ttl", query.DefaultCacheTTL, "How long cached responses are reused")
+	queryCmd.MarkFlagsMutuallyExclusive("no-cache", "refresh")
 
 	// Add mutually exclusive flags to exact match and regex match
 	queryCmd.MarkFlagsMutuallyExclusive("regex-match", "wildcard-match")
+	queryCmd.MarkFlagsMutuallyExclusive("offline", "prefer-local")
+}
+
+// getCachePolicy returns the response cache policy of the cache flags. Recording and
+// replaying always reach the API so cassettes hold every response.
+func getCachePolicy() *query.CachePolicy {
+	if noCache || recordTo != "" || replayOf != "" {
+		return nil
+	}
+	return query.NewCachePolicy(cacheTTL, refreshCache)
+}
+
+// getLocalPolicy returns the local database policy of the --offline, --prefer-local and
+// --max-age flags, or nil when the API is always queried
+func getLocalPolicy() (*query.LocalPolicy, error) {
+	if !offline && !preferLocal {
+		return nil, nil
+	}
+
+	var maxAge time.Duration
+	if maxLocalAge != "" {
+		since, err := parseSince(maxLocalAge)
+		if err != nil {
+			return nil, err
+		}
+		maxAge = time.Since(since)
+	}
+	return query.NewLocalPolicy(offline, maxAge), nil
+}
+
+// checkExpressionFlags rejects flags that cannot be combined with a query expression
+func checkExpressionFlags(cmd *cobra.Command) error {
+	for _, name := range []string{"username", "email-query", "ip", "domain", "password", "vin", "license", "address", "phone", "social", "crypto", "hash", "name", "regex-match", "wildcard-match", "input"} {
+		if cmd.Flags().Changed(name) {
+			return fmt.Errorf("--query cannot be combined with --%s, write it as part of the expression instead", name)
+		}
+	}
+	return nil
+}
+
+// runBatch queries every target of the input file using options as the template
+func runBatch(cmd *cobra.Command, options *sqlite.QueryOptions) {
+	targets, err := readBatchTargets()
+	if err != nil {
+		exitWithError(err)
+	}
+	if len(targets) == 0 {
+		fmt.Println("No targets found in input.")
+		return
+	}
+	fmt.Printf("[*] Loaded %d Targets\n", len(targets))
+
+	batch := query.NewBatch(options, targets, splitOutput)
+	batch.SetCachePolicy(getCachePolicy())
+	if dryRun {
+		if err := batch.DryRun(); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+
+	localPolicy, err := getLocalPolicy()
+	if err != nil {
+		exitWithError(err)
+	}
+	batch.SetLocalPolicy(localPolicy)
+	if !offline {
+		key, err := getQueryCredentials()
+		if err != nil {
+			exitWithError(err)
+		}
+		batch.SetClientCredentials(key)
+	}
+	batch.SetRetryPolicy(query.NewRetryPolicy(maxRetries, retryWait, retryMaxWait))
+	batch.SetRateLimiter(query.NewRateLimiter(rateLimit, concurrency))
+	batch.SetConcurrency(concurrency)
+
+	if err := batch.Start(cmd.Context()); err != nil {
+		zap.L().Error("query_batch",
+			zap.String("message", "batch query failed"),
+			zap.Error(err),
+		)
+		exitWithError(err)
+	}
+	fmt.Println("\n[*] Completing Process")
+}
+
+// readBatchTargets reads the targets from the input file or stdin
+func readBatchTargets() ([]query.Target, error) {
+	var r io.Reader = os.Stdin
+	if inputFile != "-" {
+		f, err := os.Open(inputFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open input: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	format := strings.ToLower(inputFormat)
+	if format == "" {
+		format = "lines"
+		if strings.EqualFold(filepath.Ext(inputFile), ".csv") {
+			format = "csv"
+		}
+	}
+
+	switch format {
+	case "lines", "txt":
+		return query.ReadTargets(r, inputField)
+	case "csv":
+		columns := make(map[string]string)
+		if inputColumns != "" {
+			for _, pair := range strings.Split(inputColumns, ",") {
+				column, field, ok := strings.Cut(pair, "=")
+				if !ok {
+					return nil, fmt.Errorf("invalid column mapping %q, expected column=field", pair)
+				}
+				columns[strings.TrimSpace(column)] = strings.TrimSpace(field)
+			}
+		}
+		return query.ReadCSVTargets(r, columns)
+	default:
+		return nil, fmt.Errorf("unsupported input format %q, expected lines or csv", inputFormat)
+	}
+}
+
+// getQueryCredentials returns the API key from flags or the keystore, or errMissingCredentials
+func getQueryCredentials() (string, error) {
+	// Check if API key and email are provided
+	key := apiKey
+	email := apiEmail
+
+	// If not provided as flags, try to get from stored values
+	if key == "" {
+		key = getStoredApiKey()
+	}
+	if email == "" {
+		email = getStoredApiEmail()
+	}
+
+	// Replayed responses do not need real credentials
+	if replayOf != "" && key == "" {
+		return cassette.RedactedKey, nil
+	}
+
+	// Validate credentials
+	if key == "" || email == "" {
+		return "", errMissingCredentials
+	}
+	return key, nil
 }
 
 // Helper functions to get stored API credentials
@@ -2,16 +2,38 @@ package cmd
 
 import (
 	"Dehash/internal/badger"
+	"Dehash/internal/cassette"
+	"Dehash/internal/query"
+	"Dehash/pkg/dehashed"
+	"context"
 	"fmt"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 var (
 	// Global Flags
 	apiKey   string
 	apiEmail string
+	apiURL   string
+	recordTo string
+	replayOf string
+
+	// Global HTTP flags, defaulting to the config keys of the same name
+	proxyURL    string
+	httpTimeout time.Duration
+	caCertFile  string
+	insecureTLS bool
+	userAgent   string
+
+	// apiHTTPClient is the HTTP client API requests are sent with
+	apiHTTPClient = http.DefaultClient
 
 	// rootCmd is the base command for the CLI.
 	rootCmd = &cobra.Command{
@@ -51,12 +73,24 @@ An Ar1ste1a Project
 `,
 		),
 		Version: "v1.0",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return setAPIURL(apiURL)
+		},
 	}
 )
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	// Cancel the command context on the first interrupt so long running commands can
+	// persist what they have; a second interrupt terminates immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		zap.L().Fatal("execute_root_command",
 			zap.String("message", "failed to execute root command"),
 			zap.Error(err),
@@ -73,12 +107,119 @@ func init() {
 	// Add global flags for API key and email
 	rootCmd.PersistentFlags().StringVarP(&apiKey, "key", "k", "", "API Key for authentication")
 	rootCmd.PersistentFlags().StringVarP(&apiEmail, "email", "e", "", "Email to pair with API key for authentication")
+	rootCmd.PersistentFlags().StringVar(&apiURL, "api-url", defaultAPIURL(), "Base URL of the Dehashed API, e.g. a mock server (env DEHASHER_API_URL)")
+	rootCmd.PersistentFlags().StringVar(&recordTo, "record", "", "Record every API request and response, with the API key redacted, to a cassette in this directory")
+	rootCmd.PersistentFlags().StringVar(&replayOf, "replay", "", "Answer API requests from the cassette in this directory instead of the network")
+	rootCmd.MarkFlagsMutuallyExclusive("record", "replay")
+	rootCmd.PersistentFlags().StringVar(&proxyURL, "proxy", "", "Proxy for API requests, e.g. http://127.0.0.1:8080 or socks5://127.0.0.1:1080 (config key proxy)")
+	rootCmd.PersistentFlags().DurationVar(&httpTimeout, "timeout", DefaultHTTPTimeout, "Time to wait for the API to answer a request, not counting the download of the response (config key timeout)")
+	rootCmd.PersistentFlags().StringVar(&caCertFile, "ca-cert", "", "PEM file of an additional trusted CA, e.g. of an intercepting proxy (config key ca_cert)")
+	rootCmd.PersistentFlags().BoolVar(&insecureTLS, "insecure", false, "Skip TLS certificate verification (config key insecure)")
+	rootCmd.PersistentFlags().StringVar(&userAgent, "user-agent", DefaultUserAgent, "User-Agent header sent with API requests (config key user_agent)")
+
+	// Run the pre-run hooks of every parent, so API commands can add their own
+	cobra.EnableTraverseRunHooks = true
 
 	// Add subcommands
 	rootCmd.AddCommand(dbCmd)
 	rootCmd.AddCommand(queryCmd)
 	rootCmd.AddCommand(setKeyCmd)
 	rootCmd.AddCommand(setEmailCmd)
+	rootCmd.AddCommand(devCmd)
+	rootCmd.AddCommand(cacheCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+// defaultAPIURL returns the API base URL from the environment, or the Dehashed API
+func defaultAPIURL() string {
+	if env := os.Getenv("DEHASHER_API_URL"); env != "" {
+		return env
+	}
+	return dehashed.DefaultBaseURL
+}
+
+// setAPIURL validates the API base URL and points the query and WHOIS clients at it
+func setAPIURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid API URL %q, expected http(s)://host[:port]", rawURL)
+	}
+	query.SetBaseURL(rawURL)
+	return nil
+}
+
+// newAPIClient creates a client for the configured API address and HTTP client
+func newAPIClient(key string) *dehashed.Client {
+	return dehashed.New(
+		dehashed.WithAPIKey(key),
+		dehashed.WithBaseURL(apiURL),
+		dehashed.WithHTTPClient(apiHTTPClient),
+		dehashed.WithLogger(zap.L()),
+	)
+}
+
+// setupHTTPClient builds the HTTP client shared by every API request from the HTTP flags
+// and config keys, routed through a cassette when recording or replaying. Only commands
+// calling the API run it.
+func setupHTTPClient(cmd *cobra.Command, args []string) error {
+	config, err := getHTTPConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	transport, err := dehashed.NewTransport(config)
+	if err != nil {
+		return err
+	}
+	switch {
+	case recordTo != "":
+		recorder, err := cassette.NewRecorder(recordTo, transport)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("[*] Recording API responses to %s\n", recordTo)
+		transport = recorder
+	case replayOf != "":
+		player, err := cassette.NewPlayer(replayOf)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("[*] Replaying API responses from %s\n", replayOf)
+		transport = player
+	}
+	if config.Proxy != "" {
+		fmt.Printf("[*] Sending API requests through %s\n", config.Proxy)
+	}
+
+	apiHTTPClient = &http.Client{Transport: transport}
+	query.SetHTTPClient(apiHTTPClient)
+	return nil
+}
+
+// getHTTPConfig resolves the HTTP settings, a flag given on the command line taking
+// precedence over its config key and the config key over the flag default
+func getHTTPConfig(cmd *cobra.Command) (dehashed.HTTPConfig, error) {
+	config := dehashed.HTTPConfig{
+		Proxy:      proxyURL,
+		Timeout:    httpTimeout,
+		CACertFile: caCertFile,
+		Insecure:   insecureTLS,
+		UserAgent:  userAgent,
+	}
+
+	for _, key := range configKeys {
+		if cmd.Flags().Changed(key.Flag) {
+			continue
+		}
+		value := badger.GetConfig(key.Name)
+		if value == "" {
+			continue
+		}
+		if err := key.apply(&config, value); err != nil {
+			return config, fmt.Errorf("invalid config key %s: %w", key.Name, err)
+		}
+	}
+	return config, nil
 }
 
 // Command to set API key
@@ -1,7 +1,7 @@
 package cmd
 
 import (
-	"Dehash/internal/whois"
+	"Dehash/internal/cassette"
 	"fmt"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
@@ -22,10 +22,13 @@ var (
 
 	// WHOIS command
 	whoisCmd = &cobra.Command{
-		Use:   "whois",
-		Short: "Dehashed WHOIS lookups and reverse WHOIS searches",
-		Long:  `Perform WHOIS lookups, history searches, reverse WHOIS searches, IP lookups, MX lookups, NS lookups, and subdomain scans.`,
+		Use:     "whois",
+		Short:   "Dehashed WHOIS lookups and reverse WHOIS searches",
+		Long:    `Perform WHOIS lookups, history searches, reverse WHOIS searches, IP lookups, MX lookups, NS lookups, and subdomain scans.`,
+		PreRunE: setupHTTPClient,
 		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+
 			// Check if API key is provided
 			key := apiKey
 
@@ -34,15 +37,22 @@ var (
 				key = getStoredApiKey()
 			}
 
+			// Replayed responses do not need real credentials
+			if replayOf != "" && key == "" {
+				key = cassette.RedactedKey
+			}
+
 			// Validate credentials
 			if key == "" {
 				fmt.Println("API key is required. Use --key flag or set it with set-key command.")
 				return
 			}
 
+			client := newAPIClient(key)
+
 			// Show credits if requested
 			if whoisShowCredits {
-				credits, err := whois.GetWHOISCredits(key)
+				credits, err := client.WhoisCredits(ctx)
 				if err != nil {
 					zap.L().Error("get_whois_credits",
 						zap.String("message", "failed to get whois credits"),
@@ -59,7 +69,7 @@ var (
 			// Determine which operation to perform based on flags
 			if whoisDomain != "" {
 				// Domain lookup
-				result, err := whois.WhoisSearch(whoisDomain, key)
+				result, err := client.Whois(ctx, whoisDomain)
 				if err != nil {
 					zap.L().Error("whois_search",
 						zap.String("message", "failed to perform whois search"),
@@ -72,7 +82,7 @@ var (
 				fmt.Println(result)
 
 				// Also perform history search
-				history, err := whois.WhoisHistory(whoisDomain, key)
+				history, err := client.WhoisHistory(ctx, whoisDomain)
 				if err != nil {
 					zap.L().Error("whois_history",
 						zap.String("message", "failed to perform whois history lookup"),
@@ -85,7 +95,7 @@ var (
 				}
 
 				// Also perform subdomain scan
-				subdomains, err := whois.WhoisSubdomainScan(whoisDomain, key)
+				subdomains, err := client.WhoisSubdomainScan(ctx, whoisDomain)
 				if err != nil {
 					zap.L().Error("whois_subdomain_scan",
 						zap.String("message", "failed to perform subdomain scan"),
@@ -101,7 +111,7 @@ var (
 
 			if whoisIPAddress != "" {
 				// IP lookup
-				result, err := whois.WhoisIP(whoisIPAddress, key)
+				result, err := client.WhoisIP(ctx, whoisIPAddress)
 				if err != nil {
 					zap.L().Error("whois_ip",
 						zap.String("message", "failed to perform ip lookup"),
@@ -111,13 +121,13 @@ var (
 					return
 				}
 				fmt.Println("IP Lookup Result:")
-				fmt.Println(string(result))
+				fmt.Println(result)
 				return
 			}
 
 			if whoisMXAddress != "" {
 				// MX lookup
-				result, err := whois.WhoisMX(whoisMXAddress, key)
+				result, err := client.WhoisMX(ctx, whoisMXAddress)
 				if err != nil {
 					zap.L().Error("whois_mx",
 						zap.String("message", "failed to perform mx lookup"),
@@ -133,7 +143,7 @@ var (
 
 			if whoisNSAddress != "" {
 				// NS lookup
-				result, err := whois.WhoisNS(whoisNSAddress, key)
+				result, err := client.WhoisNS(ctx, whoisNSAddress)
 				if err != nil {
 					zap.L().Error("whois_ns",
 						zap.String("message", "failed to perform ns lookup"),
@@ -163,7 +173,7 @@ var (
 					whoisReverseType = "registrant"
 				}
 
-				result, err := whois.ReverseWHOIS(includeTerms, excludeTerms, whoisReverseType, key)
+				result, err := client.ReverseWhois(ctx, includeTerms, excludeTerms, whoisReverseType)
 				if err != nil {
 					fmt.Printf("Error performing reverse WHOIS: %v\n", err)
 					return
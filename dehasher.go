@@ -86,8 +86,8 @@ func main() {
 	}
 
 	zap.L().Info("starting_badger")
-	db := badger.Start(storePath)
-	defer db.Close()
+	badger.Start(storePath)
+	defer badger.Close()
 
 	zap.L().Info("executing_command")
 	cmd.Execute()
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildDehasher builds the CLI into a temporary directory
+func buildDehasher(t *testing.T) string {
+	t.Helper()
+	bin := filepath.Join(t.TempDir(), "dehasher")
+	out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput()
+	if err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	return bin
+}
+
+// startMockServer runs dev mock-server on a free port and returns its URL, the server
+// is stopped when the test ends
+func startMockServer(t *testing.T, bin string, env []string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command(bin, append([]string{"dev", "mock-server", "--addr", "127.0.0.1:0"}, args...)...)
+	cmd.Env = env
+	cmd.Dir = t.TempDir()
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start mock server: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Signal(os.Interrupt)
+		done := make(chan struct{})
+		go func() {
+			cmd.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			cmd.Process.Kill()
+		}
+	})
+
+	urls := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			if _, url, ok := strings.Cut(scanner.Text(), "listening on "); ok {
+				urls <- url
+				break
+			}
+		}
+		// Keep draining so the server never blocks on a full pipe
+		for scanner.Scan() {
+		}
+	}()
+	select {
+	case url := <-urls:
+		return url
+	case <-time.After(30 * time.Second):
+		t.Fatal("mock server did not start")
+		return ""
+	}
+}
+
+// TestQueryAgainstMockServer runs a query while the mock server is running under the same
+// HOME, so both processes share the keystore directory as they do on a developer machine
+func TestQueryAgainstMockServer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs the CLI")
+	}
+
+	bin := buildDehasher(t)
+	env := append(os.Environ(), "HOME="+t.TempDir())
+	url := startMockServer(t, bin, env, "--generate", "30")
+
+	workDir := t.TempDir()
+	cmd := exec.Command(bin, "query", "--api-url", url, "-k", "mock-api-key", "-e", "mock@example.com", "-E", "@generated.example", "-o", "results")
+	cmd.Env = env
+	cmd.Dir = workDir
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("query: %v\n%s", err, out.String())
+	}
+
+	if !strings.Contains(out.String(), "Page 1: Retrieved 30 Records") {
+		t.Errorf("query output does not report the page:\n%s", out.String())
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "results.json")); err != nil {
+		t.Errorf("query did not write its output: %v", err)
+	}
+}
+
+// TestDBCommandErrorsExit checks that the local database commands report invalid input
+// with a non-zero exit code instead of printing the error and exiting 0
+func TestDBCommandErrorsExit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs the CLI")
+	}
+
+	bin := buildDehasher(t)
+	env := append(os.Environ(), "HOME="+t.TempDir())
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+		wantOut  string
+	}{
+		{name: "query without filters", args: []string{"db", "query"}, wantCode: 1, wantOut: "at least one search parameter is required"},
+		{name: "query with a file format", args: []string{"db", "query", "-u", "admin", "--format", "yaml"}, wantCode: 1, wantOut: `unsupported output format "yaml"`},
+		{name: "query with an unknown format", args: []string{"db", "query", "-u", "admin", "--format", "csv"}, wantCode: 1, wantOut: `unsupported output format "csv"`},
+		{name: "query with json", args: []string{"db", "query", "-u", "admin", "--format", "JSON"}, wantCode: 0, wantOut: "No results found."},
+		{name: "query with an invalid regex", args: []string{"db", "query", "-u", "adm(", "--regex"}, wantCode: 1, wantOut: "invalid regex"},
+		{name: "search with a file format", args: []string{"db", "search", "admin", "--format", "xml"}, wantCode: 1, wantOut: `unsupported output format "xml"`},
+		{name: "export with an unknown format", args: []string{"db", "export", "-u", "admin", "--format", "csv"}, wantCode: 1, wantOut: `unsupported file format "csv"`},
+		{name: "export of everything with filters", args: []string{"db", "export", "--all", "-u", "admin"}, wantCode: 1, wantOut: "--all cannot be combined with filters"},
+		{name: "export without filters", args: []string{"db", "export"}, wantCode: 1, wantOut: "at least one search parameter is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := exec.Command(bin, tt.args...)
+			cmd.Env = env
+			cmd.Dir = t.TempDir()
+			out, err := cmd.CombinedOutput()
+			code := 0
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				code = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d\n%s", code, tt.wantCode, out)
+			}
+			if !strings.Contains(string(out), tt.wantOut) {
+				t.Errorf("output does not contain %q:\n%s", tt.wantOut, out)
+			}
+		})
+	}
+}
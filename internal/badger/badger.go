@@ -2,6 +2,7 @@ package badger
 
 import (
 	"crypto/sha256"
+	"errors"
 	"github.com/dgraph-io/badger/v4"
 	"go.uber.org/zap"
 	"log"
@@ -52,18 +53,23 @@ func GetHardwareEntropy() []byte {
 	return sum[:]
 }
 
-func Start(dirPath string) *badger.DB {
-	var err error
-
+// Start sets the directory of the keystore. The database is opened on first use, so
+// commands that never read the keystore do not hold its lock.
+func Start(dirPath string) {
 	zap.L().Info("Starting Badger DB", zap.String("directory", dirPath))
 	zap.L().Info("Badger DB Directory Path", zap.String("directory", dirPath))
 
-	once.Do(func() {
-		if !strings.HasSuffix(dirPath, "db") {
-			dirPath = filepath.Join(dirPath, "db")
-		}
-		rootDir = dirPath
+	if !strings.HasSuffix(dirPath, "db") {
+		dirPath = filepath.Join(dirPath, "db")
+	}
+	rootDir = dirPath
+}
+
+// open opens the keystore the first time it is used
+func open() *badger.DB {
+	var err error
 
+	once.Do(func() {
 		encryptionKey = GetHardwareEntropy()
 		if err != nil {
 			zap.L().Fatal("get_encryption_key",
@@ -89,7 +95,11 @@ func Start(dirPath string) *badger.DB {
 	return db
 }
 
+// Close closes the keystore if it was opened
 func Close() {
+	if db == nil {
+		return
+	}
 	err := db.Close()
 	if err != nil {
 		zap.L().Fatal("new_badger_db",
@@ -102,7 +112,7 @@ func Close() {
 func GetKey() string {
 	var apiKey string
 
-	err := db.View(func(txn *badger.Txn) error {
+	err := open().View(func(txn *badger.Txn) error {
 		item, err := txn.Get([]byte("cfg:api_key"))
 		if err != nil {
 			return err // could be ErrKeyNotFound
@@ -126,7 +136,7 @@ func GetKey() string {
 func GetEmail() string {
 	var email string
 
-	err := db.View(func(txn *badger.Txn) error {
+	err := open().View(func(txn *badger.Txn) error {
 		item, err := txn.Get([]byte("cfg:email"))
 		if err != nil {
 			return err // could be ErrKeyNotFound
@@ -148,7 +158,7 @@ func GetEmail() string {
 }
 
 func StoreKey(apiKey string) error {
-	err := db.Update(func(txn *badger.Txn) error {
+	err := open().Update(func(txn *badger.Txn) error {
 		return txn.Set([]byte("cfg:api_key"), []byte(apiKey))
 	})
 	if err != nil {
@@ -161,7 +171,7 @@ func StoreKey(apiKey string) error {
 }
 
 func StoreEmail(email string) error {
-	err := db.Update(func(txn *badger.Txn) error {
+	err := open().Update(func(txn *badger.Txn) error {
 		return txn.Set([]byte("cfg:email"), []byte(email))
 	})
 	if err != nil {
@@ -172,3 +182,59 @@ func StoreEmail(email string) error {
 	}
 	return err
 }
+
+// GetConfig returns the stored value of a config key, or "" when it is not set
+func GetConfig(name string) string {
+	var value string
+
+	err := open().View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte("cfg:" + name))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			value = string(val)
+			return nil
+		})
+	})
+
+	if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+		zap.L().Error("get_config",
+			zap.String("message", "failed to get config"),
+			zap.String("name", name),
+			zap.Error(err),
+		)
+	}
+
+	return value
+}
+
+// StoreConfig stores the value of a config key
+func StoreConfig(name, value string) error {
+	err := open().Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte("cfg:"+name), []byte(value))
+	})
+	if err != nil {
+		zap.L().Error("set_config",
+			zap.String("message", "failed to set config"),
+			zap.String("name", name),
+			zap.Error(err),
+		)
+	}
+	return err
+}
+
+// DeleteConfig removes a config key
+func DeleteConfig(name string) error {
+	err := open().Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte("cfg:" + name))
+	})
+	if err != nil {
+		zap.L().Error("delete_config",
+			zap.String("message", "failed to delete config"),
+			zap.String("name", name),
+			zap.Error(err),
+		)
+	}
+	return err
+}
@@ -0,0 +1,253 @@
+package cassette
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileName is the name of the cassette file inside a cassette directory
+const FileName = "cassette.jsonl"
+
+// RedactedKey replaces the API key in recorded requests
+const RedactedKey = "REDACTED"
+
+// redactedHeaders are never written to a cassette
+var redactedHeaders = []string{"Dehashed-Api-Key", "Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// ErrNoInteraction is returned when a replayed request was never recorded
+var ErrNoInteraction = errors.New("no recorded response for request")
+
+// Request is a recorded HTTP request
+type Request struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// Response is a recorded HTTP response
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// Interaction is a request and the response the API returned for it
+type Interaction struct {
+	RecordedAt time.Time     `json:"recorded_at"`
+	Duration   time.Duration `json:"duration"`
+	Request    Request       `json:"request"`
+	Response   Response      `json:"response"`
+}
+
+// Recorder is a RoundTripper that sends requests with its transport and appends every
+// request and response pair to a cassette, one JSON line per interaction
+type Recorder struct {
+	transport http.RoundTripper
+	mu        sync.Mutex
+	file      *os.File
+}
+
+// NewRecorder creates a Recorder appending to the cassette in dir. A nil transport uses
+// http.DefaultTransport.
+func NewRecorder(dir string, transport http.RoundTripper) (*Recorder, error) {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cassette directory: %w", err)
+	}
+	file, err := os.OpenFile(filepath.Join(dir, FileName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cassette: %w", err)
+	}
+	return &Recorder{transport: transport, file: file}, nil
+}
+
+// RoundTrip sends the request and records it together with its response
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	res, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resBody, err := readBody(&res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	interaction := Interaction{
+		RecordedAt: start.UTC(),
+		Duration:   time.Since(start),
+		Request: Request{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: redact(req.Header),
+			Body:   string(reqBody),
+		},
+		Response: Response{
+			StatusCode: res.StatusCode,
+			Header:     redact(res.Header),
+			Body:       string(resBody),
+		},
+	}
+	if err := r.write(interaction); err != nil {
+		// A failed recording does not fail the request itself
+		zap.L().Error("cassette_record",
+			zap.String("message", "failed to record interaction"),
+			zap.Error(err),
+		)
+		fmt.Printf("\n[!] Error recording response: %v", err)
+	}
+	return res, nil
+}
+
+// write appends an interaction, so a cassette is complete up to the last response
+// even when the run is interrupted
+func (r *Recorder) write(interaction Interaction) error {
+	line, err := json.Marshal(interaction)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err = r.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the cassette file
+func (r *Recorder) Close() error {
+	return r.file.Close()
+}
+
+// Player is a RoundTripper answering requests from a cassette without using the network.
+// Identical requests are answered in the order they were recorded, and the last
+// response is repeated once they run out.
+type Player struct {
+	mu           sync.Mutex
+	interactions map[string][]Interaction
+}
+
+// NewPlayer loads the cassette in dir
+func NewPlayer(dir string) (*Player, error) {
+	file, err := os.Open(filepath.Join(dir, FileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cassette: %w", err)
+	}
+	defer file.Close()
+
+	p := &Player{interactions: make(map[string][]Interaction)}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<30) // Responses with 10000 entries are far larger than a default line
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var interaction Interaction
+		if err := json.Unmarshal(scanner.Bytes(), &interaction); err != nil {
+			return nil, fmt.Errorf("failed to parse cassette line %d: %w", line, err)
+		}
+		key, err := matchKey(interaction.Request.Method, interaction.Request.URL, []byte(interaction.Request.Body))
+		if err != nil {
+			return nil, fmt.Errorf("cassette line %d: %w", line, err)
+		}
+		p.interactions[key] = append(p.interactions[key], interaction)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+	return p, nil
+}
+
+// RoundTrip returns the recorded response for the request
+func (p *Player) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+	key, err := matchKey(req.Method, req.URL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	recorded := p.interactions[key]
+	if len(recorded) > 1 {
+		p.interactions[key] = recorded[1:]
+	}
+	p.mu.Unlock()
+	if len(recorded) == 0 {
+		return nil, fmt.Errorf("%w: %s %s", ErrNoInteraction, req.Method, req.URL.Path)
+	}
+
+	recordedRes := recorded[0].Response
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recordedRes.StatusCode, http.StatusText(recordedRes.StatusCode)),
+		StatusCode:    recordedRes.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        recordedRes.Header.Clone(),
+		Body:          io.NopCloser(strings.NewReader(recordedRes.Body)),
+		ContentLength: int64(len(recordedRes.Body)),
+		Request:       req,
+	}, nil
+}
+
+// matchKey identifies a request by method, path, query and body. The host is left out
+// so cassettes recorded against one API address replay against any other, and JSON
+// bodies are compacted so formatting does not matter.
+func matchKey(method, rawURL string, body []byte) (string, error) {
+	req, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid request url %q: %w", rawURL, err)
+	}
+
+	var compact bytes.Buffer
+	if json.Compact(&compact, body) != nil {
+		compact.Reset()
+		compact.Write(body)
+	}
+	return fmt.Sprintf("%s %s %s", method, req.URL.RequestURI(), compact.String()), nil
+}
+
+// readBody reads a body and replaces it with a copy so it can still be sent
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+	data, err := io.ReadAll(*body)
+	(*body).Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+	*body = io.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
+
+// redact returns a copy of the headers with credentials replaced
+func redact(header http.Header) http.Header {
+	header = header.Clone()
+	for _, name := range redactedHeaders {
+		if header.Get(name) != "" {
+			header.Set(name, RedactedKey)
+		}
+	}
+	return header
+}
@@ -0,0 +1,233 @@
+package cassette
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const testAPIKey = "secret-api-key"
+
+// newTestAPI serves numbered responses, so replayed responses can be told apart
+func newTestAPI(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=abc123")
+		if r.Header.Get("Dehashed-Api-Key") != testAPIKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": "Invalid API Key"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"n": %d, "path": %q, "body": %q}`, n, r.URL.Path, body)
+	}))
+	t.Cleanup(server.Close)
+	return server, &hits
+}
+
+// send sends a request through transport and returns the status and body of the response
+func send(t *testing.T, transport http.RoundTripper, method, url, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Dehashed-Api-Key", testAPIKey)
+	req.Header.Set("Authorization", "Bearer "+testAPIKey)
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(data)
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	server, hits := newTestAPI(t)
+	dir := t.TempDir()
+
+	recorder, err := NewRecorder(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := []struct {
+		method, path, body string
+	}{
+		{http.MethodPost, "/v2/search", `{"query": "email:a@corp.com", "page": 1}`},
+		{http.MethodPost, "/v2/search", `{"query": "email:a@corp.com", "page": 1}`},
+		{http.MethodPost, "/v2/search", `{"query": "email:a@corp.com", "page": 2}`},
+		{http.MethodGet, "/v2/whois/credits?x=1", ""},
+	}
+	recorded := make([]string, len(requests))
+	for i, r := range requests {
+		status, body := send(t, recorder, r.method, server.URL+r.path, r.body)
+		if status != http.StatusOK {
+			t.Fatalf("recording %s %s: status %d", r.method, r.path, status)
+		}
+		recorded[i] = body
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	player, err := NewPlayer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The host differs and the JSON is formatted differently, neither prevents a match
+	const otherHost = "http://127.0.0.1:1"
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   string
+	}{
+		{name: "first of identical requests", method: http.MethodPost, path: "/v2/search", body: `{"query":"email:a@corp.com","page":1}`, want: recorded[0]},
+		{name: "second of identical requests", method: http.MethodPost, path: "/v2/search", body: `{"query":"email:a@corp.com","page":1}`, want: recorded[1]},
+		{name: "last response repeats", method: http.MethodPost, path: "/v2/search", body: `{"query":"email:a@corp.com","page":1}`, want: recorded[1]},
+		{name: "different body", method: http.MethodPost, path: "/v2/search", body: `{"page": 2, "query": "email:a@corp.com"}`, want: ""},
+		{name: "query string", method: http.MethodGet, path: "/v2/whois/credits?x=1", want: recorded[3]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, otherHost+tt.path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := player.RoundTrip(req)
+			if tt.want == "" {
+				// JSON keys are not reordered, so this request was never recorded
+				if !errors.Is(err, ErrNoInteraction) {
+					t.Fatalf("RoundTrip error = %v, want ErrNoInteraction", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RoundTrip: %v", err)
+			}
+			defer res.Body.Close()
+			body, _ := io.ReadAll(res.Body)
+			if string(body) != tt.want {
+				t.Errorf("replayed body = %s, want %s", body, tt.want)
+			}
+			if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("replayed status %d, content type %q", res.StatusCode, res.Header.Get("Content-Type"))
+			}
+		})
+	}
+
+	if got := hits.Load(); got != int32(len(requests)) {
+		t.Errorf("server received %d requests, want %d", got, len(requests))
+	}
+}
+
+func TestPlayerNoInteraction(t *testing.T) {
+	server, _ := newTestAPI(t)
+	dir := t.TempDir()
+
+	recorder, err := NewRecorder(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(t, recorder, http.MethodPost, server.URL+"/v2/search", `{"page": 1}`)
+	recorder.Close()
+
+	player, err := NewPlayer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, method, path, body string
+	}{
+		{name: "other path", method: http.MethodPost, path: "/v2/whois/search", body: `{"page": 1}`},
+		{name: "other method", method: http.MethodGet, path: "/v2/search", body: `{"page": 1}`},
+		{name: "other body", method: http.MethodPost, path: "/v2/search", body: `{"page": 2}`},
+		{name: "other query", method: http.MethodPost, path: "/v2/search?page=1", body: `{"page": 1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, server.URL+tt.path, strings.NewReader(tt.body))
+			_, err := player.RoundTrip(req)
+			if !errors.Is(err, ErrNoInteraction) {
+				t.Fatalf("RoundTrip error = %v, want ErrNoInteraction", err)
+			}
+			if !strings.Contains(err.Error(), tt.method) {
+				t.Errorf("error %q does not name the request", err)
+			}
+		})
+	}
+}
+
+func TestRecorderScrubsHeaders(t *testing.T) {
+	server, _ := newTestAPI(t)
+	dir := t.TempDir()
+
+	recorder, err := NewRecorder(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(t, recorder, http.MethodPost, server.URL+"/v2/search", `{"page": 1}`)
+	recorder.Close()
+
+	data, err := os.ReadFile(filepath.Join(dir, FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{testAPIKey, "abc123"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("cassette contains %q:\n%s", secret, data)
+		}
+	}
+
+	player, err := NewPlayer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var interaction Interaction
+	for _, recorded := range player.interactions {
+		interaction = recorded[0]
+	}
+	tests := []struct {
+		name   string
+		header http.Header
+		key    string
+		want   string
+	}{
+		{name: "api key", header: interaction.Request.Header, key: "Dehashed-Api-Key", want: RedactedKey},
+		{name: "authorization", header: interaction.Request.Header, key: "Authorization", want: RedactedKey},
+		{name: "set cookie", header: interaction.Response.Header, key: "Set-Cookie", want: RedactedKey},
+		{name: "content type is kept", header: interaction.Response.Header, key: "Content-Type", want: "application/json"},
+	}
+	for _, tt := range tests {
+		if got := tt.header.Get(tt.key); got != tt.want {
+			t.Errorf("%s: %s = %q, want %q", tt.name, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestRedactLeavesOriginalHeaders(t *testing.T) {
+	header := http.Header{"Dehashed-Api-Key": {testAPIKey}}
+	redacted := redact(header)
+	if redacted.Get("Dehashed-Api-Key") != RedactedKey {
+		t.Errorf("redacted key = %q, want %q", redacted.Get("Dehashed-Api-Key"), RedactedKey)
+	}
+	if header.Get("Dehashed-Api-Key") != testAPIKey {
+		t.Error("redact changed the headers of the request being sent")
+	}
+}
@@ -3,75 +3,71 @@ package export
 import (
 	"Dehash/internal/files"
 	"Dehash/internal/sqlite"
+	"bytes"
 	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"gopkg.in/yaml.v3"
-	"io/ioutil"
+	"io"
 	"os"
 	"strings"
 )
 
-func WriteCredsToFile(creds []sqlite.Creds, outputFile string, fileType files.FileType) error {
-	var data []byte
-	var err error
-
-	switch fileType {
-	case files.JSON:
-		data, err = json.MarshalIndent(creds, "", "  ")
-	case files.XML:
-		data, err = xml.MarshalIndent(creds, "", "  ")
-	case files.YAML:
-		data, err = yaml.Marshal(creds)
-	case files.TEXT:
-		var outStrings []string
-		for _, c := range creds {
-			outStrings = append(outStrings, c.ToString()+"\n")
-		}
-		data = []byte(strings.Join(outStrings, ""))
-	default:
-		return errors.New("unsupported file type")
-	}
-
+// WriteToFile writes results to outputFile with the extension of fileType, replacing it
+func WriteToFile(results sqlite.DehashedResults, outputFile string, fileType files.FileType) error {
+	w, err := NewResultWriter(outputFile, fileType, Create)
 	if err != nil {
 		return err
 	}
-
-	filePath := fmt.Sprintf("%s.%s", outputFile, fileType.String())
-	return os.WriteFile(filePath, data, 0644)
+	for _, r := range results.Results {
+		if err := w.Write(r); err != nil {
+			break
+		}
+	}
+	return w.Close()
 }
 
-func WriteToFile(results sqlite.DehashedResults, outputFile string, fileType files.FileType) error {
-	var data []byte
-	var err error
-
-	result := results.Results
+// readFile reads entries back from a JSON, YAML or XML output file. A missing file yields no entries.
+func readFile[T any](filePath string, fileType files.FileType) ([]T, error) {
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
+	var entries []T
 	switch fileType {
 	case files.JSON:
-		data, err = json.MarshalIndent(result, "", "  ")
-	case files.XML:
-		data, err = xml.MarshalIndent(result, "", "  ")
+		err = json.Unmarshal(data, &entries)
 	case files.YAML:
-		data, err = yaml.Marshal(result)
-	case files.TEXT:
-		var outStrings []string
-		for _, r := range result {
-			out := fmt.Sprintf(
-				"Id: %s\nEmail: %s\nIpAddress: %s\nUsername: %s\nPassword: %s\nHashedPassword: %s\nHashType: %s\nName: %s\nVin: %s\nAddress: %s\nPhone: %s\nDatabaseName: %s\n\n",
-				r.DehashedId, r.Email, r.IpAddress, r.Username, r.Password, r.HashedPassword, r.HashType, r.Name, r.Vin, r.Address, r.Phone, r.DatabaseName)
-			outStrings = append(outStrings, out)
+		err = yaml.Unmarshal(data, &entries)
+	case files.XML:
+		// Slices are written as a sequence of elements without a root element
+		decoder := xml.NewDecoder(bytes.NewReader(data))
+		for {
+			var entry T
+			if err = decoder.Decode(&entry); err != nil {
+				break
+			}
+			entries = append(entries, entry)
+		}
+		if errors.Is(err, io.EOF) {
+			err = nil
 		}
-		data = []byte(strings.Join(outStrings, ""))
 	default:
-		return errors.New("unsupported file type")
+		return nil, errors.New("unsupported file type")
 	}
-
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to read %s: %w", filePath, err)
 	}
 
-	filePath := fmt.Sprintf("%s.%s", outputFile, fileType)
-	return ioutil.WriteFile(filePath, data, 0644)
+	return entries, nil
+}
+
+// credKey returns the fields that identify a credential independent of its database row
+func credKey(c sqlite.Creds) string {
+	return strings.Join([]string{c.Email, c.Username, c.Password, c.HashedPassword, c.DehashedId}, "\x00")
 }
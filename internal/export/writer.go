@@ -0,0 +1,233 @@
+package export
+
+import (
+	"Dehash/internal/files"
+	"Dehash/internal/sqlite"
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Mode controls how a Writer treats an existing output file and repeated entries
+type Mode int
+
+const (
+	Create Mode = iota // Replace the file
+	Unique             // Replace the file, skipping entries written before
+	Merge              // Keep the entries already in the file, skipping new entries already present
+)
+
+// Writer writes entries to an output file one at a time, so entries never have to be
+// collected in memory. The file is created with the first entry; nothing is written
+// when there are none. Merged files are written next to the original and replace it on
+// Close, text files are appended to.
+type Writer[T any] struct {
+	filePath string
+	fileType files.FileType
+	mode     Mode
+	key      func(T) string
+	format   func(T) string
+
+	file    *os.File
+	buf     *bufio.Writer
+	tmpPath string
+	seen    map[string]bool
+	count   int
+	err     error
+}
+
+// NewResultWriter creates a writer of results to outputFile with the extension of fileType
+func NewResultWriter(outputFile string, fileType files.FileType, mode Mode) (*Writer[sqlite.Result], error) {
+	return newWriter(outputFile, fileType, mode, func(r sqlite.Result) string { return r.DehashedId }, formatResult)
+}
+
+// NewCredsWriter creates a writer of credentials to outputFile with the extension of fileType
+func NewCredsWriter(outputFile string, fileType files.FileType, mode Mode) (*Writer[sqlite.Creds], error) {
+	return newWriter(outputFile, fileType, mode, credKey, func(c sqlite.Creds) string { return c.ToString() + "\n" })
+}
+
+func newWriter[T any](outputFile string, fileType files.FileType, mode Mode, key, format func(T) string) (*Writer[T], error) {
+	switch fileType {
+	case files.JSON, files.YAML, files.XML, files.TEXT:
+	default:
+		return nil, errors.New("unsupported file type")
+	}
+	w := &Writer[T]{
+		filePath: fmt.Sprintf("%s.%s", outputFile, fileType.String()),
+		fileType: fileType,
+		mode:     mode,
+		key:      key,
+		format:   format,
+	}
+	if mode != Create {
+		w.seen = make(map[string]bool)
+	}
+	return w, nil
+}
+
+// NewTerminalWriter creates a JSON writer to out, used when the output file cannot be written
+func NewTerminalWriter[T any](out io.Writer) *Writer[T] {
+	return &Writer[T]{filePath: "terminal", fileType: files.JSON, buf: bufio.NewWriter(out)}
+}
+
+// Path returns the path of the output file
+func (w *Writer[T]) Path() string {
+	return w.filePath
+}
+
+// Count returns the number of entries written
+func (w *Writer[T]) Count() int {
+	return w.count
+}
+
+// Write writes a single entry, skipping it when the mode does not allow repeated entries
+// and it was written or already present before
+func (w *Writer[T]) Write(entry T) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.buf == nil {
+		if w.err = w.open(); w.err != nil {
+			return w.err
+		}
+	}
+	if w.seen != nil {
+		key := w.key(entry)
+		if w.seen[key] {
+			return nil
+		}
+		w.seen[key] = true
+	}
+	w.err = w.writeEntry(entry)
+	return w.err
+}
+
+// open creates the output file, copying the entries of the existing file first when merging
+func (w *Writer[T]) open() error {
+	var existing []T
+	switch {
+	case w.mode == Merge && w.fileType == files.TEXT:
+		file, err := os.OpenFile(w.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		w.file, w.buf = file, bufio.NewWriter(file)
+		return nil
+	case w.mode == Merge:
+		entries, err := readFile[T](w.filePath, w.fileType)
+		if err != nil {
+			return err
+		}
+		existing = entries
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(w.filePath), filepath.Base(w.filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	w.file, w.tmpPath, w.buf = file, file.Name(), bufio.NewWriter(file)
+	if err := file.Chmod(0644); err != nil {
+		return err
+	}
+
+	for _, entry := range existing {
+		w.seen[w.key(entry)] = true
+		if err := w.writeEntry(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEntry encodes an entry in the format of the file. JSON entries form an indented
+// array, YAML entries a sequence and XML entries a sequence of elements without a root.
+func (w *Writer[T]) writeEntry(entry T) error {
+	var data []byte
+	var err error
+	switch w.fileType {
+	case files.JSON:
+		separator := "[\n  "
+		if w.count > 0 {
+			separator = ",\n  "
+		}
+		if _, err = w.buf.WriteString(separator); err != nil {
+			return err
+		}
+		data, err = json.MarshalIndent(entry, "  ", "  ")
+	case files.YAML:
+		data, err = yaml.Marshal([]T{entry})
+	case files.XML:
+		if w.count > 0 {
+			if err = w.buf.WriteByte('\n'); err != nil {
+				return err
+			}
+		}
+		data, err = xml.MarshalIndent(entry, "", "  ")
+	case files.TEXT:
+		data = []byte(w.format(entry))
+	}
+	if err != nil {
+		return err
+	}
+	if _, err = w.buf.Write(data); err != nil {
+		return err
+	}
+	w.count++
+	return nil
+}
+
+// Flush writes buffered entries to the file
+func (w *Writer[T]) Flush() error {
+	if w.buf == nil || w.err != nil {
+		return w.err
+	}
+	w.err = w.buf.Flush()
+	return w.err
+}
+
+// Close terminates the file and moves it into place. A writer that failed leaves the
+// original file untouched.
+func (w *Writer[T]) Close() error {
+	if w.buf == nil {
+		return w.err
+	}
+	if w.err == nil && w.fileType == files.JSON && w.count > 0 {
+		_, w.err = w.buf.WriteString("\n]")
+	}
+	if w.err == nil {
+		w.err = w.buf.Flush()
+	}
+	w.buf = nil
+	if w.file == nil {
+		return w.err
+	}
+
+	closeErr := w.file.Close()
+	if w.err == nil {
+		w.err = closeErr
+	}
+	if w.tmpPath == "" {
+		return w.err
+	}
+	if w.err != nil {
+		os.Remove(w.tmpPath)
+		return w.err
+	}
+	if w.err = os.Rename(w.tmpPath, w.filePath); w.err != nil {
+		os.Remove(w.tmpPath)
+	}
+	return w.err
+}
+
+// formatResult formats a result for text files
+func formatResult(r sqlite.Result) string {
+	return fmt.Sprintf(
+		"Id: %s\nEmail: %s\nIpAddress: %s\nUsername: %s\nPassword: %s\nHashedPassword: %s\nHashType: %s\nName: %s\nVin: %s\nAddress: %s\nPhone: %s\nDatabaseName: %s\nSource: %s\n\n",
+		r.DehashedId, r.Email, r.IpAddress, r.Username, r.Password, r.HashedPassword, r.HashType, r.Name, r.Vin, r.Address, r.Phone, r.DatabaseName, r.Source)
+}
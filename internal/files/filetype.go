@@ -1,5 +1,10 @@
 package files
 
+import (
+	"fmt"
+	"strings"
+)
+
 type FileType int32
 
 const (
@@ -24,6 +29,22 @@ func GetFileType(filetype string) FileType {
 	}
 }
 
+// ParseFileType returns the file type with the given name or extension, rejecting unknown
+// names instead of falling back to JSON like GetFileType
+func ParseFileType(filetype string) (FileType, error) {
+	switch strings.ToLower(strings.TrimPrefix(filetype, ".")) {
+	case "json":
+		return JSON, nil
+	case "xml":
+		return XML, nil
+	case "yaml", "yml":
+		return YAML, nil
+	case "txt", "text":
+		return TEXT, nil
+	}
+	return JSON, fmt.Errorf("unsupported file format %q (json, yaml, xml or txt)", filetype)
+}
+
 func (ft FileType) String() string {
 	switch ft {
 	case JSON:
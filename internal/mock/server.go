@@ -0,0 +1,428 @@
+package mock
+
+import (
+	"Dehash/internal/query"
+	"Dehash/pkg/dehashed"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultAPIKey is accepted by the mock server when no key is configured
+const DefaultAPIKey = "mock-api-key"
+
+//go:embed fixtures/*.json
+var defaultFixtures embed.FS
+
+// fieldKeys maps API parameters to the entry fields they search where the names differ
+var fieldKeys = map[query.DehashedParameter]string{
+	query.Domain: "url",
+}
+
+// Fault is an error status returned instead of the next Count responses
+type Fault struct {
+	Status int
+	Count  int
+}
+
+// ParseFault parses a fault given as status or status:count, e.g. 429:2
+func ParseFault(spec string) (Fault, error) {
+	status, count, hasCount := strings.Cut(strings.TrimSpace(spec), ":")
+	fault := Fault{Count: 1}
+
+	var err error
+	if fault.Status, err = strconv.Atoi(status); err != nil || fault.Status < 400 || fault.Status > 599 {
+		return Fault{}, fmt.Errorf("invalid fault %q, expected a 4xx or 5xx status", spec)
+	}
+	if hasCount {
+		if fault.Count, err = strconv.Atoi(count); err != nil || fault.Count < 1 {
+			return Fault{}, fmt.Errorf("invalid fault %q, expected a positive count", spec)
+		}
+	}
+	return fault, nil
+}
+
+// Fixtures are the canned data served by the mock server
+type Fixtures struct {
+	Entries []map[string]any           // Search entries, in the order they are paginated
+	Whois   map[string]json.RawMessage // WHOIS responses by search type
+}
+
+// LoadFixtures reads search.json and whois.json from dir. Files missing from dir, or
+// every file when dir is empty, fall back to the built in fixtures.
+func LoadFixtures(dir string) (*Fixtures, error) {
+	var fixtures Fixtures
+	if err := readFixture(dir, "search.json", &fixtures.Entries); err != nil {
+		return nil, err
+	}
+	if err := readFixture(dir, "whois.json", &fixtures.Whois); err != nil {
+		return nil, err
+	}
+	for i, entry := range fixtures.Entries {
+		if _, ok := entry["id"]; !ok {
+			entry["id"] = fmt.Sprintf("fixture-%d", i+1)
+		}
+	}
+	return &fixtures, nil
+}
+
+func readFixture(dir, name string, v any) error {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if dir == "" || errors.Is(err, fs.ErrNotExist) {
+		data, err = defaultFixtures.ReadFile("fixtures/" + name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read fixture %s: %w", name, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse fixture %s: %w", name, err)
+	}
+	return nil
+}
+
+// Generate appends n synthetic entries, useful for exercising pagination
+func (f *Fixtures) Generate(n int) {
+	for i := 1; i <= n; i++ {
+		f.Entries = append(f.Entries, map[string]any{
+			"id":              fmt.Sprintf("generated-%06d", i),
+			"email":           []any{fmt.Sprintf("user%d@generated.example", i)},
+			"username":        []any{fmt.Sprintf("user%d", i)},
+			"hashed_password": []any{fmt.Sprintf("%032x", i)},
+			"url":             []any{"generated.example"},
+			"database_name":   "GeneratedFixture",
+		})
+	}
+}
+
+// Config configures a mock server
+type Config struct {
+	APIKey       string        // Key required in the Dehashed-Api-Key header
+	Balance      int           // Search credits, one is spent per search
+	WhoisCredits int           // WHOIS credits, one is spent per WHOIS search
+	Faults       []Fault       // Returned in order before normal responses
+	RetryAfter   time.Duration // Sent with 429 faults when set
+	Latency      time.Duration // Added to every response
+	Log          io.Writer     // Receives a line per request when set
+}
+
+// Server is an offline stand in for the Dehashed API serving fixtures
+type Server struct {
+	config   Config
+	fixtures *Fixtures
+	mux      *http.ServeMux
+
+	mu           sync.Mutex
+	balance      int
+	whoisCredits int
+	faults       []Fault
+}
+
+// NewServer creates a mock server for the fixtures
+func NewServer(config Config, fixtures *Fixtures) *Server {
+	if config.APIKey == "" {
+		config.APIKey = DefaultAPIKey
+	}
+	s := &Server{
+		config:       config,
+		fixtures:     fixtures,
+		mux:          http.NewServeMux(),
+		balance:      config.Balance,
+		whoisCredits: config.WhoisCredits,
+		faults:       append([]Fault(nil), config.Faults...),
+	}
+	s.mux.HandleFunc("POST /v2/search", s.handleSearch)
+	s.mux.HandleFunc("POST /v2/whois/search", s.handleWhoisSearch)
+	s.mux.HandleFunc("GET /v2/whois/credits", s.handleWhoisCredits)
+	return s
+}
+
+// ServeHTTP checks authentication and injected faults before routing the request
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	defer func() {
+		zap.L().Info("mock_request",
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Int("status", rec.status),
+		)
+		if s.config.Log != nil {
+			fmt.Fprintf(s.config.Log, "[*] %s %s %d (%s)\n", r.Method, r.URL.Path, rec.status, time.Since(start).Round(time.Millisecond))
+		}
+	}()
+
+	if s.config.Latency > 0 {
+		time.Sleep(s.config.Latency)
+	}
+	if r.Header.Get("Dehashed-Api-Key") != s.config.APIKey {
+		writeError(rec, http.StatusUnauthorized, "Invalid API key")
+		return
+	}
+	if fault, ok := s.nextFault(); ok {
+		if fault.Status == http.StatusTooManyRequests && s.config.RetryAfter > 0 {
+			rec.Header().Set("Retry-After", strconv.Itoa(int(s.config.RetryAfter.Round(time.Second)/time.Second)))
+		}
+		writeError(rec, fault.Status, fmt.Sprintf("Injected fault (HTTP %d)", fault.Status))
+		return
+	}
+
+	// Unknown routes and methods are reported the way the API reports them
+	if _, pattern := s.mux.Handler(r); pattern == "" {
+		writeError(rec, http.StatusNotFound, "Method not permitted")
+		return
+	}
+	s.mux.ServeHTTP(rec, r)
+}
+
+// nextFault consumes the next injected fault, if any remain
+func (s *Server) nextFault() (Fault, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.faults) == 0 {
+		return Fault{}, false
+	}
+	fault := s.faults[0]
+	if s.faults[0].Count--; s.faults[0].Count == 0 {
+		s.faults = s.faults[1:]
+	}
+	return fault, true
+}
+
+// spend takes one credit from a balance, reporting false when none are left
+func (s *Server) spend(balance *int) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if *balance < query.CreditsPerRequest {
+		return *balance, false
+	}
+	*balance -= query.CreditsPerRequest
+	return *balance, true
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	var req query.DehashedSearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	switch {
+	case strings.TrimSpace(req.Query) == "":
+		writeError(w, http.StatusFound, "Invalid/Missing Query")
+		return
+	case req.Size < 1 || req.Size > query.MaxPageSize:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Size must be between 1 and %d", query.MaxPageSize))
+		return
+	case req.Page < 1 || req.Page*req.Size > query.MaxPaginationDepth:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Pagination is limited to %d records", query.MaxPaginationDepth))
+		return
+	}
+
+	node, err := query.ParseExpression(req.Query)
+	if err != nil {
+		writeError(w, http.StatusFound, err.Error())
+		return
+	}
+	matches, err := s.search(node, req)
+	if err != nil {
+		writeError(w, http.StatusFound, err.Error())
+		return
+	}
+
+	balance, ok := s.spend(&s.balance)
+	if !ok {
+		writeError(w, http.StatusForbidden, "Insufficient Credits")
+		return
+	}
+
+	entries := []map[string]any{}
+	if from := (req.Page - 1) * req.Size; from < len(matches) {
+		entries = matches[from:min(from+req.Size, len(matches))]
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"balance": balance,
+		"entries": entries,
+		"success": true,
+		"took":    time.Since(start).String(),
+		"total":   len(matches),
+	})
+}
+
+// search returns the fixture entries matching a parsed query
+func (s *Server) search(node query.Node, req query.DehashedSearchRequest) ([]map[string]any, error) {
+	patterns := make(map[*query.TermNode]*regexp.Regexp)
+	var compile func(query.Node) error
+	compile = func(node query.Node) error {
+		switch n := node.(type) {
+		case *query.TermNode:
+			pattern, err := termPattern(n, req)
+			patterns[n] = pattern
+			return err
+		case *query.NotNode:
+			return compile(n.Expr)
+		case *query.AndNode:
+			return errors.Join(compileAll(n.Exprs, compile)...)
+		case *query.OrNode:
+			return errors.Join(compileAll(n.Exprs, compile)...)
+		}
+		return nil
+	}
+	if err := compile(node); err != nil {
+		return nil, err
+	}
+
+	var matches []map[string]any
+	for _, entry := range s.fixtures.Entries {
+		if evaluate(node, entry, patterns) {
+			matches = append(matches, entry)
+		}
+	}
+	return matches, nil
+}
+
+func compileAll(nodes []query.Node, compile func(query.Node) error) []error {
+	var errs []error
+	for _, node := range nodes {
+		errs = append(errs, compile(node))
+	}
+	return errs
+}
+
+// termPattern builds the case insensitive pattern a term matches, honouring the match
+// flags of the request the way the API applies them to the whole query
+func termPattern(term *query.TermNode, req query.DehashedSearchRequest) (*regexp.Regexp, error) {
+	switch {
+	case req.Regex || term.Mode == query.MatchRegex:
+		pattern, err := regexp.Compile("(?i)" + term.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex for %s: %w", term.Param, err)
+		}
+		return pattern, nil
+	case req.Wildcard || term.Mode == query.MatchWildcard:
+		glob := regexp.QuoteMeta(term.Value)
+		glob = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(glob)
+		return regexp.MustCompile("(?i)^" + glob + "$"), nil
+	default:
+		return regexp.MustCompile("(?i)" + regexp.QuoteMeta(term.Value)), nil
+	}
+}
+
+// evaluate reports whether an entry matches a query
+func evaluate(node query.Node, entry map[string]any, patterns map[*query.TermNode]*regexp.Regexp) bool {
+	switch n := node.(type) {
+	case *query.TermNode:
+		key, ok := fieldKeys[n.Param]
+		if !ok {
+			key = string(n.Param)
+		}
+		for _, value := range entryValues(entry[key]) {
+			if patterns[n].MatchString(value) {
+				return true
+			}
+		}
+		return false
+	case *query.NotNode:
+		return !evaluate(n.Expr, entry, patterns)
+	case *query.AndNode:
+		for _, expr := range n.Exprs {
+			if !evaluate(expr, entry, patterns) {
+				return false
+			}
+		}
+		return true
+	case *query.OrNode:
+		for _, expr := range n.Exprs {
+			if evaluate(expr, entry, patterns) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// entryValues returns the values of an entry field, which may be a string or a list
+func entryValues(field any) []string {
+	switch v := field.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, value := range v {
+			values = append(values, fmt.Sprint(value))
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+func (s *Server) handleWhoisSearch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		SearchType string `json:"search_type"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SearchType == "" {
+		writeError(w, http.StatusFound, "Invalid/Missing Query")
+		return
+	}
+
+	fixture, ok := s.fixtures.Whois[req.SearchType]
+	if !ok {
+		writeError(w, http.StatusFound, fmt.Sprintf("Unknown search type %q", req.SearchType))
+		return
+	}
+	if _, ok := s.spend(&s.whoisCredits); !ok {
+		writeError(w, http.StatusForbidden, "Insufficient Credits")
+		return
+	}
+	writeJSON(w, http.StatusOK, fixture)
+}
+
+func (s *Server) handleWhoisCredits(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	credits := s.whoisCredits
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, dehashed.WhoisCreditsResponse{WhoisCredits: credits})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		zap.L().Error("mock_response",
+			zap.String("message", "failed to write response"),
+			zap.Error(err),
+		)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, dehashed.ErrorResponse{HttpResponse: status, Error: message})
+}
+
+// statusRecorder remembers the status written for request logging
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
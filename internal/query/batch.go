@@ -0,0 +1,431 @@
+package query
+
+import (
+	"Dehash/internal/export"
+	"Dehash/internal/sqlite"
+	"Dehash/pkg/dehashed"
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// parameterAliases maps the short field names used by the CLI flags to API parameters.
+// There is no url alias: a URL is not a domain, and searching it as one finds nothing.
+var parameterAliases = map[string]DehashedParameter{
+	"ip":      IpAddress,
+	"hash":    HashedPassword,
+	"license": LicensePlate,
+	"crypto":  CryptoAddress,
+}
+
+// ParseParameter returns the API parameter for a field name or one of its CLI aliases
+func ParseParameter(name string) (DehashedParameter, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, param := range dehashed.Parameters {
+		if name == string(param) {
+			return param, nil
+		}
+	}
+	if param, ok := parameterAliases[name]; ok {
+		return param, nil
+	}
+	return "", fmt.Errorf("unknown field %q", name)
+}
+
+// TargetField is a single field and value searched for a batch target
+type TargetField struct {
+	Param DehashedParameter
+	Value string
+}
+
+// Target is one entry of a batch input, searched with all of its fields combined
+type Target struct {
+	Line   int
+	Fields []TargetField
+}
+
+func (t Target) String() string {
+	parts := make([]string, 0, len(t.Fields))
+	for _, f := range t.Fields {
+		parts = append(parts, f.Param.GetArgumentString(f.Value))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Apply sets the target fields on a copy of the query options
+func (t Target) Apply(options sqlite.QueryOptions) *sqlite.QueryOptions {
+	for _, f := range t.Fields {
+		switch f.Param {
+		case Username:
+			options.UsernameQuery = f.Value
+		case Email:
+			options.EmailQuery = f.Value
+		case Password:
+			options.PassQuery = f.Value
+		case HashedPassword:
+			options.HashQuery = f.Value
+		case Name:
+			options.NameQuery = f.Value
+		case IpAddress:
+			options.IpQuery = f.Value
+		case Domain:
+			options.DomainQuery = f.Value
+		case Vin:
+			options.VinQuery = f.Value
+		case LicensePlate:
+			options.LicensePlateQuery = f.Value
+		case Address:
+			options.AddressQuery = f.Value
+		case Phone:
+			options.PhoneQuery = f.Value
+		case Social:
+			options.SocialQuery = f.Value
+		case CryptoAddress:
+			options.CryptoAddressQuery = f.Value
+		}
+	}
+	return &options
+}
+
+// ReadTargets reads one target per line. A line may name its field as "field:value",
+// otherwise defaultField is used. Blank lines and lines starting with # are skipped.
+func ReadTargets(r io.Reader, defaultField string) ([]Target, error) {
+	defaultParam, err := ParseParameter(defaultField)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []Target
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		param, value := defaultParam, text
+		if field, rest, ok := strings.Cut(text, ":"); ok {
+			// Values such as URLs and IPv6 addresses contain colons, so only known fields count
+			if p, err := ParseParameter(field); err == nil {
+				param, value = p, strings.TrimSpace(rest)
+			}
+		}
+		if value == "" {
+			return nil, fmt.Errorf("line %d: empty value for field %s", line, param)
+		}
+		targets = append(targets, Target{Line: line, Fields: []TargetField{{Param: param, Value: value}}})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read targets: %w", err)
+	}
+
+	return targets, nil
+}
+
+// ReadCSVTargets reads one target per CSV row. columns maps a header name or zero based
+// column index to a field; when empty, header names are used as field names.
+func ReadCSVTargets(r io.Reader, columns map[string]string) ([]Target, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv header: %w", err)
+	}
+
+	// Resolve the column mapping against the header
+	mapping := make(map[int]DehashedParameter)
+	if len(columns) == 0 {
+		for i, name := range header {
+			param, err := ParseParameter(name)
+			if err != nil {
+				return nil, fmt.Errorf("csv column %q: %w, use a column mapping", name, err)
+			}
+			mapping[i] = param
+		}
+	}
+	for column, field := range columns {
+		param, err := ParseParameter(field)
+		if err != nil {
+			return nil, fmt.Errorf("csv column %q: %w", column, err)
+		}
+		index, err := strconv.Atoi(column)
+		if err != nil {
+			index = -1
+			for i, name := range header {
+				if strings.EqualFold(strings.TrimSpace(name), strings.TrimSpace(column)) {
+					index = i
+					break
+				}
+			}
+		}
+		if index < 0 || index >= len(header) {
+			return nil, fmt.Errorf("csv column %q not found", column)
+		}
+		mapping[index] = param
+	}
+
+	var targets []Target
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read csv: %w", err)
+		}
+
+		// Blank lines are skipped by the reader, so the line is taken from the record
+		line, _ := reader.FieldPos(0)
+		target := Target{Line: line}
+		for i := range header {
+			param, ok := mapping[i]
+			if !ok || i >= len(record) || strings.TrimSpace(record[i]) == "" {
+				continue
+			}
+			target.Fields = append(target.Fields, TargetField{Param: param, Value: strings.TrimSpace(record[i])})
+		}
+		if len(target.Fields) > 0 {
+			targets = append(targets, target)
+		}
+	}
+
+	return targets, nil
+}
+
+// BatchResult is the outcome of querying a single batch target
+type BatchResult struct {
+	Target  Target
+	RunID   uint
+	Records int
+	Err     error
+	started bool
+}
+
+// Batch queries many targets in one process, sharing the query options between them
+type Batch struct {
+	options     sqlite.QueryOptions
+	targets     []Target
+	key         string
+	retry       *RetryPolicy
+	cache       *CachePolicy
+	local       *LocalPolicy
+	limiter     *RateLimiter
+	budget      *Budget // Shared by every target, so together they stay within the budget
+	concurrency int
+	splitOutput bool
+	Results     []BatchResult // Ordered as the targets, whatever order they complete in
+}
+
+// NewBatch creates a new Batch. Target fields are applied on top of options; when
+// splitOutput is set every target is exported to its own file.
+func NewBatch(options *sqlite.QueryOptions, targets []Target, splitOutput bool) *Batch {
+	return &Batch{options: *options, targets: targets, splitOutput: splitOutput, budget: NewBudget(options.Budget), concurrency: 1}
+}
+
+// SetCachePolicy sets how search responses of every target are cached
+func (b *Batch) SetCachePolicy(policy *CachePolicy) {
+	b.cache = policy
+}
+
+// SetLocalPolicy sets whether targets are answered from the local database
+func (b *Batch) SetLocalPolicy(policy *LocalPolicy) {
+	b.local = policy
+}
+
+// SetConcurrency sets how many targets are queried at the same time
+func (b *Batch) SetConcurrency(n int) {
+	b.concurrency = max(1, n)
+}
+
+// SetRateLimiter sets the limiter shared by the requests of every target
+func (b *Batch) SetRateLimiter(limiter *RateLimiter) {
+	b.limiter = limiter
+}
+
+// SetClientCredentials sets the client credentials used for every target
+func (b *Batch) SetClientCredentials(key string) {
+	b.key = key
+}
+
+// SetRetryPolicy sets the retry policy used for every target
+func (b *Batch) SetRetryPolicy(policy *RetryPolicy) {
+	b.retry = policy
+}
+
+// newDehasher creates the Dehasher for the target at index i
+func (b *Batch) newDehasher(i int) (*Dehasher, error) {
+	target := b.targets[i]
+	options := target.Apply(b.options)
+	if b.splitOutput {
+		options.OutputFile = fmt.Sprintf("%s_%d_%s", b.options.OutputFile, i+1, outputSuffix(target))
+	}
+	return NewDehasher(options)
+}
+
+// DryRun prints the requests every target would send and their total estimated cost
+func (b *Batch) DryRun() error {
+	credits := 0
+	for i, target := range b.targets {
+		fmt.Printf("\n[*] Target %d/%d: %s\n", i+1, len(b.targets), target)
+		dh, err := b.newDehasher(i)
+		if err != nil {
+			return wrapError(fmt.Sprintf("target on line %d", target.Line), err)
+		}
+		dh.SetCachePolicy(b.cache)
+		if err := dh.DryRun(); err != nil {
+			return err
+		}
+		credits += dh.estimatedCredits()
+	}
+	fmt.Printf("\n[*] %d Targets, estimated cost up to %d credits\n", len(b.targets), credits)
+	return nil
+}
+
+// Start queries the targets on up to the configured number of workers, each target
+// requesting its pages one at a time. Failures of single targets are recorded and the
+// batch continues, while authentication, credit, budget and cancellation errors stop it.
+func (b *Batch) Start(ctx context.Context) error {
+	b.Results = make([]BatchResult, len(b.targets))
+	var mu sync.Mutex // Serializes progress output of concurrent targets
+
+	// Targets write to the combined output in the order of the input, each result once
+	var combined *orderedSink
+	if !b.splitOutput {
+		sink, err := newExportSink(b.options.OutputFile, b.options.OutputFormat, export.Unique, b.options.CredsOnly)
+		if err != nil {
+			return wrapError("failed to create output file", err)
+		}
+		combined = newOrderedSink(sink, 0)
+	}
+
+	fatal := runWorkers(ctx, len(b.targets), b.concurrency, func(ctx context.Context, i int) error {
+		target := b.targets[i]
+		mu.Lock()
+		fmt.Printf("\n[*] Target %d/%d: %s\n", i+1, len(b.targets), target)
+		mu.Unlock()
+
+		result := BatchResult{Target: target, started: true}
+		dh, err := b.newDehasher(i)
+		if err == nil {
+			dh.SetClientCredentials(b.key)
+			dh.SetRateLimiter(b.limiter)
+			dh.SetBudget(b.budget)
+			dh.SetCachePolicy(b.cache)
+			dh.SetLocalPolicy(b.local)
+			if b.retry != nil {
+				dh.SetRetryPolicy(b.retry)
+			}
+			if combined != nil {
+				dh.SetSharedOutput(combined.Part(i))
+			}
+			err = dh.Start(ctx)
+			result.RunID = dh.RunID()
+			result.Records = dh.Retrieved()
+		}
+		if combined != nil {
+			// A target that failed before writing its output must not hold back the targets after it
+			if closeErr := combined.Part(i).Close(context.WithoutCancel(ctx)); err == nil && closeErr != nil {
+				err = wrapError("failed to write combined output", closeErr)
+			}
+		}
+		result.Err = err
+		b.Results[i] = result
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			zap.L().Error("batch_target",
+				zap.String("message", "target failed"),
+				zap.Int("line", target.Line),
+				zap.Error(err),
+			)
+			fmt.Printf("\n[!] Target %d/%d failed: %v\n", i+1, len(b.targets), err)
+			if batchFatal(err) {
+				return err
+			}
+			return nil
+		}
+		fmt.Printf("[+] Target %d/%d: %d Records\n", i+1, len(b.targets), result.Records)
+		return nil
+	})
+
+	if combined != nil {
+		// The combined output holds what every target retrieved, even when the batch was cancelled
+		if err := combined.Close(context.WithoutCancel(ctx)); err != nil {
+			zap.L().Error("batch_export",
+				zap.String("message", "failed to write combined output"),
+				zap.Error(err),
+			)
+			fmt.Printf("\n[!] Error writing combined output: %v\n", err)
+		}
+	}
+	b.printSummary()
+	if fatal != nil {
+		return fatal
+	}
+
+	failed := 0
+	for _, result := range b.Results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return &DehashError{Message: fmt.Sprintf("%d of %d targets failed", failed, len(b.targets)), Code: -1}
+	}
+	return nil
+}
+
+// printSummary prints the per target record counts
+func (b *Batch) printSummary() {
+	total := 0
+	fmt.Printf("\n[*] Batch Summary\n")
+	fmt.Printf("%-6s %-7s %-9s %-40s %s\n", "Line", "Run", "Records", "Target", "Status")
+	queried := 0
+	for _, result := range b.Results {
+		if !result.started {
+			continue
+		}
+		queried++
+		status := "ok"
+		if result.Err != nil {
+			status = "failed"
+		}
+		total += result.Records
+		fmt.Printf("%-6d %-7d %-9d %-40s %s\n", result.Target.Line, result.RunID, result.Records, result.Target.String(), status)
+	}
+	fmt.Printf("[*] %d of %d Targets queried, %d Records\n", queried, len(b.targets), total)
+}
+
+// batchFatal reports whether an error will also affect every remaining target
+func batchFatal(err error) bool {
+	return errors.Is(err, context.Canceled) ||
+		errors.Is(err, ErrUnauthorized) ||
+		errors.Is(err, ErrInsufficientCredits) ||
+		errors.Is(err, ErrBudgetExceeded)
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// outputSuffix returns a file name safe representation of a target
+func outputSuffix(t Target) string {
+	values := make([]string, 0, len(t.Fields))
+	for _, f := range t.Fields {
+		values = append(values, f.Value)
+	}
+	suffix := strings.Trim(unsafeFileChars.ReplaceAllString(strings.Join(values, "_"), "_"), "_")
+	if len(suffix) > 64 {
+		suffix = suffix[:64]
+	}
+	return suffix
+}
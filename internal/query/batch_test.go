@@ -0,0 +1,190 @@
+package query_test
+
+import (
+	"Dehash/internal/files"
+	"Dehash/internal/mock"
+	"Dehash/internal/query"
+	"Dehash/internal/sqlite"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func emailTargets(n int) []query.Target {
+	targets := make([]query.Target, n)
+	for i := range targets {
+		targets[i] = query.Target{
+			Line:   i + 1,
+			Fields: []query.TargetField{{Param: query.Email, Value: fmt.Sprintf("user%d@generated.example", i+1)}},
+		}
+	}
+	return targets
+}
+
+func batchOptions(t *testing.T) *sqlite.QueryOptions {
+	return &sqlite.QueryOptions{
+		MaxRecords:   100,
+		MaxRequests:  -1,
+		StartingPage: 1,
+		OutputFormat: files.JSON,
+		OutputFile:   filepath.Join(t.TempDir(), "batch"),
+	}
+}
+
+func TestBatchConcurrentTargetsKeepOrder(t *testing.T) {
+	initDB(t)
+	// The first target answers last, so targets after it finish first
+	mockAPI(t, 8, 100, func(w http.ResponseWriter, r *http.Request, req query.DehashedSearchRequest) bool {
+		if strings.Contains(req.Query, "user1@") {
+			time.Sleep(300 * time.Millisecond)
+		}
+		return false
+	})
+
+	options := batchOptions(t)
+	batch := query.NewBatch(options, emailTargets(8), false)
+	batch.SetConcurrency(4)
+	batch.SetClientCredentials(mock.DefaultAPIKey)
+	if err := batch.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	for i, result := range batch.Results {
+		if result.Target.Line != i+1 || result.Records != 1 || result.Err != nil {
+			t.Errorf("result %d = line %d, %d records, error %v; want line %d, 1 record", i, result.Target.Line, result.Records, result.Err, i+1)
+		}
+	}
+	assertIDs(t, readOutputIDs(t, options.OutputFile+".json"), generatedIDs(1, 8))
+}
+
+func TestBatchFatalErrorCancelsTargets(t *testing.T) {
+	initDB(t)
+	mockAPI(t, 8, 100, func(w http.ResponseWriter, r *http.Request, req query.DehashedSearchRequest) bool {
+		switch {
+		case strings.Contains(req.Query, "user2@"):
+			time.Sleep(50 * time.Millisecond)
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"error": "Insufficient Credits"}`)
+			return true
+		case strings.Contains(req.Query, "user1@"):
+			select {
+			case <-r.Context().Done():
+			case <-time.After(10 * time.Second):
+			}
+			return true
+		}
+		return false
+	})
+
+	batch := query.NewBatch(batchOptions(t), emailTargets(8), false)
+	batch.SetConcurrency(2)
+	batch.SetRetryPolicy(query.NewRetryPolicy(0, time.Millisecond, time.Millisecond))
+	batch.SetClientCredentials(mock.DefaultAPIKey)
+
+	start := time.Now()
+	err := batch.Start(context.Background())
+	if !errors.Is(err, query.ErrInsufficientCredits) {
+		t.Fatalf("Start error = %v, want ErrInsufficientCredits", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("failed after %s, want the hanging target cancelled", elapsed)
+	}
+	for _, result := range batch.Results[2:] {
+		if result.RunID != 0 {
+			t.Errorf("target on line %d ran after the batch failed", result.Target.Line)
+		}
+	}
+}
+
+func TestBatchSharesRateLimiter(t *testing.T) {
+	const (
+		rate    = 20
+		targets = 6
+	)
+	initDB(t)
+	var (
+		mu    sync.Mutex
+		times []time.Time
+	)
+	mockAPI(t, targets, 100, func(w http.ResponseWriter, r *http.Request, req query.DehashedSearchRequest) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		times = append(times, time.Now())
+		return false
+	})
+
+	batch := query.NewBatch(batchOptions(t), emailTargets(targets), false)
+	batch.SetConcurrency(targets)
+	batch.SetRateLimiter(query.NewRateLimiter(rate, 1))
+	batch.SetClientCredentials(mock.DefaultAPIKey)
+	if err := batch.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	if len(times) != targets {
+		t.Fatalf("server saw %d searches, want %d", len(times), targets)
+	}
+	first, last := times[0], times[0]
+	for _, at := range times {
+		if at.Before(first) {
+			first = at
+		}
+		if at.After(last) {
+			last = at
+		}
+	}
+	want := (targets - 1) * time.Second / rate
+	if span := last.Sub(first); span < want*9/10 {
+		t.Errorf("%d searches took %s, want at least %s at %d per second", targets, span, want, rate)
+	}
+}
+
+func TestBatchSharesBudget(t *testing.T) {
+	initDB(t)
+	// The probe leaves 19 credits, so a budget of 15 allows 4 of the 8 single page targets
+	var (
+		mu       sync.Mutex
+		probes   int
+		searched []string
+	)
+	mockAPI(t, 8, 20, func(w http.ResponseWriter, r *http.Request, req query.DehashedSearchRequest) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if req.Size == 1 {
+			probes++
+		} else {
+			searched = append(searched, req.Query)
+		}
+		return false
+	})
+
+	options := batchOptions(t)
+	options.Budget = 15
+	batch := query.NewBatch(options, emailTargets(8), false)
+	batch.SetConcurrency(4)
+	batch.SetClientCredentials(mock.DefaultAPIKey)
+
+	err := batch.Start(context.Background())
+	if !errors.Is(err, query.ErrBudgetExceeded) {
+		t.Fatalf("Start error = %v, want ErrBudgetExceeded", err)
+	}
+	if probes != 1 {
+		t.Errorf("sent %d balance probes, want 1 for the whole batch", probes)
+	}
+	if len(searched) != 4 {
+		t.Errorf("searched %d targets, want 4 within the budget: %v", len(searched), searched)
+	}
+	records := 0
+	for _, result := range batch.Results {
+		records += result.Records
+	}
+	if records != 4 {
+		t.Errorf("batch retrieved %d records, want 4", records)
+	}
+}
@@ -0,0 +1,110 @@
+package query
+
+import (
+	"context"
+	"sync"
+)
+
+// Budget keeps the balance from dropping below a floor of credits. One budget is shared
+// by every request of a query, or of every target of a batch, so requests sent at the same
+// time never overspend together. A nil budget allows every request.
+type Budget struct {
+	floor int
+
+	learning sync.Mutex // Held while the balance is learned, so it is learned once
+	mu       sync.Mutex
+	balance  int
+	known    bool
+	reserved int // Credits of requests in flight
+}
+
+// NewBudget creates a budget keeping the balance at or above floor, or nil when floor is not positive
+func NewBudget(floor int) *Budget {
+	if floor <= 0 {
+		return nil
+	}
+	return &Budget{floor: floor}
+}
+
+// Floor returns the number of credits the budget keeps
+func (b *Budget) Floor() int {
+	if b == nil {
+		return 0
+	}
+	return b.floor
+}
+
+// Balance returns the lowest balance reported so far
+func (b *Budget) Balance() int {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.balance
+}
+
+// learn calls probe to learn the balance unless it is known already. Concurrent callers
+// wait for the first probe rather than sending their own.
+func (b *Budget) learn(ctx context.Context, probe func(ctx context.Context) (int, error)) error {
+	if b == nil {
+		return nil
+	}
+	b.learning.Lock()
+	defer b.learning.Unlock()
+
+	b.mu.Lock()
+	known := b.known
+	b.mu.Unlock()
+	if known {
+		return nil
+	}
+
+	balance, err := probe(ctx)
+	if err != nil {
+		return err
+	}
+	b.update(balance)
+	return nil
+}
+
+// reserve takes the credits of a request before it is sent, reporting false when the
+// request could drop the balance below the floor. The balance must have been learned.
+// Credits stay reserved until release, after the response reported the new balance.
+func (b *Budget) reserve() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.known || b.balance-b.reserved-CreditsPerRequest < b.floor {
+		return false
+	}
+	b.reserved += CreditsPerRequest
+	return true
+}
+
+// release returns the credits reserved for a request once it is done
+func (b *Budget) release() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reserved -= CreditsPerRequest
+}
+
+// update records a balance reported by a response. Responses of concurrent requests
+// arrive out of order, so the lowest balance is the latest.
+func (b *Budget) update(balance int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.known || balance < b.balance {
+		b.balance = balance
+		b.known = true
+	}
+}
@@ -0,0 +1,113 @@
+package query
+
+import (
+	"Dehash/internal/sqlite"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultCacheTTL is how long search responses are reused before they are requested again
+const DefaultCacheTTL = 7 * 24 * time.Hour
+
+// CachePolicy controls how search responses are cached. A nil policy disables the cache.
+type CachePolicy struct {
+	TTL     time.Duration
+	Refresh bool // Ignore cached responses, but cache the new ones
+}
+
+// NewCachePolicy creates a cache policy; a non-positive ttl uses DefaultCacheTTL
+func NewCachePolicy(ttl time.Duration, refresh bool) *CachePolicy {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return &CachePolicy{TTL: ttl, Refresh: refresh}
+}
+
+// CacheKey returns the key a search request is cached under, a hash of its normalized
+// query, page, size, regex, wildcard and de_dupe fields. The API address is included so
+// responses of a mock server are never served for the real API.
+func CacheKey(baseURL string, req DehashedSearchRequest) string {
+	normalized, _ := json.Marshal(struct {
+		BaseURL  string `json:"base_url"`
+		Query    string `json:"query"`
+		Page     int    `json:"page"`
+		Size     int    `json:"size"`
+		Regex    bool   `json:"regex"`
+		Wildcard bool   `json:"wildcard"`
+		DeDupe   bool   `json:"de_dupe"`
+	}{
+		BaseURL:  strings.TrimRight(baseURL, "/"),
+		Query:    strings.Join(strings.Fields(req.Query), " "),
+		Page:     req.Page,
+		Size:     req.Size,
+		Regex:    req.Regex,
+		Wildcard: req.Wildcard,
+		DeDupe:   req.DeDupe,
+	})
+	sum := sha256.Sum256(normalized)
+	return hex.EncodeToString(sum[:])
+}
+
+// lookup returns a reader of the response cached for a key, if the policy allows reading one
+func (cp *CachePolicy) lookup(ctx context.Context, key string) (io.Reader, bool) {
+	if cp == nil || cp.Refresh {
+		return nil, false
+	}
+
+	_, err := sqlite.GetCachedResponse(ctx, key)
+	if err == nil {
+		var body io.Reader
+		if body, err = sqlite.OpenCachedBody(ctx, key); err == nil {
+			return body, true
+		}
+	}
+	if !errors.Is(err, sqlite.ErrCacheMiss) {
+		zap.L().Warn("cache_lookup", zap.String("key", key), zap.Error(err))
+	}
+	return nil, false
+}
+
+// has reports whether a response for the key would be served from the cache
+func (cp *CachePolicy) has(ctx context.Context, key string) bool {
+	return cp != nil && !cp.Refresh && sqlite.HasCachedResponse(ctx, key)
+}
+
+// store caches the raw body of a successful response, spooled to a temporary file which
+// it removes. Failing to cache never fails the search.
+func (cp *CachePolicy) store(ctx context.Context, key, baseURL string, req DehashedSearchRequest, total, entries int, spool *os.File) {
+	defer removeSpool(spool)
+	if cp == nil || spool == nil {
+		return
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		zap.L().Warn("cache_store", zap.String("key", key), zap.Error(err))
+		return
+	}
+
+	now := time.Now()
+	err := sqlite.StoreCachedResponse(context.WithoutCancel(ctx), &sqlite.CachedResponse{
+		Key:          key,
+		BaseURL:      baseURL,
+		Query:        req.Query,
+		Page:         req.Page,
+		Size:         req.Size,
+		Regex:        req.Regex,
+		Wildcard:     req.Wildcard,
+		DeDupe:       req.DeDupe,
+		TotalResults: total,
+		Entries:      entries,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(cp.TTL),
+	}, spool)
+	if err != nil {
+		zap.L().Warn("cache_store", zap.String("key", key), zap.Error(err))
+	}
+}
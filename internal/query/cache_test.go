@@ -0,0 +1,53 @@
+package query_test
+
+import (
+	"Dehash/internal/query"
+	"Dehash/internal/sqlite"
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+// sourceSink records the source of every result written to it
+type sourceSink struct {
+	sources []string
+}
+
+func (s *sourceSink) Write(ctx context.Context, result sqlite.Result) error {
+	s.sources = append(s.sources, result.Source)
+	return nil
+}
+
+func (s *sourceSink) Flush(ctx context.Context) error { return nil }
+func (s *sourceSink) Close(ctx context.Context) error { return nil }
+
+func TestSearchServesSpooledResponseFromCache(t *testing.T) {
+	initDB(t)
+	var searches atomic.Int32
+	pagedAPI(t, 5000, 5000, 100, func(w http.ResponseWriter, r *http.Request, req query.DehashedSearchRequest) bool {
+		searches.Add(1)
+		return false
+	})
+
+	request := *query.NewDehashedSearchRequest(1, 5000, false, false, false).Add(query.Email, "@paged.example")
+	for i, want := range []string{sqlite.SourceAPI, sqlite.SourceCache} {
+		client := query.NewDehashedClientV2("test-key")
+		client.SetCachePolicy(query.NewCachePolicy(0, false))
+
+		sink := &sourceSink{}
+		total, err := client.Search(context.Background(), request, sink)
+		if err != nil {
+			t.Fatalf("search %d: %v", i+1, err)
+		}
+		if total != 5000 || len(sink.sources) != 5000 {
+			t.Fatalf("search %d returned %d of %d results, want 5000", i+1, len(sink.sources), total)
+		}
+		if sink.sources[0] != want || sink.sources[4999] != want {
+			t.Errorf("search %d served results from %s, want %s", i+1, sink.sources[0], want)
+		}
+	}
+	if got := searches.Load(); got != 1 {
+		t.Errorf("sent %d searches, want the second served from the cache", got)
+	}
+}
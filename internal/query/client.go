@@ -2,11 +2,11 @@ package query
 
 import (
 	"Dehash/internal/sqlite"
+	"Dehash/pkg/dehashed"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
 )
 
@@ -69,12 +69,11 @@ func (dc *DehashedClient) setPage(page int) {
 	dc.query = fmt.Sprintf("%s&nextPage=%d", dc.query, page)
 }
 
-func (dc *DehashedClient) Do() int {
+func (dc *DehashedClient) Do() (int, error) {
 	fmt.Printf("\n\t[*] Performing Request...")
 	req, err := http.NewRequest("GET", dc.query, nil)
 	if err != nil {
-		fmt.Printf("[!] Error constructing request: %v", err)
-		os.Exit(-1)
+		return -1, wrapError("failed to construct request", err)
 	}
 
 	dc.setAuth(req)
@@ -82,24 +81,26 @@ func (dc *DehashedClient) Do() int {
 	req.Header.Add("Accept", "application/json")
 	resp, err := dc.client.Do(req)
 	if err != nil {
-		fmt.Printf("[!] Error performing request: %s\n%v", dc.query, err)
-		os.Exit(-1)
+		return -1, wrapError(fmt.Sprintf("failed to perform request: %s", dc.query), err)
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		dhErr := GetDehashedError(resp.StatusCode)
-		fmt.Println()
-		log.Fatal(dhErr.Error())
+		body, _ := io.ReadAll(resp.Body)
+		return -1, dehashed.NewResponseError(resp, body)
 	}
 
-	entries, balance, total := sqlite.NewDehashedResults(resp.Body)
+	entries, balance, total, err := sqlite.NewDehashedResults(resp.Body)
+	if err != nil {
+		return -1, wrapError("failed to parse response", err)
+	}
 	dc.results = append(dc.results, entries...)
 	dc.balance = balance
 	dc.total += total
 	if dc.printBal {
 		fmt.Printf("\n\t\t[*] Balance Remaining: %d", balance)
 	}
-	return total
+	return total, nil
 }
 
 func (dc *DehashedClient) setAuth(r *http.Request) {
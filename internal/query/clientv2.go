@@ -2,192 +2,319 @@ package query
 
 import (
 	"Dehash/internal/sqlite"
-	"bytes"
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
-	"errors"
+	"Dehash/pkg/dehashed"
+	"context"
 	"fmt"
 	"go.uber.org/zap"
 	"io"
 	"net/http"
+	"os"
 	"strings"
+	"sync"
+	"time"
 )
 
-type DehashedParameter string
+// DefaultBaseURL is the address of the Dehashed API
+const DefaultBaseURL = dehashed.DefaultBaseURL
 
-const (
-	Username       DehashedParameter = "username"
-	Email          DehashedParameter = "email"
-	Password       DehashedParameter = "password"
-	HashedPassword DehashedParameter = "hashed_password"
-	Name           DehashedParameter = "name"
-	IpAddress      DehashedParameter = "ip_address"
-	Domain         DehashedParameter = "domain"
-	Vin            DehashedParameter = "vin"
-	LicensePlate   DehashedParameter = "license_plate"
-	Address        DehashedParameter = "address"
-	Phone          DehashedParameter = "phone"
-	Social         DehashedParameter = "social"
-	CryptoAddress  DehashedParameter = "cryptocurrency_address"
+var (
+	apiBaseURL = DefaultBaseURL     // Address new clients send requests to
+	httpClient = http.DefaultClient // HTTP client new clients send requests with
 )
 
-func (dp DehashedParameter) GetArgumentString(arg string) string {
-	return fmt.Sprintf("%s:%s", string(dp), arg)
+// SetBaseURL sets the API address used by new clients, e.g. to point them at a mock server
+func SetBaseURL(baseURL string) {
+	apiBaseURL = strings.TrimRight(baseURL, "/")
 }
 
-type DehashedSearchRequest struct {
-	ForcePlaintext bool   `json:"-"`
-	Page           int    `json:"page"`
-	Query          string `json:"query"`
-	Size           int    `json:"size"`
-	Wildcard       bool   `json:"wildcard"`
-	Regex          bool   `json:"regex"`
-	DeDupe         bool   `json:"de_dupe"`
+// SetHTTPClient sets the HTTP client used by new clients, e.g. to record or replay responses
+func SetHTTPClient(client *http.Client) {
+	httpClient = client
 }
 
-func NewDehashedSearchRequest(page, size int, wildcard, regex, forcePlaintext bool) *DehashedSearchRequest {
-	return &DehashedSearchRequest{Page: page, Query: "", Size: size, Wildcard: wildcard, Regex: regex, DeDupe: true, ForcePlaintext: forcePlaintext}
-}
+// RateLimiter limits how often requests are sent, see dehashed.RateLimiter
+type RateLimiter = dehashed.RateLimiter
 
-func (dsr *DehashedSearchRequest) buildQuery(query string, param DehashedParameter) {
-	if len(dsr.Query) > 0 {
-		dsr.Query = fmt.Sprintf("%s&%s", strings.TrimSpace(dsr.Query), strings.TrimSpace(query))
-	} else {
-		dsr.Query = query
-	}
+// NewRateLimiter creates a limiter allowing rate requests per second with bursts of up to
+// burst requests. A non-positive rate returns nil, which never limits.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return dehashed.NewRateLimiter(rate, burst)
 }
 
-func (dsr *DehashedSearchRequest) AddUsernameQuery(query string) {
-	query = strings.TrimSpace(query)
-	dsr.buildQuery(Username.GetArgumentString(query), Username)
-}
+// DehashedParameter is a field of the search API, see dehashed.Parameter
+type DehashedParameter = dehashed.Parameter
 
-func (dsr *DehashedSearchRequest) AddEmailQuery(query string) {
-	query = strings.TrimSpace(query)
-	dsr.buildQuery(Email.GetArgumentString(query), Email)
-}
+const (
+	Username       = dehashed.Username
+	Email          = dehashed.Email
+	Password       = dehashed.Password
+	HashedPassword = dehashed.HashedPassword
+	Name           = dehashed.Name
+	IpAddress      = dehashed.IpAddress
+	Domain         = dehashed.Domain
+	Vin            = dehashed.Vin
+	LicensePlate   = dehashed.LicensePlate
+	Address        = dehashed.Address
+	Phone          = dehashed.Phone
+	Social         = dehashed.Social
+	CryptoAddress  = dehashed.CryptoAddress
+)
 
-func (dsr *DehashedSearchRequest) AddIpAddressQuery(query string) {
-	query = strings.TrimSpace(query)
-	dsr.buildQuery(IpAddress.GetArgumentString(query), IpAddress)
-}
+// DehashedSearchRequest is the body of a search, see dehashed.SearchRequest
+type DehashedSearchRequest = dehashed.SearchRequest
 
-func (dsr *DehashedSearchRequest) AddDomainQuery(query string) {
-	query = strings.TrimSpace(query)
-	dsr.buildQuery(Domain.GetArgumentString(query), Domain)
+func NewDehashedSearchRequest(page, size int, wildcard, regex, forcePlaintext bool) *DehashedSearchRequest {
+	return dehashed.NewSearchRequest(page, size, wildcard, regex, forcePlaintext)
 }
 
-func (dsr *DehashedSearchRequest) AddPasswordQuery(query string) {
-	if dsr.ForcePlaintext {
-		dsr.buildQuery(Password.GetArgumentString(query), Password)
-		return
+// setExpression replaces the query of a request with a compiled query expression and the
+// match flags it needs
+func setExpression(dsr *DehashedSearchRequest, expr string) error {
+	compiled, err := CompileExpression(expr, dsr.ForcePlaintext)
+	if err != nil {
+		return err
 	}
-	hash := sha256.Sum256([]byte(query))
-	query = hex.EncodeToString(hash[:])
-	dsr.AddHashedPasswordQuery(query)
+	dsr.Query = compiled.Query
+	dsr.Regex = compiled.Regex
+	dsr.Wildcard = compiled.Wildcard
+	return nil
 }
 
-func (dsr *DehashedSearchRequest) AddVinQuery(query string) {
-	query = strings.TrimSpace(query)
-	dsr.buildQuery(Vin.GetArgumentString(query), Vin)
-}
+// DehashedClientV2 searches page by page with the public client, retrying and caching
+// responses, and is safe for concurrent searches of different pages. Results are passed
+// to a sink as they are decoded; only their number is kept.
+type DehashedClientV2 struct {
+	api   *dehashed.Client
+	retry *RetryPolicy
+	cache *CachePolicy
 
-func (dsr *DehashedSearchRequest) AddLicensePlateQuery(query string) {
-	query = strings.TrimSpace(query)
-	dsr.buildQuery(LicensePlate.GetArgumentString(query), LicensePlate)
+	mu         sync.Mutex
+	pages      map[int]int  // Results retrieved by page
+	cached     map[int]bool // Pages served from the response cache
+	balance    int
+	hasBalance bool
 }
 
-func (dsr *DehashedSearchRequest) AddAddressQuery(query string) {
-	query = strings.TrimSpace(query)
-	dsr.buildQuery(Address.GetArgumentString(query), Address)
+func NewDehashedClientV2(apiKey string) *DehashedClientV2 {
+	api := dehashed.New(
+		dehashed.WithAPIKey(apiKey),
+		dehashed.WithBaseURL(apiBaseURL),
+		dehashed.WithHTTPClient(httpClient),
+		dehashed.WithLogger(zap.L()),
+	)
+	return &DehashedClientV2{api: api, retry: DefaultRetryPolicy(), pages: make(map[int]int), cached: make(map[int]bool)}
 }
 
-func (dsr *DehashedSearchRequest) AddPhoneQuery(query string) {
-	query = strings.TrimSpace(query)
-	dsr.buildQuery(Phone.GetArgumentString(query), Phone)
+// SetBaseURL sets the API address the client sends requests to
+func (dcv2 *DehashedClientV2) SetBaseURL(baseURL string) {
+	dcv2.api = dcv2.api.With(dehashed.WithBaseURL(baseURL))
 }
 
-func (dsr *DehashedSearchRequest) AddSocialQuery(query string) {
-	query = strings.TrimSpace(query)
-	dsr.buildQuery(Social.GetArgumentString(query), Social)
+// SetRateLimiter sets the limiter every request waits on, which may be shared between clients
+func (dcv2 *DehashedClientV2) SetRateLimiter(limiter *RateLimiter) {
+	dcv2.api = dcv2.api.With(dehashed.WithRateLimiter(limiter))
 }
 
-func (dsr *DehashedSearchRequest) AddCryptoAddressQuery(query string) {
-	query = strings.TrimSpace(query)
-	dsr.buildQuery(CryptoAddress.GetArgumentString(query), CryptoAddress)
+// SetRetryPolicy sets the policy used to retry rate limited and failed requests
+func (dcv2 *DehashedClientV2) SetRetryPolicy(policy *RetryPolicy) {
+	if policy == nil {
+		policy = NewRetryPolicy(0, 0, 0)
+	}
+	dcv2.retry = policy
 }
 
-func (dsr *DehashedSearchRequest) AddHashedPasswordQuery(query string) {
-	dsr.buildQuery(HashedPassword.GetArgumentString(query), HashedPassword)
+// SetCachePolicy sets how search responses are cached, nil disables the cache
+func (dcv2 *DehashedClientV2) SetCachePolicy(policy *CachePolicy) {
+	dcv2.cache = policy
 }
 
-func (dsr *DehashedSearchRequest) AddNameQuery(query string) {
-	query = strings.TrimSpace(query)
-	dsr.buildQuery(Name.GetArgumentString(query), Name)
-}
+// Search performs a search request, passing every result to sink as it is decoded and
+// retrying retryable failures according to the retry policy. Responses cached for the same
+// request are used instead when the cache policy allows it.
+//
+// A response cut off midway may already have passed some results to the sink; the attempt
+// that follows skips as many results, so the sink sees every result of the page once.
+func (dcv2 *DehashedClientV2) Search(ctx context.Context, searchRequest DehashedSearchRequest, sink Sink) (int, error) {
+	page := pageStream{ctx: ctx, sink: sink}
 
-type DehashedClientV2 struct {
-	apiKey  string
-	results []sqlite.Result
-}
+	cacheKey := CacheKey(dcv2.api.BaseURL(), searchRequest)
+	if body, ok := dcv2.cache.lookup(ctx, cacheKey); ok {
+		response, err := dehashed.DecodeSearchResponse(body, page.deliver(sqlite.SourceCache))
+		if err == nil {
+			dcv2.addPage(searchRequest.Page, page.delivered, response.Balance, true)
+			return response.TotalResults, nil
+		}
+		if page.sinkErr != nil {
+			return -1, wrapError("failed to write results", page.sinkErr)
+		}
+		zap.L().Warn("cache_lookup",
+			zap.String("message", "ignoring unreadable cached response"),
+			zap.String("key", cacheKey),
+			zap.Error(err),
+		)
+	}
 
-func NewDehashedClientV2(apiKey string) *DehashedClientV2 {
-	return &DehashedClientV2{apiKey: apiKey}
+	for retry := 1; ; retry++ {
+		response, spool, err := dcv2.stream(ctx, searchRequest, &page)
+		if err == nil {
+			dcv2.addPage(searchRequest.Page, page.delivered, response.Balance, false)
+			dcv2.cache.store(ctx, cacheKey, dcv2.api.BaseURL(), searchRequest, response.TotalResults, page.delivered, spool)
+			return response.TotalResults, nil
+		}
+
+		if page.sinkErr != nil {
+			return -1, wrapError("failed to write results", page.sinkErr)
+		}
+		if ctx.Err() != nil {
+			return -1, wrapError("search cancelled", ctx.Err())
+		}
+		if !dcv2.retry.shouldRetry(retry, err) {
+			return -1, err
+		}
+
+		wait := dcv2.retry.backoff(retry, retryAfter(err))
+		zap.L().Warn("v2_search",
+			zap.String("message", "retrying request"),
+			zap.Int("retry", retry),
+			zap.Duration("wait", wait),
+			zap.Error(err),
+		)
+		fmt.Printf("\n\t\t[!] %v, retrying in %s (%d/%d)", err, wait.Round(time.Millisecond), retry, dcv2.retry.MaxRetries)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return -1, wrapError("search cancelled", ctx.Err())
+		case <-timer.C:
+		}
+	}
 }
 
-func (dcv2 *DehashedClientV2) Search(searchRequest DehashedSearchRequest) (int, error) {
-	reqBody, _ := json.Marshal(searchRequest)
-	req, err := http.NewRequest("POST", "https://api.dehashed.com/v2/search", bytes.NewReader(reqBody))
+// stream performs a single attempt of a search, decoding the response as it arrives. When
+// responses are cached, the raw body is spooled to a temporary file rather than kept in
+// memory; the caller passes it on to the cache, which removes it.
+func (dcv2 *DehashedClientV2) stream(ctx context.Context, searchRequest DehashedSearchRequest, page *pageStream) (*dehashed.SearchResponse, *os.File, error) {
+	body, err := dcv2.api.SearchBody(ctx, &searchRequest)
 	if err != nil {
-		return -1, err
+		return nil, nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Dehashed-Api-Key", dcv2.apiKey)
-	res, err := http.DefaultClient.Do(req)
-	if res != nil {
-		defer res.Body.Close()
+	defer body.Close()
+
+	var reader io.Reader = body
+	var spool *os.File
+	if dcv2.cache != nil {
+		// Caching is best effort, the search goes on without it
+		if spool, err = os.CreateTemp("", "dehasher-response-*.json"); err != nil {
+			zap.L().Warn("cache_spool", zap.Error(err))
+			spool = nil
+		} else {
+			reader = io.TeeReader(body, spool)
+		}
 	}
+	response, err := dehashed.DecodeSearchResponse(reader, page.deliver(sqlite.SourceAPI))
 	if err != nil {
-		zap.L().Error("v2_search",
-			zap.String("message", "failed to perform request"),
-			zap.Error(err),
-		)
-		return -1, err
+		removeSpool(spool)
+		return nil, nil, err
 	}
-	if res == nil {
-		zap.L().Error("v2_search",
-			zap.String("message", "response was nil"),
-		)
-		return -1, errors.New("response was nil")
+	return response, spool, nil
+}
+
+// removeSpool closes and deletes a spooled response body
+func removeSpool(spool *os.File) {
+	if spool == nil {
+		return
 	}
-	b, err := io.ReadAll(res.Body)
-	if err != nil {
-		zap.L().Error("v2_search",
-			zap.String("message", "failed to read response body"),
-			zap.Error(err),
-		)
-		return -1, err
+	spool.Close()
+	os.Remove(spool.Name())
+}
+
+// pageStream passes the results of a page to a sink across attempts
+type pageStream struct {
+	ctx       context.Context
+	sink      Sink
+	delivered int   // Results passed to the sink by all attempts
+	sinkErr   error // Set when the sink failed, which is not worth retrying
+}
+
+// deliver returns the callback of an attempt, skipping the results earlier attempts delivered.
+// Results are stamped with the time they were retrieved, as stored in the database.
+func (ps *pageStream) deliver(source string) func(dehashed.Result) error {
+	index := 0
+	retrieved := time.Now()
+	return func(entry dehashed.Result) error {
+		index++
+		if index <= ps.delivered {
+			return nil
+		}
+		result := sqlite.NewResult(entry, source)
+		result.CreatedAt, result.UpdatedAt = retrieved, retrieved
+		if err := ps.sink.Write(ps.ctx, result); err != nil {
+			ps.sinkErr = err
+			return err
+		}
+		ps.delivered++
+		return nil
 	}
+}
 
-	var responseResults sqlite.DehashedResponse
-	err = json.Unmarshal(b, &responseResults)
-	if err != nil {
-		zap.L().Error("v2_search",
-			zap.String("message", "failed to unmarshal response body"),
-			zap.Error(err),
-		)
-		return -1, err
+// addPage records the number of results of a page and the balance reported with them. The
+// balance of a cached response is out of date, so it is ignored.
+func (dcv2 *DehashedClientV2) addPage(page, results, balance int, fromCache bool) {
+	dcv2.mu.Lock()
+	defer dcv2.mu.Unlock()
+
+	dcv2.pages[page] = results
+	dcv2.cached[page] = fromCache
+	if fromCache {
+		return
+	}
+	// Responses of concurrent searches arrive out of order, the lowest balance is the latest
+	if !dcv2.hasBalance || balance < dcv2.balance {
+		dcv2.balance = balance
+		dcv2.hasBalance = true
+	}
+}
+
+func (dcv2 *DehashedClientV2) GetTotalResults() int {
+	dcv2.mu.Lock()
+	defer dcv2.mu.Unlock()
+
+	total := 0
+	for _, results := range dcv2.pages {
+		total += results
 	}
+	return total
+}
 
-	dcv2.results = append(dcv2.results, responseResults.Entries...)
-	return responseResults.TotalResults, nil
+// GetPageResults returns the number of results retrieved for a page
+func (dcv2 *DehashedClientV2) GetPageResults(page int) int {
+	dcv2.mu.Lock()
+	defer dcv2.mu.Unlock()
+	return dcv2.pages[page]
 }
 
-func (dcv2 *DehashedClientV2) GetResults() sqlite.DehashedResults {
-	return sqlite.DehashedResults{Results: dcv2.results}
+// FromCache reports whether a page was served from the response cache, costing no credits
+func (dcv2 *DehashedClientV2) FromCache(page int) bool {
+	dcv2.mu.Lock()
+	defer dcv2.mu.Unlock()
+	return dcv2.cached[page]
 }
 
-func (dcv2 *DehashedClientV2) GetTotalResults() int {
-	return len(dcv2.results)
+// ProbeBalance sends a search for a single result of the request, bypassing the cache,
+// and returns the balance the API reports. It costs one request but makes the balance
+// known before a page is requested. The probe is not counted as a retrieved page.
+func (dcv2 *DehashedClientV2) ProbeBalance(ctx context.Context, searchRequest DehashedSearchRequest) (int, error) {
+	probe := &DehashedClientV2{api: dcv2.api, retry: dcv2.retry, pages: make(map[int]int), cached: make(map[int]bool)}
+	searchRequest.Size = 1
+	if _, err := probe.Search(ctx, searchRequest, discardSink{}); err != nil {
+		return -1, err
+	}
+	return probe.GetBalance(), nil
+}
+
+// GetBalance returns the lowest balance reported by a successful search
+func (dcv2 *DehashedClientV2) GetBalance() int {
+	dcv2.mu.Lock()
+	defer dcv2.mu.Unlock()
+	return dcv2.balance
 }
@@ -3,109 +3,494 @@ package query
 import (
 	"Dehash/internal/export"
 	"Dehash/internal/sqlite"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"go.uber.org/zap"
-	"os"
+	"sync"
+	"sync/atomic"
 )
 
 // Dehasher is a struct for querying the Dehashed API
 type Dehasher struct {
-	options  sqlite.QueryOptions
-	nextPage int
-	request  *DehashedSearchRequest
-	client   *DehashedClientV2
+	options     sqlite.QueryOptions
+	plan        *PaginationPlan
+	request     *DehashedSearchRequest
+	client      *DehashedClientV2
+	retry       *RetryPolicy
+	cache       *CachePolicy
+	local       *LocalPolicy
+	limiter     *RateLimiter
+	budget      *Budget
+	concurrency int
+	partial     bool
+
+	localResults *sqlite.DehashedResults // Set when the query was answered from the local database
+	store        *sharedSink             // Stores the results of every page as they are decoded
+	output       *orderedSink            // Writes the results of the pages to the output in page order
+	shared       Sink                    // Output written to by several queries, replacing the output file
+
+	// Run tracking, guarded by mu while pages are retrieved concurrently
+	mu         sync.Mutex
+	runOptions *sqlite.QueryOptions
+	run        *sqlite.QueryRun
+	nextPage   int          // First page not yet retrieved
+	done       map[int]bool // Pages retrieved after nextPage
+	completed  int
+	retrieved  int
+	merge      bool // Merge into the output file instead of overwriting it
 }
 
 // NewDehasher creates a new Dehasher
-func NewDehasher(options *sqlite.QueryOptions) *Dehasher {
+func NewDehasher(options *sqlite.QueryOptions) (*Dehasher, error) {
 	dh := &Dehasher{
-		options:  *options,
-		nextPage: options.StartingPage + 1,
+		options:     *options,
+		concurrency: 1,
+		budget:      NewBudget(options.Budget),
+		runOptions:  options,
+		done:        make(map[int]bool),
+	}
+	if err := dh.planRequests(); err != nil {
+		return nil, err
+	}
+	dh.request = NewDehashedSearchRequest(dh.plan.StartingPage, dh.plan.PageSize, dh.options.WildcardMatch, dh.options.RegexMatch, false)
+	dh.nextPage = dh.request.Page
+	if dh.options.Expression == "" {
+		dh.buildRequest()
+	} else if err := setExpression(dh.request, dh.options.Expression); err != nil {
+		return nil, err
+	}
+	return dh, nil
+}
+
+// ResumeDehasher creates a Dehasher that continues a previous run from its last successful page
+func ResumeDehasher(run *sqlite.QueryRun) (*Dehasher, error) {
+	if !run.Resumable() {
+		return nil, &DehashError{Message: fmt.Sprintf("run %d is already complete", run.ID), Code: -1}
+	}
+
+	dh, err := NewDehasher(&run.QueryOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	dh.run = run
+	dh.merge = true
+	dh.completed = run.PagesCompleted
+	dh.retrieved = run.Retrieved
+	if run.PagesCompleted > 0 {
+		dh.request.Page = run.LastPage + 1
+		dh.nextPage = dh.request.Page
 	}
-	dh.setQueries()
-	dh.request = NewDehashedSearchRequest(dh.options.StartingPage, dh.options.MaxRecords, dh.options.WildcardMatch, dh.options.RegexMatch, false)
-	dh.buildRequest()
-	return dh
+	fmt.Printf("Resuming run %d from page %d (%d of %d Requests completed)\n", run.ID, dh.request.Page, dh.completed, dh.plan.Pages)
+	return dh, nil
+}
+
+// Retrieved returns the number of records retrieved by this Dehasher
+func (dh *Dehasher) Retrieved() int {
+	if dh.localResults != nil {
+		return len(dh.localResults.Results)
+	}
+	if dh.client == nil {
+		return 0
+	}
+	return dh.client.GetTotalResults()
+}
+
+// RunID returns the id of the run record tracking this query, or 0 if it has not started
+func (dh *Dehasher) RunID() uint {
+	if dh.run == nil {
+		return 0
+	}
+	return dh.run.ID
 }
 
 // SetClientCredentials sets the client credentials for the dehasher
 func (dh *Dehasher) SetClientCredentials(key string) {
 	dh.client = NewDehashedClientV2(key)
+	dh.client.SetRateLimiter(dh.limiter)
+	dh.client.SetCachePolicy(dh.cache)
+	if dh.retry != nil {
+		dh.client.SetRetryPolicy(dh.retry)
+	}
 }
 
-func (dh *Dehasher) getNextPage() int {
-	nextPage := dh.nextPage
-	dh.nextPage += 1
-	return nextPage
+// SetRetryPolicy sets the retry policy used for API requests
+func (dh *Dehasher) SetRetryPolicy(policy *RetryPolicy) {
+	dh.retry = policy
+	if dh.client != nil {
+		dh.client.SetRetryPolicy(policy)
+	}
 }
 
-// setQueries sets the number of queries to make based on the number of records and requests
-func (dh *Dehasher) setQueries() {
-	var numQueries int
+// SetCachePolicy sets how search responses are cached, nil disables the cache
+func (dh *Dehasher) SetCachePolicy(policy *CachePolicy) {
+	dh.cache = policy
+	if dh.client != nil {
+		dh.client.SetCachePolicy(policy)
+	}
+}
 
-	switch {
-	case dh.options.MaxRequests == 0:
-		zap.L().Error("max requests cannot be zero")
-		fmt.Println("[!] Max Requests cannot be zero")
-		os.Exit(1)
-	case dh.options.MaxRecords <= 10000 || dh.options.MaxRequests == 1:
-		numQueries = 1
-		if dh.options.MaxRecords > 10000 {
-			dh.options.MaxRecords = 10000
+// SetLocalPolicy sets whether the query is answered from the local database, nil always queries the API
+func (dh *Dehasher) SetLocalPolicy(policy *LocalPolicy) {
+	dh.local = policy
+}
+
+// SetRateLimiter sets the limiter shared by every request of the dehasher
+func (dh *Dehasher) SetRateLimiter(limiter *RateLimiter) {
+	dh.limiter = limiter
+	if dh.client != nil {
+		dh.client.SetRateLimiter(limiter)
+	}
+}
+
+// SetBudget sets the credit budget, which may be shared with other queries. By default
+// each query has its own budget from its options.
+func (dh *Dehasher) SetBudget(budget *Budget) {
+	dh.budget = budget
+}
+
+// SetConcurrency sets how many pages are requested at the same time
+func (dh *Dehasher) SetConcurrency(n int) {
+	dh.concurrency = max(1, n)
+}
+
+// planRequests computes the pagination plan for the configured records and requests
+func (dh *Dehasher) planRequests() error {
+	plan, err := PlanPagination(dh.options.MaxRecords, dh.options.MaxRequests, dh.options.StartingPage)
+	if err != nil {
+		zap.L().Error("plan_requests",
+			zap.String("message", "failed to plan requests"),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	dh.plan = plan
+	zap.L().Info("requests_planned",
+		zap.Int("pages", plan.Pages),
+		zap.Int("page_size", plan.PageSize),
+		zap.Int("starting_page", plan.StartingPage),
+	)
+	return nil
+}
+
+// Start starts the querying process. If ctx is cancelled mid-run, the pages already
+// retrieved are still persisted and exported, and the run is marked as partial.
+func (dh *Dehasher) Start(ctx context.Context) error {
+	if dh.local != nil && dh.run == nil {
+		if answered, err := dh.answerLocally(ctx); answered || err != nil {
+			return err
+		}
+	}
+	if dh.client == nil {
+		return &DehashError{Message: "client credentials have not been set", Code: -1}
+	}
+	fmt.Println(dh.plan)
+	sinks, err := dh.outputSinks()
+	if err != nil {
+		return err
+	}
+
+	if dh.run == nil {
+		run, err := sqlite.CreateRun(ctx, dh.runOptions, dh.request.Query)
+		if err != nil {
+			return wrapError("failed to record query run", err)
+		}
+		dh.run = run
+	}
+	fmt.Printf("[*] Run ID: %d\n", dh.run.ID)
+
+	dh.store = newSharedSink(newSQLiteSink(dh.run.ID))
+	dh.output = newOrderedSink(newPipeline(sinks...), dh.request.Page)
+
+	fmt.Println("[*] Querying Dehashed API...")
+	if dh.completed < dh.plan.Pages {
+		if err := dh.fetchPages(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := dh.closeSinks(ctx); err != nil {
+		dh.finishRun(ctx, sqlite.RunFailed, err)
+		return err
+	}
+	dh.finishRun(ctx, sqlite.RunComplete, nil)
+	return nil
+}
+
+// SetSharedOutput makes the query write its results to sink, which is shared with other
+// queries, instead of its own output file
+func (dh *Dehasher) SetSharedOutput(sink Sink) {
+	dh.shared = sink
+}
+
+// outputSinks returns the sinks writing the output of the query: the shared output, or
+// the output file, merged into when a run is resumed
+func (dh *Dehasher) outputSinks() ([]Sink, error) {
+	if dh.shared != nil {
+		return []Sink{dh.shared}, nil
+	}
+	mode := export.Create
+	if dh.merge {
+		mode = export.Merge
+	}
+	sink, err := newExportSink(dh.options.OutputFile, dh.options.OutputFormat, mode, dh.options.CredsOnly)
+	if err != nil {
+		return nil, wrapError("failed to create output file", err)
+	}
+	return []Sink{sink}, nil
+}
+
+// closeSinks stores and writes the last buffered results. The context may be cancelled
+// already, so the sinks decide themselves whether to persist what they hold.
+func (dh *Dehasher) closeSinks(ctx context.Context) error {
+	zap.L().Info("closing_sinks", zap.Int("retrieved", dh.Retrieved()))
+	err := dh.store.sink.Close(ctx)
+	if outputErr := dh.output.Close(ctx); err == nil {
+		err = outputErr
+	}
+	return err
+}
+
+// fetchPages requests the remaining pages of the plan. The first page is requested alone
+// since its total bounds the pages worth requesting; the rest are requested concurrently.
+// Every page is checked against the budget before it is sent.
+func (dh *Dehasher) fetchPages(ctx context.Context) error {
+	first, last := dh.request.Page, dh.plan.LastPage()
+	total, err := dh.fetchBudgetedPage(ctx, first)
+	if err == nil {
+		if needed := ceilDiv(total, dh.plan.PageSize); needed < last {
+			if first < last {
+				fmt.Printf("\n[-] Not Enough Entries, ending queries")
+			}
+			last = max(first, needed)
 		}
-		zap.L().Info("max requests set to 1", zap.Int("max_records", dh.options.MaxRecords))
-	case dh.options.MaxRequests < 0 && dh.options.MaxRecords > 20000:
-		numQueries = 3
-		dh.options.MaxRecords = 10000
-		zap.L().Info("max requests set to 3", zap.Int("max_records", dh.options.MaxRecords))
-	case dh.options.MaxRequests < 0 && dh.options.MaxRecords > 10000:
-		numQueries = 2
-		dh.options.MaxRecords = 10000
-		zap.L().Info("max requests set to 2", zap.Int("max_records", dh.options.MaxRecords))
-	case dh.options.MaxRecords < 0 && dh.options.MaxRecords < 10000:
-		numQueries = 1
-		zap.L().Info("max requests set to 1", zap.Int("max_records", dh.options.MaxRecords))
-	case dh.options.MaxRequests == 2 && dh.options.MaxRecords > 20000:
-		numQueries = 2
-		dh.options.MaxRecords = 10000
-		zap.L().Info("max requests set to 2", zap.Int("max_records", dh.options.MaxRecords))
-	case dh.options.MaxRequests == 2 && dh.options.MaxRecords <= 10000:
-		numQueries = 1
-		zap.L().Info("max requests set to 1", zap.Int("max_records", dh.options.MaxRecords))
+
+		// Pages already sent when the budget is reached are finished, only later ones are skipped
+		var overBudget atomic.Bool
+		err = runWorkers(ctx, last-first, dh.concurrency, func(ctx context.Context, job int) error {
+			if overBudget.Load() {
+				return nil
+			}
+			_, err := dh.fetchBudgetedPage(ctx, first+1+job)
+			if errors.Is(err, ErrBudgetExceeded) {
+				overBudget.Store(true)
+				return nil
+			}
+			return err
+		})
+		if err == nil && overBudget.Load() {
+			err = ErrBudgetExceeded
+		}
+	}
+
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		return dh.interrupt(ctx)
+	case errors.Is(err, ErrBudgetExceeded):
+		return dh.stopForBudget(ctx)
 	default:
-		numQueries = 3
-		dh.options.MaxRecords = 10000
-		zap.L().Info("max requests set to 3", zap.Int("max_records", dh.options.MaxRecords))
+		return dh.fail(ctx, err)
+	}
+}
+
+// fetchBudgetedPage requests a page once its credits are reserved from the budget,
+// returning ErrBudgetExceeded when they are not available. Until a response has reported
+// the balance, a single result probe learns it first. Cached pages cost nothing and skip
+// the budget.
+func (dh *Dehasher) fetchBudgetedPage(ctx context.Context, page int) (int, error) {
+	request := *dh.request
+	request.Page = page
+	if dh.budget == nil || dh.cache.has(ctx, CacheKey(dh.client.api.BaseURL(), request)) {
+		return dh.fetchPage(ctx, page)
+	}
+
+	err := dh.budget.learn(ctx, func(ctx context.Context) (int, error) {
+		fmt.Printf("\n\t[*] Checking the balance against the budget...")
+		return dh.client.ProbeBalance(ctx, request)
+	})
+	if err != nil {
+		return -1, wrapError("failed to check the balance", err)
+	}
+	if !dh.budget.reserve() {
+		return -1, ErrBudgetExceeded
 	}
+	defer dh.budget.release()
 
-	dh.options.MaxRequests = numQueries
-	fmt.Printf("Making %d Requests for %d Records (%d Total)\n", dh.options.MaxRequests, dh.options.MaxRecords, dh.options.MaxRequests*dh.options.MaxRecords)
+	total, err := dh.fetchPage(ctx, page)
+	if err == nil && !dh.client.FromCache(page) {
+		dh.budget.update(dh.client.GetBalance())
+	}
+	return total, err
 }
 
-// Start starts the querying process
-func (dh *Dehasher) Start() {
-	fmt.Println("[*] Querying Dehashed API...")
-	for i := 0; i < dh.options.MaxRequests; i++ {
-		fmt.Printf("\n\t[*] Performing Request...")
-		count, err := dh.client.Search(*dh.request)
-		if err != nil {
-			fmt.Printf("[!] Error performing request: %v", err)
-			os.Exit(-1)
+// fetchPage requests a single page and records it on the run, returning the total results
+func (dh *Dehasher) fetchPage(ctx context.Context, page int) (int, error) {
+	request := *dh.request
+	request.Page = page
+
+	fmt.Printf("\n\t[*] Performing Request for page %d...", page)
+	output := dh.output.Part(page)
+	total, err := dh.client.Search(ctx, request, newPipeline(dh.store, output))
+	if err != nil {
+		return -1, wrapError(fmt.Sprintf("request for page %d failed", page), err)
+	}
+	// Results of a page are persisted before it counts as completed, so a resumed run has them
+	if err := dh.store.Flush(ctx); err != nil {
+		return -1, wrapError(fmt.Sprintf("failed to save page %d", page), err)
+	}
+	if err := output.Close(ctx); err != nil {
+		return -1, wrapError(fmt.Sprintf("failed to write page %d", page), err)
+	}
+	dh.recordPage(ctx, page, total)
+
+	if dh.client.FromCache(page) {
+		fmt.Printf("\n\t\t[+] Page %d: Retrieved %d Records from cache (0 credits)", page, dh.client.GetPageResults(page))
+		return total, nil
+	}
+	fmt.Printf("\n\t\t[+] Page %d: Retrieved %d Records", page, dh.client.GetPageResults(page))
+	if dh.options.PrintBalance {
+		fmt.Printf("\n\t\t[*] Balance Remaining: %d", dh.client.GetBalance())
+	}
+	return total, nil
+}
+
+// recordPage records a successfully retrieved page on the run. Only pages without gaps
+// before them count as completed, so a resumed run never skips a page.
+func (dh *Dehasher) recordPage(ctx context.Context, page, total int) {
+	dh.mu.Lock()
+	defer dh.mu.Unlock()
+
+	dh.done[page] = true
+	for dh.done[dh.nextPage] {
+		delete(dh.done, dh.nextPage)
+		dh.run.LastPage = dh.nextPage
+		dh.nextPage++
+		dh.completed++
+	}
+	dh.run.PagesCompleted = dh.completed
+	dh.run.TotalResults = total
+	dh.run.Retrieved = dh.retrieved + dh.client.GetTotalResults()
+	dh.run.Balance = dh.client.GetBalance()
+	// The page was already paid for, so its progress is saved even if other pages failed
+	if err := sqlite.UpdateRun(context.WithoutCancel(ctx), dh.run); err != nil {
+		fmt.Printf("\n\t\t[!] Error saving run progress: %v", err)
+	}
+}
+
+// finishRun records the final status of the run
+func (dh *Dehasher) finishRun(ctx context.Context, status sqlite.RunStatus, runErr error) {
+	dh.run.Status = status
+	dh.run.Error = ""
+	if runErr != nil {
+		dh.run.Error = runErr.Error()
+	}
+	// The run status is saved even when the query itself was cancelled
+	if err := sqlite.UpdateRun(context.WithoutCancel(ctx), dh.run); err != nil {
+		fmt.Printf("\n[!] Error saving run status: %v", err)
+	}
+}
+
+// Partial reports whether the last run was interrupted before all pages were retrieved
+func (dh *Dehasher) Partial() bool {
+	return dh.partial
+}
+
+// fail marks the run as failed after a request error
+func (dh *Dehasher) fail(ctx context.Context, err error) error {
+	return dh.stop(ctx, sqlite.RunFailed, err)
+}
+
+// interrupt marks the run as partial after ctx was cancelled
+func (dh *Dehasher) interrupt(ctx context.Context) error {
+	dh.partial = true
+	// Pages in flight may have delivered part of their results, which are saved as well
+	saved := 0
+	if dh.store != nil {
+		saved = dh.store.Accepted()
+	}
+	zap.L().Warn("query_interrupted",
+		zap.Int("page", dh.nextPage),
+		zap.Int("saved", saved),
+	)
+	fmt.Printf("\n[!] Interrupted, saving %d retrieved records", saved)
+	return dh.stop(ctx, sqlite.RunPartial, wrapError("query interrupted, partial results were saved", ctx.Err()))
+}
+
+// stopForBudget marks the run as partial when the next request would exceed the credit budget
+func (dh *Dehasher) stopForBudget(ctx context.Context) error {
+	dh.partial = true
+	balance := dh.budget.Balance()
+	zap.L().Warn("budget_reached",
+		zap.Int("balance", balance),
+		zap.Int("budget", dh.budget.Floor()),
+	)
+	err := &DehashError{
+		Message: fmt.Sprintf("stopping before the balance of %d drops below the budget of %d credits", balance, dh.budget.Floor()),
+		Code:    -1,
+		Err:     ErrBudgetExceeded,
+	}
+	return dh.stop(ctx, sqlite.RunPartial, err)
+}
+
+// stop saves the pages retrieved so far and records the run with the given status
+func (dh *Dehasher) stop(ctx context.Context, status sqlite.RunStatus, err error) error {
+	if dh.store != nil {
+		// Persist with a context that is not cancelled so the flush itself can complete
+		if flushErr := dh.closeSinks(context.WithoutCancel(ctx)); flushErr != nil {
+			zap.L().Error("flush_results",
+				zap.String("message", "failed to save retrieved results"),
+				zap.Error(flushErr),
+			)
+			status = sqlite.RunFailed
 		}
+	}
+	dh.finishRun(ctx, status, err)
+	fmt.Printf("\n[*] Resume this run with: dehasher query resume %d", dh.run.ID)
+	return err
+}
 
-		if count < dh.options.MaxRecords {
-			fmt.Printf("\n\t\t[+] Retrieved %d Records", count)
-			fmt.Printf("\n[-] Not Enough Entries, ending queries")
-			break
-		} else {
-			fmt.Printf("\n\t\t[+] Retrieved %d Records", dh.options.MaxRecords)
+// DryRun prints the requests the plan would send and their estimated cost without sending them
+func (dh *Dehasher) DryRun() error {
+	fmt.Println(dh.plan)
+	fmt.Println("[*] Dry run, no requests will be sent")
+	request := *dh.request
+	for i := dh.completed; i < dh.plan.Pages; i++ {
+		body, err := json.MarshalIndent(request, "", "  ")
+		if err != nil {
+			return wrapError("failed to marshal search request", err)
 		}
+		source := ""
+		if dh.cache.has(context.Background(), CacheKey(apiBaseURL, request)) {
+			source = ", cached, 0 credits"
+		}
+		fmt.Printf("\n[*] Request %d of %d (page %d%s):\n%s\n", i+1, dh.plan.Pages, request.Page, source, body)
+		request.Page++
+	}
 
-		dh.request.Page = dh.getNextPage()
+	remaining := dh.plan.Pages - dh.completed
+	fmt.Printf("\n[*] %d Requests for up to %d Records, estimated cost %d credits\n", remaining, remaining*dh.plan.PageSize, dh.estimatedCredits())
+	if dh.options.Budget > 0 {
+		fmt.Printf("[*] Requests will stop before the balance drops below %d credits, one single result request checks the balance first\n", dh.options.Budget)
 	}
+	return nil
+}
 
-	dh.parseResults()
+// estimatedCredits returns the credits the remaining requests cost, cached responses being free
+func (dh *Dehasher) estimatedCredits() int {
+	request := *dh.request
+	credits := 0
+	for i := dh.completed; i < dh.plan.Pages; i++ {
+		if !dh.cache.has(context.Background(), CacheKey(apiBaseURL, request)) {
+			credits += CreditsPerRequest
+		}
+		request.Page++
+	}
+	return credits
 }
 
 // buildRequest constructs the query map
@@ -150,57 +535,3 @@ func (dh *Dehasher) buildRequest() {
 		dh.request.AddCryptoAddressQuery(dh.options.CryptoAddressQuery)
 	}
 }
-
-// parseResults parses the results and writes them to a file
-func (dh *Dehasher) parseResults() {
-	var data []byte
-
-	zap.L().Info("extracting_credentials")
-	results := dh.client.GetResults()
-	creds := results.ExtractCredentials()
-	fmt.Printf("\n\t[*] Discovered %d Credentials", len(creds))
-	err := sqlite.StoreCreds(creds)
-	if err != nil {
-		zap.L().Error("store_creds",
-			zap.String("message", "failed to store creds"),
-			zap.Error(err),
-		)
-	}
-	zap.L().Info("creds_stored", zap.Int("count", len(creds)))
-
-	zap.L().Info("storing_results")
-	err = sqlite.StoreResults(results)
-	if err != nil {
-		zap.L().Error("store_results",
-			zap.String("message", "failed to store results"),
-			zap.Error(err),
-		)
-	}
-	zap.L().Info("results_stored", zap.Int("count", len(results.Results)))
-
-	if len(results.Results) > 0 {
-		fmt.Printf("\n\t[*] Writing entries to file: %s.%s", dh.options.OutputFile, dh.options.OutputFormat.String())
-		if !dh.options.CredsOnly {
-			err := export.WriteToFile(results, dh.options.OutputFile, dh.options.OutputFormat)
-			if err != nil {
-				fmt.Printf("\n[!] Error Writing to file: %v\n\tOutputting to terminal.", err)
-				data, err = json.MarshalIndent(results, "", "  ")
-				fmt.Println(string(data))
-				os.Exit(0)
-			} else {
-				fmt.Println("\n\t\t[*] Success\n")
-			}
-		} else {
-			creds := results.ExtractCredentials()
-			err := export.WriteCredsToFile(creds, dh.options.OutputFile, dh.options.OutputFormat)
-			if err != nil {
-				fmt.Printf("\n[!] Error Writing to file: %v\n\tOutputting to terminal.", err)
-				data, err = json.MarshalIndent(creds, "", "  ")
-				fmt.Println(string(data))
-				os.Exit(0)
-			} else {
-				fmt.Println("\n\t\t[*] Success\n")
-			}
-		}
-	}
-}
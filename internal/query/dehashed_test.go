@@ -0,0 +1,370 @@
+package query_test
+
+import (
+	"Dehash/internal/files"
+	"Dehash/internal/mock"
+	"Dehash/internal/query"
+	"Dehash/internal/sqlite"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// initDB points the sqlite store at a fresh database for the test
+func initDB(t *testing.T) {
+	t.Helper()
+	db, err := sqlite.InitDB(t.TempDir())
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+		sqlite.DB = nil
+	})
+}
+
+// mockAPI serves generated fixtures. intercept may answer a search before the mock
+// server does, returning false to let it through.
+func mockAPI(t *testing.T, entries, balance int, intercept func(w http.ResponseWriter, r *http.Request, req query.DehashedSearchRequest) bool) {
+	t.Helper()
+	fixtures, err := mock.LoadFixtures("")
+	if err != nil {
+		t.Fatalf("LoadFixtures: %v", err)
+	}
+	fixtures.Generate(entries)
+	api := mock.NewServer(mock.Config{APIKey: mock.DefaultAPIKey, Balance: balance}, fixtures)
+	serveAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if intercept != nil && r.URL.Path == "/v2/search" {
+			body, _ := io.ReadAll(r.Body)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			var req query.DehashedSearchRequest
+			if json.Unmarshal(body, &req) == nil && intercept(w, r, req) {
+				return
+			}
+		}
+		api.ServeHTTP(w, r)
+	}))
+}
+
+// pagedAPI reports a total of total results for every search but returns only
+// pageEntries of them per page, so many pages are requested cheaply. handle may answer a
+// page itself, returning false to let it through.
+func pagedAPI(t *testing.T, total, pageEntries, balance int, handle func(w http.ResponseWriter, r *http.Request, req query.DehashedSearchRequest) bool) {
+	t.Helper()
+	var mu sync.Mutex
+	serveAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req query.DehashedSearchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if handle != nil && handle(w, r, req) {
+			return
+		}
+
+		mu.Lock()
+		balance--
+		remaining := balance
+		mu.Unlock()
+		entries := make([]map[string]any, pageEntries)
+		for i := range entries {
+			entries[i] = map[string]any{"id": pageID(req.Page, i), "email": []string{"user@paged.example"}}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"balance": remaining, "total": total, "entries": entries})
+	}))
+}
+
+func pageID(page, i int) string {
+	return fmt.Sprintf("page%02d-%d", page, i)
+}
+
+// serveAPI serves handler in place of the Dehashed API for the test
+func serveAPI(t *testing.T, handler http.Handler) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	query.SetBaseURL(server.URL)
+	t.Cleanup(func() { query.SetBaseURL(query.DefaultBaseURL) })
+}
+
+// readOutputIDs returns the ids of the results in a JSON output file, in file order
+func readOutputIDs(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	var entries []map[string]any
+	if err := json.Unmarshal(data, &entries); err != nil {
+		t.Fatalf("parsing output: %v", err)
+	}
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i], _ = entry["id"].(string)
+	}
+	return ids
+}
+
+func generatedIDs(from, to int) []string {
+	var ids []string
+	for i := from; i <= to; i++ {
+		ids = append(ids, fmt.Sprintf("generated-%06d", i))
+	}
+	return ids
+}
+
+func assertIDs(t *testing.T, got, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("output has %d results, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("output result %d is %s, want %s", i, got[i], want[i])
+		}
+	}
+}
+
+// pagedOptions plans 30000 records as 3 pages of 10000
+func pagedOptions(t *testing.T) *sqlite.QueryOptions {
+	return &sqlite.QueryOptions{
+		MaxRecords:   30000,
+		MaxRequests:  -1,
+		StartingPage: 1,
+		OutputFormat: files.JSON,
+		OutputFile:   filepath.Join(t.TempDir(), "out"),
+		EmailQuery:   "@paged.example",
+	}
+}
+
+func TestDehasherConcurrentPagesKeepOrder(t *testing.T) {
+	initDB(t)
+	// Page 2 answers last, so page 3 finishes before it
+	pagedAPI(t, 30000, 50, 100, func(w http.ResponseWriter, r *http.Request, req query.DehashedSearchRequest) bool {
+		if req.Page == 2 {
+			time.Sleep(200 * time.Millisecond)
+		}
+		return false
+	})
+
+	options := pagedOptions(t)
+	dh, err := query.NewDehasher(options)
+	if err != nil {
+		t.Fatalf("NewDehasher: %v", err)
+	}
+	dh.SetConcurrency(3)
+	dh.SetRateLimiter(query.NewRateLimiter(100, 3))
+	dh.SetClientCredentials(mock.DefaultAPIKey)
+	if err := dh.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	if got := dh.Retrieved(); got != 150 {
+		t.Errorf("retrieved %d records, want 150", got)
+	}
+	var want []string
+	for page := 1; page <= 3; page++ {
+		for i := 0; i < 50; i++ {
+			want = append(want, pageID(page, i))
+		}
+	}
+	assertIDs(t, readOutputIDs(t, options.OutputFile+".json"), want)
+}
+
+func TestDehasherFirstErrorCancelsPages(t *testing.T) {
+	initDB(t)
+	pagedAPI(t, 30000, 50, 100, func(w http.ResponseWriter, r *http.Request, req query.DehashedSearchRequest) bool {
+		switch req.Page {
+		case 2:
+			time.Sleep(50 * time.Millisecond)
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": "key revoked"}`)
+			return true
+		case 3:
+			// Hangs until the client gives up on the request
+			select {
+			case <-r.Context().Done():
+			case <-time.After(10 * time.Second):
+			}
+			return true
+		}
+		return false
+	})
+
+	dh, err := query.NewDehasher(pagedOptions(t))
+	if err != nil {
+		t.Fatalf("NewDehasher: %v", err)
+	}
+	dh.SetConcurrency(2)
+	dh.SetRetryPolicy(query.NewRetryPolicy(0, time.Millisecond, time.Millisecond))
+	dh.SetClientCredentials(mock.DefaultAPIKey)
+
+	start := time.Now()
+	err = dh.Start(context.Background())
+	if !errors.Is(err, query.ErrUnauthorized) {
+		t.Fatalf("Start error = %v, want ErrUnauthorized", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("failed after %s, want the hanging page cancelled", elapsed)
+	}
+}
+
+func TestDehasherBudgetWithConcurrentPages(t *testing.T) {
+	initDB(t)
+	// The probe leaves 10 credits, so a budget of 8 allows 2 of the 3 pages
+	var probes, pages atomic.Int32
+	pagedAPI(t, 30000, 50, 11, func(w http.ResponseWriter, r *http.Request, req query.DehashedSearchRequest) bool {
+		if req.Size == 1 {
+			probes.Add(1)
+		} else {
+			pages.Add(1)
+		}
+		return false
+	})
+
+	options := pagedOptions(t)
+	options.Budget = 8
+	dh, err := query.NewDehasher(options)
+	if err != nil {
+		t.Fatalf("NewDehasher: %v", err)
+	}
+	dh.SetConcurrency(2)
+	dh.SetClientCredentials(mock.DefaultAPIKey)
+
+	err = dh.Start(context.Background())
+	if !errors.Is(err, query.ErrBudgetExceeded) {
+		t.Fatalf("Start error = %v, want ErrBudgetExceeded", err)
+	}
+	if got := probes.Load(); got != 1 {
+		t.Errorf("sent %d balance probes, want 1 before the first page", got)
+	}
+	if got := pages.Load(); got != 2 {
+		t.Errorf("requested %d pages, want 2 within the budget", got)
+	}
+	if got := dh.Retrieved(); got != 100 {
+		t.Errorf("retrieved %d records, want the 2 pages within the budget", got)
+	}
+}
+
+func TestDehasherBudgetGuardsFirstPage(t *testing.T) {
+	initDB(t)
+	// The probe leaves 5 credits, already at the budget
+	var pages atomic.Int32
+	pagedAPI(t, 30000, 50, 6, func(w http.ResponseWriter, r *http.Request, req query.DehashedSearchRequest) bool {
+		if req.Size != 1 {
+			pages.Add(1)
+		}
+		return false
+	})
+
+	options := pagedOptions(t)
+	options.Budget = 5
+	dh, err := query.NewDehasher(options)
+	if err != nil {
+		t.Fatalf("NewDehasher: %v", err)
+	}
+	dh.SetClientCredentials(mock.DefaultAPIKey)
+
+	if err := dh.Start(context.Background()); !errors.Is(err, query.ErrBudgetExceeded) {
+		t.Fatalf("Start error = %v, want ErrBudgetExceeded", err)
+	}
+	if got := pages.Load(); got != 0 {
+		t.Errorf("requested %d pages, want none below the budget", got)
+	}
+}
+
+func TestDehasherResumeSkipsFinishedPages(t *testing.T) {
+	initDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The first run is interrupted while page 3 is in flight
+	var (
+		mu        sync.Mutex
+		requested []int
+		resumed   bool
+	)
+	pagedAPI(t, 30000, 50, 100, func(w http.ResponseWriter, r *http.Request, req query.DehashedSearchRequest) bool {
+		mu.Lock()
+		requested = append(requested, req.Page)
+		interrupt := req.Page == 3 && !resumed
+		mu.Unlock()
+		if interrupt {
+			cancel()
+			<-r.Context().Done()
+			return true
+		}
+		return false
+	})
+
+	options := pagedOptions(t)
+	dh, err := query.NewDehasher(options)
+	if err != nil {
+		t.Fatalf("NewDehasher: %v", err)
+	}
+	dh.SetClientCredentials(mock.DefaultAPIKey)
+	if err := dh.Start(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Start error = %v, want context.Canceled", err)
+	}
+
+	run, err := sqlite.GetRun(context.Background(), dh.RunID())
+	if err != nil {
+		t.Fatalf("GetRun: %v", err)
+	}
+	if run.Status != sqlite.RunPartial || run.PagesCompleted != 2 || run.LastPage != 2 || run.Retrieved != 100 {
+		t.Fatalf("interrupted run = %s with %d pages up to page %d and %d records, want partial with 2 pages up to page 2 and 100 records",
+			run.Status, run.PagesCompleted, run.LastPage, run.Retrieved)
+	}
+
+	mu.Lock()
+	requested, resumed = nil, true
+	mu.Unlock()
+	dh, err = query.ResumeDehasher(run)
+	if err != nil {
+		t.Fatalf("ResumeDehasher: %v", err)
+	}
+	dh.SetClientCredentials(mock.DefaultAPIKey)
+	if err := dh.Start(context.Background()); err != nil {
+		t.Fatalf("resumed Start: %v", err)
+	}
+
+	if len(requested) != 1 || requested[0] != 3 {
+		t.Errorf("resumed run requested pages %v, want only page 3", requested)
+	}
+	run, err = sqlite.GetRun(context.Background(), run.ID)
+	if err != nil {
+		t.Fatalf("GetRun: %v", err)
+	}
+	if run.Status != sqlite.RunComplete || run.PagesCompleted != 3 || run.Retrieved != 150 {
+		t.Errorf("resumed run = %s with %d pages and %d records, want complete with 3 pages and 150 records", run.Status, run.PagesCompleted, run.Retrieved)
+	}
+
+	// Every result of both attempts is linked to the one run and merged into the output
+	runs, err := sqlite.ListRuns(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("ListRuns: %v", err)
+	}
+	if len(runs) != 1 || runs[0].Hits != 150 {
+		t.Fatalf("ListRuns = %+v, want 1 run with 150 linked results", runs)
+	}
+	var want []string
+	for page := 1; page <= 3; page++ {
+		for i := 0; i < 50; i++ {
+			want = append(want, pageID(page, i))
+		}
+	}
+	assertIDs(t, readOutputIDs(t, options.OutputFile+".json"), want)
+}
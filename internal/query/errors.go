@@ -1,33 +1,31 @@
 package query
 
-type DehashError struct {
-	Message string
-	Code    int
-}
+import (
+	"Dehash/pkg/dehashed"
+	"errors"
+)
 
-type DehashResponseError struct {
-	HttpResponse int `json:"HTTP Response Code"`
-}
+// Sentinel errors for branching on API failures with errors.Is
+var (
+	ErrUnauthorized        = dehashed.ErrUnauthorized
+	ErrInsufficientCredits = dehashed.ErrInsufficientCredits
+	ErrRateLimited         = dehashed.ErrRateLimited
+	ErrInvalidQuery        = dehashed.ErrInvalidQuery
+	ErrNotPermitted        = dehashed.ErrNotPermitted
+	ErrUnavailable         = dehashed.ErrUnavailable
+	ErrBudgetExceeded      = errors.New("credit budget exceeded")
+)
 
-func (de *DehashError) Error() string {
-	return de.Message
-}
+// DehashError is the error of a failed request, see dehashed.Error
+type DehashError = dehashed.Error
 
-func GetDehashedError(c int) DehashError {
-	switch c {
-	case 400:
-		return DehashError{Code: 400, Message: "There is an issue with authentication. Please check your API key and email. If you haven't, refresh your API Key "}
-	case 401:
-		return DehashError{Code: 401, Message: "You need a search subscription and API credits to use the API, please purchase a search subscription and add credits to your account."}
-	case 403:
-		return DehashError{Code: 403, Message: "Insufficient Credits"}
-	case 404:
-		return DehashError{Code: 404, Message: "Method not permitted"}
-	case 429:
-		return DehashError{Code: 420, Message: "Rate Limited"}
-	case 302:
-		return DehashError{Code: 302, Message: "Invalid/Missing Query"}
-	default:
-		return DehashError{Code: -1, Message: "An unknown error has occurred"}
+// wrapError wraps err with a message, keeping the status of any DehashError already in the chain
+func wrapError(message string, err error) *DehashError {
+	dhErr := &DehashError{Message: message, Code: -1, Err: err}
+	var cause *DehashError
+	if errors.As(err, &cause) {
+		dhErr.Code = cause.Code
+		dhErr.Retryable = cause.Retryable
 	}
+	return dhErr
 }
@@ -0,0 +1,504 @@
+package query
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// MatchMode is how the value of a single expression term is matched
+type MatchMode int
+
+const (
+	MatchExact    MatchMode = iota // "quoted" or plain values
+	MatchWildcard                  // Plain values containing * or ?
+	MatchRegex                     // /slash delimited/ values
+)
+
+func (m MatchMode) String() string {
+	switch m {
+	case MatchWildcard:
+		return "wildcard"
+	case MatchRegex:
+		return "regex"
+	default:
+		return "exact"
+	}
+}
+
+// Node is a node of a parsed query expression
+type Node interface {
+	node()
+}
+
+// TermNode matches a single field against a value
+type TermNode struct {
+	Param DehashedParameter
+	Value string
+	Mode  MatchMode
+	Pos   int
+}
+
+// NotNode negates its expression
+type NotNode struct {
+	Expr Node
+	Pos  int
+}
+
+// AndNode matches when every one of its expressions matches
+type AndNode struct {
+	Exprs []Node
+}
+
+// OrNode matches when any one of its expressions matches
+type OrNode struct {
+	Exprs []Node
+}
+
+func (*TermNode) node() {}
+func (*NotNode) node()  {}
+func (*AndNode) node()  {}
+func (*OrNode) node()   {}
+
+// CompiledQuery is a query expression in the syntax of the API together with the
+// match flags the request needs for it
+type CompiledQuery struct {
+	Query    string
+	Regex    bool
+	Wildcard bool
+}
+
+// expressionError reports a problem in a query expression at a byte offset
+func expressionError(pos int, format string, args ...any) *DehashError {
+	return &DehashError{
+		Message: fmt.Sprintf("invalid query expression at position %d: %s", pos+1, fmt.Sprintf(format, args...)),
+		Code:    -1,
+	}
+}
+
+// ParseExpression parses a boolean query expression such as
+//
+//	email:"@corp.com" AND (username:admin OR username:root) AND NOT domain:test.corp.com
+//
+// Terms are field:value pairs; values are exact when plain or "quoted", wildcards when
+// they contain * or ?, and regular expressions when written as /pattern/. Terms next to
+// each other without an operator are combined with AND.
+func ParseExpression(expr string) (Node, error) {
+	tokens, err := lexExpression(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, expressionError(0, "expression is empty")
+	}
+
+	p := &exprParser{tokens: tokens, end: len(expr)}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok != nil {
+		if tok.kind == tokenRParen {
+			return nil, expressionError(tok.pos, "unmatched ')'")
+		}
+		return nil, expressionError(tok.pos, "unexpected %s", tok)
+	}
+	return node, nil
+}
+
+// CompileExpression parses, validates and compiles a query expression. Password terms
+// are hashed like AddPasswordQuery does unless forcePlaintext is set.
+func CompileExpression(expr string, forcePlaintext bool) (*CompiledQuery, error) {
+	node, err := ParseExpression(expr)
+	if err != nil {
+		return nil, err
+	}
+	return Compile(node, forcePlaintext)
+}
+
+// Compile validates an expression and renders it in the syntax of the API. The API
+// applies regex and wildcard matching to the whole query rather than to single terms,
+// so expressions whose terms cannot share one set of flags are rejected.
+func Compile(node Node, forcePlaintext bool) (*CompiledQuery, error) {
+	var terms []*TermNode
+	collectTerms(node, &terms)
+
+	compiled := &CompiledQuery{}
+	var first *TermNode
+	for _, term := range terms {
+		if term.Param == Password && !forcePlaintext && term.Mode != MatchExact {
+			return nil, expressionError(term.Pos, "password values are hashed before searching and cannot use %s matching", term.Mode)
+		}
+		if term.Mode == MatchExact {
+			continue
+		}
+		if first != nil && first.Mode != term.Mode {
+			return nil, expressionError(term.Pos, "%s term cannot be combined with the %s term at position %d, the API applies one match mode to the whole query", term.Mode, first.Mode, first.Pos+1)
+		}
+		if first == nil {
+			first = term
+		}
+	}
+	if first != nil {
+		compiled.Regex = first.Mode == MatchRegex
+		compiled.Wildcard = first.Mode == MatchWildcard
+	}
+
+	for _, term := range terms {
+		switch {
+		case compiled.Regex && term.Mode == MatchExact:
+			return nil, expressionError(term.Pos, "exact term %s cannot be combined with regex terms, write it as a regex instead", term.Param)
+		case compiled.Wildcard && term.Mode == MatchExact && strings.ContainsAny(term.Value, "*?"):
+			return nil, expressionError(term.Pos, "exact value %q contains wildcard characters and cannot be combined with wildcard terms", term.Value)
+		case term.Mode != MatchExact && strings.IndexFunc(term.Value, unicode.IsSpace) >= 0:
+			return nil, expressionError(term.Pos, "%s values cannot contain spaces", term.Mode)
+		}
+	}
+
+	if !positive(node) {
+		return nil, expressionError(0, "expression must match at least one term and cannot consist only of negations")
+	}
+
+	compiled.Query = compileNode(node, forcePlaintext, false)
+	return compiled, nil
+}
+
+// collectTerms appends every term of an expression in order
+func collectTerms(node Node, terms *[]*TermNode) {
+	switch n := node.(type) {
+	case *TermNode:
+		*terms = append(*terms, n)
+	case *NotNode:
+		collectTerms(n.Expr, terms)
+	case *AndNode:
+		for _, expr := range n.Exprs {
+			collectTerms(expr, terms)
+		}
+	case *OrNode:
+		for _, expr := range n.Exprs {
+			collectTerms(expr, terms)
+		}
+	}
+}
+
+// positive reports whether an expression always requires some term to match, which
+// the API needs since it cannot search for everything except a value
+func positive(node Node) bool {
+	switch n := node.(type) {
+	case *TermNode:
+		return true
+	case *AndNode:
+		for _, expr := range n.Exprs {
+			if positive(expr) {
+				return true
+			}
+		}
+		return false
+	case *OrNode:
+		for _, expr := range n.Exprs {
+			if !positive(expr) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// compileNode renders a node, grouping it in parentheses when nested in another operator
+func compileNode(node Node, forcePlaintext, nested bool) string {
+	switch n := node.(type) {
+	case *TermNode:
+		return compileTerm(n, forcePlaintext)
+	case *NotNode:
+		return "NOT " + compileNode(n.Expr, forcePlaintext, true)
+	case *AndNode:
+		return joinNodes(n.Exprs, " AND ", forcePlaintext, nested)
+	case *OrNode:
+		return joinNodes(n.Exprs, " OR ", forcePlaintext, nested)
+	default:
+		return ""
+	}
+}
+
+func joinNodes(nodes []Node, op string, forcePlaintext, nested bool) string {
+	parts := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		parts = append(parts, compileNode(node, forcePlaintext, true))
+	}
+	query := strings.Join(parts, op)
+	if nested {
+		return "(" + query + ")"
+	}
+	return query
+}
+
+// compileTerm renders a single term, hashing plaintext passwords
+func compileTerm(term *TermNode, forcePlaintext bool) string {
+	param, value := term.Param, term.Value
+	if param == Password && !forcePlaintext {
+		hash := sha256.Sum256([]byte(value))
+		param, value = HashedPassword, hex.EncodeToString(hash[:])
+	}
+	// Patterns are sent as written, quoting would make the API match them literally
+	if term.Mode != MatchExact || !needsQuotes(value) {
+		return param.GetArgumentString(value)
+	}
+	return param.GetArgumentString(`"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`)
+}
+
+// needsQuotes reports whether a value would be split or misread by the API unquoted
+func needsQuotes(value string) bool {
+	if value == "" || strings.EqualFold(value, "AND") || strings.EqualFold(value, "OR") || strings.EqualFold(value, "NOT") {
+		return true
+	}
+	return strings.ContainsFunc(value, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(`"()&\`, r)
+	})
+}
+
+type tokenKind int
+
+const (
+	tokenTerm tokenKind = iota
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenLParen
+	tokenRParen
+)
+
+type exprToken struct {
+	kind tokenKind
+	pos  int
+	term *TermNode
+}
+
+func (t *exprToken) String() string {
+	switch t.kind {
+	case tokenAnd:
+		return "AND"
+	case tokenOr:
+		return "OR"
+	case tokenNot:
+		return "NOT"
+	case tokenLParen:
+		return "'('"
+	case tokenRParen:
+		return "')'"
+	default:
+		return fmt.Sprintf("term %s", t.term.Param)
+	}
+}
+
+// lexExpression splits an expression into operators, parentheses and terms
+func lexExpression(expr string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '(':
+			tokens = append(tokens, exprToken{kind: tokenLParen, pos: i})
+			i++
+			continue
+		case c == ')':
+			tokens = append(tokens, exprToken{kind: tokenRParen, pos: i})
+			i++
+			continue
+		case c == '&':
+			tokens = append(tokens, exprToken{kind: tokenAnd, pos: i})
+			i++
+			continue
+		case c == '|':
+			tokens = append(tokens, exprToken{kind: tokenOr, pos: i})
+			i++
+			continue
+		case c == '!':
+			tokens = append(tokens, exprToken{kind: tokenNot, pos: i})
+			i++
+			continue
+		}
+
+		// A word is an operator keyword or the field name of a term
+		start := i
+		for i < len(expr) && isFieldChar(expr[i]) {
+			i++
+		}
+		word := expr[start:i]
+		if i >= len(expr) || expr[i] != ':' {
+			switch strings.ToUpper(word) {
+			case "AND":
+				tokens = append(tokens, exprToken{kind: tokenAnd, pos: start})
+				continue
+			case "OR":
+				tokens = append(tokens, exprToken{kind: tokenOr, pos: start})
+				continue
+			case "NOT":
+				tokens = append(tokens, exprToken{kind: tokenNot, pos: start})
+				continue
+			}
+			return nil, expressionError(start, "expected field:value, operator or parenthesis")
+		}
+
+		param, err := ParseParameter(word)
+		if err != nil {
+			return nil, expressionError(start, "%v", err)
+		}
+		term := &TermNode{Param: param, Pos: start}
+		i++ // Skip the colon
+		if i, err = lexValue(expr, i, term); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, exprToken{kind: tokenTerm, pos: start, term: term})
+	}
+	return tokens, nil
+}
+
+// lexValue reads the value of a term starting at i and returns the offset after it
+func lexValue(expr string, i int, term *TermNode) (int, error) {
+	if i >= len(expr) {
+		return i, expressionError(term.Pos, "missing value for field %s", term.Param)
+	}
+
+	switch delim := expr[i]; delim {
+	case '"', '/':
+		term.Mode = MatchExact
+		if delim == '/' {
+			term.Mode = MatchRegex
+		}
+		var value strings.Builder
+		for j := i + 1; j < len(expr); j++ {
+			switch {
+			case expr[j] == '\\' && j+1 < len(expr) && (expr[j+1] == delim || expr[j+1] == '\\' && delim == '"'):
+				value.WriteByte(expr[j+1])
+				j++
+			case expr[j] == delim:
+				if value.Len() == 0 {
+					return j, expressionError(term.Pos, "empty value for field %s", term.Param)
+				}
+				term.Value = value.String()
+				return j + 1, nil
+			default:
+				value.WriteByte(expr[j])
+			}
+		}
+		return i, expressionError(i, "unterminated %c", delim)
+	default:
+		start := i
+		// & ends a plain value so queries joined like email:a&username:b parse as two terms
+		for i < len(expr) && !strings.ContainsRune(" \t\r\n()&", rune(expr[i])) {
+			i++
+		}
+		term.Value = expr[start:i]
+		if term.Value == "" {
+			return i, expressionError(term.Pos, "missing value for field %s", term.Param)
+		}
+		if strings.ContainsAny(term.Value, "*?") {
+			term.Mode = MatchWildcard
+		}
+		return i, nil
+	}
+}
+
+func isFieldChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// exprParser is a recursive descent parser over the tokens of an expression. OR binds
+// loosest, then AND, then NOT.
+type exprParser struct {
+	tokens []exprToken
+	next   int
+	end    int
+}
+
+func (p *exprParser) peek() *exprToken {
+	if p.next >= len(p.tokens) {
+		return nil
+	}
+	return &p.tokens[p.next]
+}
+
+func (p *exprParser) parseOr() (Node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	exprs := []Node{left}
+	for tok := p.peek(); tok != nil && tok.kind == tokenOr; tok = p.peek() {
+		p.next++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, right)
+	}
+	if len(exprs) == 1 {
+		return left, nil
+	}
+	return &OrNode{Exprs: exprs}, nil
+}
+
+func (p *exprParser) parseAnd() (Node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	exprs := []Node{left}
+	for tok := p.peek(); tok != nil && tok.kind != tokenOr && tok.kind != tokenRParen; tok = p.peek() {
+		if tok.kind == tokenAnd {
+			p.next++
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, right)
+	}
+	if len(exprs) == 1 {
+		return left, nil
+	}
+	return &AndNode{Exprs: exprs}, nil
+}
+
+func (p *exprParser) parseUnary() (Node, error) {
+	tok := p.peek()
+	if tok == nil {
+		return nil, expressionError(p.end, "expression ends where a term was expected")
+	}
+	p.next++
+
+	switch tok.kind {
+	case tokenNot:
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &NotNode{Expr: expr, Pos: tok.pos}, nil
+	case tokenLParen:
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.peek(); closing == nil || closing.kind != tokenRParen {
+			return nil, expressionError(tok.pos, "unmatched '('")
+		}
+		p.next++
+		return expr, nil
+	case tokenTerm:
+		return tok.term, nil
+	case tokenRParen:
+		if p.next == 1 || p.tokens[p.next-2].kind == tokenLParen {
+			return nil, expressionError(tok.pos, "empty group")
+		}
+		return nil, expressionError(tok.pos, "expected a term before ')'")
+	default:
+		return nil, expressionError(tok.pos, "expected a term before %s", tok)
+	}
+}
@@ -0,0 +1,162 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// describe renders a parsed expression as nested calls, such as and(email=a, not(domain=b))
+func describe(node Node) string {
+	join := func(op string, exprs []Node) string {
+		parts := make([]string, len(exprs))
+		for i, expr := range exprs {
+			parts[i] = describe(expr)
+		}
+		return op + "(" + strings.Join(parts, ", ") + ")"
+	}
+
+	switch n := node.(type) {
+	case *TermNode:
+		switch n.Mode {
+		case MatchRegex:
+			return fmt.Sprintf("%s~/%s/", n.Param, n.Value)
+		case MatchWildcard:
+			return fmt.Sprintf("%s~%s", n.Param, n.Value)
+		default:
+			return fmt.Sprintf("%s=%s", n.Param, n.Value)
+		}
+	case *NotNode:
+		return "not(" + describe(n.Expr) + ")"
+	case *AndNode:
+		return join("and", n.Exprs)
+	case *OrNode:
+		return join("or", n.Exprs)
+	default:
+		return fmt.Sprintf("%T", node)
+	}
+}
+
+func TestParseExpression(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "documented example",
+			expr: `email:"@corp.com" AND (username:admin OR username:root) AND NOT domain:test.corp.com`,
+			want: "and(email=@corp.com, or(username=admin, username=root), not(domain=test.corp.com))",
+		},
+		{name: "single term", expr: "username:admin", want: "username=admin"},
+		{name: "adjacent terms are combined with AND", expr: "username:admin email:@corp.com", want: "and(username=admin, email=@corp.com)"},
+		{name: "ampersand joins terms", expr: "email:a@corp.com&username:admin", want: "and(email=a@corp.com, username=admin)"},
+		{name: "symbol operators", expr: "username:admin | !username:root", want: "or(username=admin, not(username=root))"},
+		{name: "lowercase keywords", expr: "username:admin or username:root and not domain:x", want: "or(username=admin, and(username=root, not(domain=x)))"},
+		{name: "AND binds tighter than OR", expr: "username:a OR username:b AND username:c", want: "or(username=a, and(username=b, username=c))"},
+		{name: "parentheses group", expr: "(username:a OR username:b) AND username:c", want: "and(or(username=a, username=b), username=c)"},
+		{name: "double negation", expr: "NOT NOT username:admin", want: "not(not(username=admin))"},
+		{name: "quoted value keeps spaces", expr: `name:"John Smith"`, want: "name=John Smith"},
+		{name: "escaped quote", expr: `name:"say \"hi\""`, want: `name=say "hi"`},
+		{name: "wildcard value", expr: "email:*@corp.com", want: "email~*@corp.com"},
+		{name: "regex value", expr: `username:/adm(in|1n)/`, want: "username~/adm(in|1n)/"},
+		{name: "field alias", expr: "ip:10.0.0.1", want: "ip_address=10.0.0.1"},
+
+		{name: "empty expression", expr: "   ", wantErr: "expression is empty"},
+		{name: "unbalanced open paren", expr: "(username:admin OR username:root", wantErr: "position 1: unmatched '('"},
+		{name: "unbalanced close paren", expr: "username:admin)", wantErr: "position 15: unmatched ')'"},
+		{name: "empty group", expr: "()", wantErr: "position 2: empty group"},
+		{name: "dangling AND", expr: "username:admin AND", wantErr: "expression ends where a term was expected"},
+		{name: "dangling OR", expr: "username:admin OR", wantErr: "expression ends where a term was expected"},
+		{name: "dangling NOT", expr: "NOT", wantErr: "expression ends where a term was expected"},
+		{name: "leading operator", expr: "AND username:admin", wantErr: "position 1: expected a term before AND"},
+		{name: "operator before close paren", expr: "(username:admin OR)", wantErr: "position 19: expected a term before ')'"},
+		{name: "unknown field", expr: "nickname:admin", wantErr: `position 1: unknown field "nickname"`},
+		{name: "missing value", expr: "username:", wantErr: "missing value for field username"},
+		{name: "empty quoted value", expr: `username:""`, wantErr: "empty value for field username"},
+		{name: "unterminated quote", expr: `name:"John`, wantErr: `position 6: unterminated "`},
+		{name: "unterminated regex", expr: "username:/adm", wantErr: "position 10: unterminated /"},
+		{name: "bare word", expr: "admin", wantErr: "position 1: expected field:value, operator or parenthesis"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := ParseExpression(tt.expr)
+			if tt.wantErr != "" {
+				if err == nil {
+					t.Fatalf("ParseExpression(%q) = %s, want an error containing %q", tt.expr, describe(node), tt.wantErr)
+				}
+				if !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseExpression(%q) error = %q, want it to contain %q", tt.expr, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseExpression(%q): %v", tt.expr, err)
+			}
+			if got := describe(node); got != tt.want {
+				t.Errorf("ParseExpression(%q) = %s, want %s", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileExpression(t *testing.T) {
+	const passwordHash = "5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8"
+
+	tests := []struct {
+		name           string
+		expr           string
+		forcePlaintext bool
+		want           CompiledQuery
+		wantErr        string
+	}{
+		{
+			name: "documented example",
+			expr: `email:"@corp.com" AND (username:admin OR username:root) AND NOT domain:test.corp.com`,
+			want: CompiledQuery{Query: "email:@corp.com AND (username:admin OR username:root) AND NOT domain:test.corp.com"},
+		},
+		{name: "single term", expr: "username:admin", want: CompiledQuery{Query: "username:admin"}},
+		{name: "nested groups keep parentheses", expr: "username:a OR (username:b AND NOT (domain:c OR domain:d))", want: CompiledQuery{Query: "username:a OR (username:b AND NOT (domain:c OR domain:d))"}},
+		{name: "values with spaces are quoted", expr: `name:"John Smith"`, want: CompiledQuery{Query: `name:"John Smith"`}},
+		{name: "operator values are quoted", expr: `username:"or"`, want: CompiledQuery{Query: `username:"or"`}},
+		{name: "quotes are escaped", expr: `name:"say \"hi\""`, want: CompiledQuery{Query: `name:"say \"hi\""`}},
+		{name: "passwords are hashed", expr: "password:password", want: CompiledQuery{Query: "hashed_password:" + passwordHash}},
+		{name: "plaintext passwords", expr: "password:password", forcePlaintext: true, want: CompiledQuery{Query: "password:password"}},
+		{name: "wildcard terms set the wildcard flag", expr: "email:*@corp.com AND username:adm?n", want: CompiledQuery{Query: "email:*@corp.com AND username:adm?n", Wildcard: true}},
+		{name: "exact terms combine with wildcards", expr: "email:*@corp.com AND username:admin", want: CompiledQuery{Query: "email:*@corp.com AND username:admin", Wildcard: true}},
+		{name: "regex terms set the regex flag", expr: `username:/adm(in|1n)/ OR email:/^root@/`, want: CompiledQuery{Query: "username:adm(in|1n) OR email:^root@", Regex: true}},
+
+		{name: "parse errors are returned", expr: "(username:admin", wantErr: "unmatched '('"},
+		{name: "unknown field", expr: "username:admin AND nickname:root", wantErr: `unknown field "nickname"`},
+		{name: "mixed wildcard and regex", expr: "email:*@corp.com AND username:/adm/", wantErr: "regex term cannot be combined with the wildcard term at position 1"},
+		{name: "exact term with regex", expr: "username:/adm/ AND domain:corp.com", wantErr: "exact term domain cannot be combined with regex terms"},
+		{name: "exact wildcard characters with wildcards", expr: `email:*@corp.com AND name:"who?"`, wantErr: `exact value "who?" contains wildcard characters`},
+		{name: "hashed password pattern", expr: "password:pass*", wantErr: "password values are hashed before searching and cannot use wildcard matching"},
+		{name: "regex with spaces", expr: `name:/john smith/`, wantErr: "regex values cannot contain spaces"},
+		{name: "only negations", expr: "NOT username:admin", wantErr: "cannot consist only of negations"},
+		{name: "OR with a negation", expr: "username:admin OR NOT username:root", wantErr: "cannot consist only of negations"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compiled, err := CompileExpression(tt.expr, tt.forcePlaintext)
+			if tt.wantErr != "" {
+				if err == nil {
+					t.Fatalf("CompileExpression(%q) = %+v, want an error containing %q", tt.expr, *compiled, tt.wantErr)
+				}
+				if !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("CompileExpression(%q) error = %q, want it to contain %q", tt.expr, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CompileExpression(%q): %v", tt.expr, err)
+			}
+			if *compiled != tt.want {
+				t.Errorf("CompileExpression(%q) = %+v, want %+v", tt.expr, *compiled, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package query
+
+import (
+	"Dehash/internal/sqlite"
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"time"
+)
+
+// LocalPolicy controls whether queries are answered from the results stored by earlier runs
+type LocalPolicy struct {
+	Offline bool          // Never fall back to the API
+	MaxAge  time.Duration // Only use results a run has seen within this age, 0 for any age
+}
+
+// NewLocalPolicy creates a local policy; a non-positive maxAge accepts results of any age
+func NewLocalPolicy(offline bool, maxAge time.Duration) *LocalPolicy {
+	return &LocalPolicy{Offline: offline, MaxAge: max(0, maxAge)}
+}
+
+// localOptions translates the field queries into local database options, matching values
+// as regexes or wildcards when the query does. Expressions have no local equivalent.
+func (dh *Dehasher) localOptions() (*sqlite.DBOptions, bool) {
+	if dh.options.Expression != "" {
+		return nil, false
+	}
+
+	options := sqlite.NewDBOptions()
+	switch {
+	case dh.options.RegexMatch:
+		options.Match = sqlite.MatchRegex
+	case dh.options.WildcardMatch:
+		options.Match = sqlite.MatchGlob
+	}
+	options.Username = dh.options.UsernameQuery
+	options.Email = dh.options.EmailQuery
+	options.IPAddress = dh.options.IpQuery
+	options.Password = dh.options.PassQuery
+	options.HashedPassword = dh.options.HashQuery
+	options.Name = dh.options.NameQuery
+	options.Domain = dh.options.DomainQuery
+	options.Vin = dh.options.VinQuery
+	options.LicensePlate = dh.options.LicensePlateQuery
+	options.Address = dh.options.AddressQuery
+	options.Phone = dh.options.PhoneQuery
+	options.Social = dh.options.SocialQuery
+	options.CryptoCurrencyAddress = dh.options.CryptoAddressQuery
+	options.Limit = dh.plan.Records()
+	if dh.local.MaxAge > 0 {
+		options.Since = time.Now().Add(-dh.local.MaxAge)
+	}
+	return options, true
+}
+
+// answerLocally answers the query from the local database when it holds matching results,
+// reporting whether it did. Offline queries are always answered locally, even when empty.
+func (dh *Dehasher) answerLocally(ctx context.Context) (bool, error) {
+	options, ok := dh.localOptions()
+	if !ok {
+		if dh.local.Offline {
+			return false, &DehashError{Message: "offline queries support field flags only, not query expressions", Code: -1}
+		}
+		fmt.Println("[*] Query cannot be answered from the local database, querying the API")
+		return false, nil
+	}
+
+	count, err := sqlite.GetResultsCount(ctx, options)
+	if err != nil {
+		return false, wrapError("failed to query local database", err)
+	}
+	if count == 0 {
+		age := ""
+		if dh.local.MaxAge > 0 {
+			age = fmt.Sprintf(" newer than %s", dh.local.MaxAge.Round(time.Second))
+		}
+		if dh.local.Offline {
+			fmt.Printf("[-] No local results%s\n", age)
+			dh.localResults = &sqlite.DehashedResults{}
+			return true, nil
+		}
+		fmt.Printf("[*] No local results%s, querying the API\n", age)
+		return false, nil
+	}
+
+	results, err := sqlite.QueryResults(ctx, options)
+	if err != nil {
+		return false, wrapError("failed to query local database", err)
+	}
+	for i := range results {
+		results[i].Source = sqlite.SourceLocal
+	}
+	dh.localResults = &sqlite.DehashedResults{Results: results}
+	zap.L().Info("query_local",
+		zap.Int64("matches", count),
+		zap.Int("results", len(results)),
+	)
+	fmt.Printf("[*] Answered from local database: %d of %d Records (0 credits)\n", len(results), count)
+
+	sinks, err := dh.outputSinks()
+	if err != nil {
+		return true, err
+	}
+	sink := newPipeline(sinks...)
+	for _, result := range results {
+		if err := sink.Write(ctx, result); err != nil {
+			sink.Close(ctx)
+			return true, wrapError("failed to write results", err)
+		}
+	}
+	return true, sink.Close(ctx)
+}
@@ -0,0 +1,79 @@
+package query
+
+import (
+	"Dehash/pkg/dehashed"
+	"fmt"
+)
+
+const (
+	MaxPageSize        = dehashed.MaxPageSize // Maximum entries the API returns for a single request
+	MaxPaginationDepth = 30000                // Maximum entries reachable through pagination (page * size)
+	CreditsPerRequest  = 1                    // Credits charged by the API for each search request
+)
+
+// PaginationPlan describes the requests needed to retrieve a number of records
+type PaginationPlan struct {
+	StartingPage int
+	PageSize     int
+	Pages        int
+}
+
+// PlanPagination computes the page size and number of requests needed to retrieve up to
+// maxRecords records starting at startingPage. A negative maxRequests means no limit on
+// the number of requests; the plan never exceeds the API page size or pagination depth.
+func PlanPagination(maxRecords, maxRequests, startingPage int) (*PaginationPlan, error) {
+	switch {
+	case maxRecords <= 0:
+		return nil, &DehashError{Message: "max records must be greater than zero", Code: -1}
+	case maxRequests == 0:
+		return nil, &DehashError{Message: "max requests cannot be zero", Code: -1}
+	case startingPage < 1:
+		return nil, &DehashError{Message: "starting page must be at least 1", Code: -1}
+	}
+
+	records := min(maxRecords, MaxPaginationDepth)
+	pages := ceilDiv(records, MaxPageSize)
+	pageSize := ceilDiv(records, pages)
+	if maxRequests > 0 && pages > maxRequests {
+		// Not enough requests to cover the records, so fill every page
+		pages = maxRequests
+		pageSize = MaxPageSize
+	}
+
+	// Pages past the pagination depth cannot be requested
+	if reachable := MaxPaginationDepth/pageSize - (startingPage - 1); reachable < pages {
+		if reachable <= 0 {
+			return nil, &DehashError{
+				Message: fmt.Sprintf("starting page %d with %d records per page is beyond the API limit of %d records", startingPage, pageSize, MaxPaginationDepth),
+				Code:    -1,
+			}
+		}
+		pages = reachable
+	}
+
+	return &PaginationPlan{StartingPage: startingPage, PageSize: pageSize, Pages: pages}, nil
+}
+
+// Records returns the maximum number of records the plan can retrieve
+func (pp *PaginationPlan) Records() int {
+	return pp.PageSize * pp.Pages
+}
+
+// EstimatedCredits returns the credits the plan costs if every request is made
+func (pp *PaginationPlan) EstimatedCredits() int {
+	return pp.Pages * CreditsPerRequest
+}
+
+// LastPage returns the last page the plan requests
+func (pp *PaginationPlan) LastPage() int {
+	return pp.StartingPage + pp.Pages - 1
+}
+
+func (pp *PaginationPlan) String() string {
+	return fmt.Sprintf("Making %d Requests for %d Records (%d Total) starting at page %d, estimated cost %d credits",
+		pp.Pages, pp.PageSize, pp.Records(), pp.StartingPage, pp.EstimatedCredits())
+}
+
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
@@ -0,0 +1,59 @@
+package query
+
+import "testing"
+
+func TestPlanPagination(t *testing.T) {
+	tests := []struct {
+		name                               string
+		maxRecords, maxRequests, startPage int
+		wantErr                            bool
+		wantPages, wantPageSize            int
+	}{
+		{name: "zero records", maxRecords: 0, maxRequests: -1, startPage: 1, wantErr: true},
+		{name: "negative records", maxRecords: -10, maxRequests: -1, startPage: 1, wantErr: true},
+		{name: "zero requests", maxRecords: 100, maxRequests: 0, startPage: 1, wantErr: true},
+		{name: "starting page zero", maxRecords: 100, maxRequests: -1, startPage: 0, wantErr: true},
+		{name: "single small page", maxRecords: 100, maxRequests: -1, startPage: 1, wantPages: 1, wantPageSize: 100},
+		{name: "exactly one full page", maxRecords: 10000, maxRequests: -1, startPage: 1, wantPages: 1, wantPageSize: 10000},
+		{name: "uneven total unlimited requests", maxRecords: 25000, maxRequests: -1, startPage: 1, wantPages: 3, wantPageSize: 8334},
+		{name: "uneven total one request", maxRecords: 25000, maxRequests: 1, startPage: 1, wantPages: 1, wantPageSize: 10000},
+		{name: "uneven total two requests", maxRecords: 25000, maxRequests: 2, startPage: 1, wantPages: 2, wantPageSize: 10000},
+		{name: "uneven total three requests", maxRecords: 25000, maxRequests: 3, startPage: 1, wantPages: 3, wantPageSize: 8334},
+		{name: "more requests than needed", maxRecords: 25000, maxRequests: 10, startPage: 1, wantPages: 3, wantPageSize: 8334},
+		{name: "just over one page two requests", maxRecords: 10001, maxRequests: 2, startPage: 1, wantPages: 2, wantPageSize: 5001},
+		{name: "between 10k and 20k two requests", maxRecords: 15000, maxRequests: 2, startPage: 1, wantPages: 2, wantPageSize: 7500},
+		{name: "just under 20k two requests", maxRecords: 19999, maxRequests: 2, startPage: 1, wantPages: 2, wantPageSize: 10000},
+		{name: "between 10k and 20k one request", maxRecords: 15000, maxRequests: 1, startPage: 1, wantPages: 1, wantPageSize: 10000},
+		{name: "records capped at pagination depth", maxRecords: 50000, maxRequests: -1, startPage: 1, wantPages: 3, wantPageSize: 10000},
+		{name: "starting page limits pages", maxRecords: 25000, maxRequests: -1, startPage: 2, wantPages: 2, wantPageSize: 8334},
+		{name: "starting page at last reachable page", maxRecords: 10000, maxRequests: -1, startPage: 3, wantPages: 1, wantPageSize: 10000},
+		{name: "starting page beyond pagination depth", maxRecords: 10000, maxRequests: -1, startPage: 4, wantErr: true},
+		{name: "small pages reach further", maxRecords: 100, maxRequests: -1, startPage: 300, wantPages: 1, wantPageSize: 100},
+		{name: "small pages beyond pagination depth", maxRecords: 100, maxRequests: -1, startPage: 301, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := PlanPagination(tt.maxRecords, tt.maxRequests, tt.startPage)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("PlanPagination(%d, %d, %d) = %+v, want an error", tt.maxRecords, tt.maxRequests, tt.startPage, plan)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PlanPagination(%d, %d, %d): %v", tt.maxRecords, tt.maxRequests, tt.startPage, err)
+			}
+			if plan.Pages != tt.wantPages || plan.PageSize != tt.wantPageSize || plan.StartingPage != tt.startPage {
+				t.Errorf("PlanPagination(%d, %d, %d) = %d pages of %d from page %d, want %d pages of %d from page %d",
+					tt.maxRecords, tt.maxRequests, tt.startPage, plan.Pages, plan.PageSize, plan.StartingPage, tt.wantPages, tt.wantPageSize, tt.startPage)
+			}
+			if depth := plan.LastPage() * plan.PageSize; depth > MaxPaginationDepth {
+				t.Errorf("plan reaches record %d, beyond the pagination depth of %d", depth, MaxPaginationDepth)
+			}
+			if plan.PageSize > MaxPageSize {
+				t.Errorf("page size %d exceeds the API maximum of %d", plan.PageSize, MaxPageSize)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package query
+
+import (
+	"context"
+	"sync"
+)
+
+// runWorkers calls fn for every job in [0, jobs) on up to workers goroutines. The first
+// error stops dispatching new jobs and cancels the context passed to jobs in flight.
+// It returns that first error once every started job has finished.
+func runWorkers(ctx context.Context, jobs, workers int, fn func(ctx context.Context, job int) error) error {
+	if jobs <= 0 {
+		return nil
+	}
+	workers = max(1, min(workers, jobs))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	next := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range next {
+				if err := fn(ctx, job); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for job := 0; job < jobs; job++ {
+		select {
+		case next <- job:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	return firstErr
+}
@@ -0,0 +1,127 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunWorkersRunsEveryJob(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen = make(map[int]int)
+	)
+	err := runWorkers(context.Background(), 100, 8, func(ctx context.Context, job int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[job]++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("runWorkers: %v", err)
+	}
+	for job := 0; job < 100; job++ {
+		if seen[job] != 1 {
+			t.Fatalf("job %d ran %d times, want once", job, seen[job])
+		}
+	}
+}
+
+func TestRunWorkersBoundsConcurrency(t *testing.T) {
+	var running, peak atomic.Int32
+	err := runWorkers(context.Background(), 50, 4, func(ctx context.Context, job int) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("runWorkers: %v", err)
+	}
+	if got := peak.Load(); got > 4 {
+		t.Errorf("%d jobs ran at once, want at most 4", got)
+	}
+}
+
+func TestRunWorkersFirstErrorCancels(t *testing.T) {
+	errFirst := errors.New("first failure")
+	var started, cancelled atomic.Int32
+
+	start := time.Now()
+	err := runWorkers(context.Background(), 100, 4, func(ctx context.Context, job int) error {
+		started.Add(1)
+		if job == 0 {
+			// Fail once the other workers are busy
+			time.Sleep(50 * time.Millisecond)
+			return errFirst
+		}
+		select {
+		case <-ctx.Done():
+			cancelled.Add(1)
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+			return nil
+		}
+	})
+	if !errors.Is(err, errFirst) {
+		t.Fatalf("runWorkers error = %v, want the first error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("returned after %s, want the jobs in flight cancelled", elapsed)
+	}
+	if got := started.Load(); got > 4+1 {
+		t.Errorf("%d jobs started, want dispatching to stop after the first error", got)
+	}
+	if cancelled.Load() == 0 {
+		t.Error("no job in flight saw its context cancelled")
+	}
+}
+
+func TestRateLimiterSharedByWorkers(t *testing.T) {
+	const (
+		rate     = 50
+		requests = 11
+	)
+	limiter := NewRateLimiter(rate, 1)
+
+	var (
+		mu    sync.Mutex
+		times []time.Time
+	)
+	err := runWorkers(context.Background(), requests, 8, func(ctx context.Context, job int) error {
+		if err := limiter.Wait(ctx); err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		times = append(times, time.Now())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("runWorkers: %v", err)
+	}
+
+	// The first request uses the burst, every other one waits a full interval
+	first, last := times[0], times[0]
+	for _, at := range times {
+		if at.Before(first) {
+			first = at
+		}
+		if at.After(last) {
+			last = at
+		}
+	}
+	want := (requests - 1) * time.Second / rate
+	if span := last.Sub(first); span < want*9/10 {
+		t.Errorf("%d requests took %s, want at least %s at %d per second", requests, span, want, rate)
+	}
+}
@@ -0,0 +1,91 @@
+package query
+
+import (
+	"Dehash/internal/cassette"
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	DefaultMaxRetries     = 3
+	DefaultRetryBaseDelay = 1 * time.Second
+	DefaultRetryMaxDelay  = 30 * time.Second
+)
+
+// RetryPolicy controls how failed API requests are retried
+type RetryPolicy struct {
+	MaxRetries int           // Retries allowed after the first attempt
+	BaseDelay  time.Duration // Delay before the first retry, doubled on each subsequent retry
+	MaxDelay   time.Duration // Upper bound for a single computed backoff delay
+}
+
+// NewRetryPolicy creates a new RetryPolicy, falling back to defaults for non-positive delays
+func NewRetryPolicy(maxRetries int, baseDelay, maxDelay time.Duration) *RetryPolicy {
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+	if baseDelay <= 0 {
+		baseDelay = DefaultRetryBaseDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultRetryMaxDelay
+	}
+	if maxDelay < baseDelay {
+		maxDelay = baseDelay
+	}
+	return &RetryPolicy{MaxRetries: maxRetries, BaseDelay: baseDelay, MaxDelay: maxDelay}
+}
+
+// DefaultRetryPolicy returns the retry policy used when none is configured
+func DefaultRetryPolicy() *RetryPolicy {
+	return NewRetryPolicy(DefaultMaxRetries, DefaultRetryBaseDelay, DefaultRetryMaxDelay)
+}
+
+// backoff returns the delay before the given retry (starting at 1). A server supplied
+// Retry-After wins over the computed delay; shouldRetry already refused any beyond MaxDelay.
+func (rp *RetryPolicy) backoff(retry int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+
+	delay := rp.MaxDelay
+	if shift := retry - 1; shift < 32 {
+		if d := rp.BaseDelay << shift; d > 0 && d < rp.MaxDelay {
+			delay = d
+		}
+	}
+
+	// Equal jitter: keep half the delay and randomize the other half
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// shouldRetry reports whether a request that failed with err may be attempted again
+func (rp *RetryPolicy) shouldRetry(retry int, err error) bool {
+	if retry > rp.MaxRetries {
+		return false
+	}
+	// Requests missing from a replayed cassette will not appear by retrying
+	if errors.Is(err, cassette.ErrNoInteraction) {
+		return false
+	}
+	// Waiting longer than MaxDelay would stall the query, retrying sooner is pointless
+	if retryAfter(err) > rp.MaxDelay {
+		return false
+	}
+	var dhErr *DehashError
+	if errors.As(err, &dhErr) {
+		return dhErr.Retryable
+	}
+	return false
+}
+
+// retryAfter returns the delay the API asked for before a failed request is retried
+func retryAfter(err error) time.Duration {
+	var dhErr *DehashError
+	if errors.As(err, &dhErr) {
+		return dhErr.RetryAfter
+	}
+	return 0
+}
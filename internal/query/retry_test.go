@@ -0,0 +1,230 @@
+package query
+
+import (
+	"Dehash/internal/sqlite"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingSink counts the results written to it
+type countingSink struct {
+	mu      sync.Mutex
+	results []sqlite.Result
+}
+
+func (s *countingSink) Write(ctx context.Context, result sqlite.Result) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results = append(s.results, result)
+	return nil
+}
+
+func (s *countingSink) Flush(ctx context.Context) error { return nil }
+func (s *countingSink) Close(ctx context.Context) error { return nil }
+
+// scriptedServer answers searches with the statuses in order, succeeding once they are used up
+func scriptedServer(t *testing.T, retryAfter string, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+		w.Header().Set("Content-Type", "application/json")
+		if n <= len(statuses) {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(statuses[n-1])
+			fmt.Fprintf(w, `{"error": "scripted failure %d"}`, n)
+			return
+		}
+		fmt.Fprint(w, `{"balance": 10, "total": 1, "entries": [{"id": "retry-1", "email": ["a@corp.com"]}]}`)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func newTestClient(baseURL string, policy *RetryPolicy) *DehashedClientV2 {
+	client := NewDehashedClientV2("test-key")
+	client.SetBaseURL(baseURL)
+	client.SetRetryPolicy(policy)
+	return client
+}
+
+func testSearch() DehashedSearchRequest {
+	return *NewDehashedSearchRequest(1, 100, false, false, false).Add(Email, "@corp.com")
+}
+
+func TestBackoffGrowsAndIsCapped(t *testing.T) {
+	policy := NewRetryPolicy(10, 100*time.Millisecond, time.Second)
+	for retry, want := range map[int]time.Duration{
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		3:  400 * time.Millisecond,
+		4:  800 * time.Millisecond,
+		5:  time.Second,
+		40: time.Second,
+	} {
+		for range 20 {
+			got := policy.backoff(retry, 0)
+			if got < want/2 || got > want {
+				t.Fatalf("backoff(%d) = %s, want between %s and %s", retry, got, want/2, want)
+			}
+		}
+	}
+}
+
+func TestBackoffRetryAfter(t *testing.T) {
+	policy := NewRetryPolicy(3, 100*time.Millisecond, 5*time.Second)
+	if got := policy.backoff(1, 3*time.Second); got != 3*time.Second {
+		t.Errorf("backoff with Retry-After 3s = %s, want 3s", got)
+	}
+	if got := policy.backoff(3, 5*time.Second); got != 5*time.Second {
+		t.Errorf("backoff with Retry-After 5s = %s, want 5s", got)
+	}
+}
+
+func TestShouldRetryRetryAfter(t *testing.T) {
+	policy := NewRetryPolicy(3, 100*time.Millisecond, 5*time.Second)
+	tests := []struct {
+		retryAfter time.Duration
+		want       bool
+	}{
+		{0, true},
+		{time.Second, true},
+		{5 * time.Second, true},
+		{5*time.Second + time.Millisecond, false},
+		{time.Hour, false},
+	}
+	for _, tt := range tests {
+		err := &DehashError{Message: "rate limited", Code: http.StatusTooManyRequests, Retryable: true, RetryAfter: tt.retryAfter}
+		if got := policy.shouldRetry(1, err); got != tt.want {
+			t.Errorf("shouldRetry with Retry-After %s = %v, want %v", tt.retryAfter, got, tt.want)
+		}
+	}
+}
+
+func TestSearchRetryAfterSeconds(t *testing.T) {
+	server, requests := scriptedServer(t, "1", http.StatusTooManyRequests)
+	client := newTestClient(server.URL, NewRetryPolicy(3, time.Millisecond, 2*time.Second))
+
+	start := time.Now()
+	if _, err := client.Search(context.Background(), testSearch(), &countingSink{}); err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want at least the 1s Retry-After", elapsed)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("sent %d requests, want 2", got)
+	}
+}
+
+func TestSearchRetryAfterDate(t *testing.T) {
+	date := time.Now().Add(30 * time.Second).UTC().Format(http.TimeFormat)
+	server, _ := scriptedServer(t, date, http.StatusTooManyRequests)
+	client := newTestClient(server.URL, NewRetryPolicy(0, time.Millisecond, time.Minute))
+
+	_, err := client.Search(context.Background(), testSearch(), &countingSink{})
+	var dhErr *DehashError
+	if !errors.As(err, &dhErr) {
+		t.Fatalf("Search error = %v, want a DehashError", err)
+	}
+	if dhErr.RetryAfter < 28*time.Second || dhErr.RetryAfter > 31*time.Second {
+		t.Errorf("RetryAfter = %s, want about 30s", dhErr.RetryAfter)
+	}
+}
+
+func TestSearchRetryAfterBeyondMaxDelay(t *testing.T) {
+	server, requests := scriptedServer(t, "3600", http.StatusTooManyRequests)
+	client := newTestClient(server.URL, NewRetryPolicy(3, time.Millisecond, time.Second))
+
+	start := time.Now()
+	_, err := client.Search(context.Background(), testSearch(), &countingSink{})
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Search error = %v, want ErrRateLimited", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("failed after %s, want at once", elapsed)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("sent %d requests, want 1", got)
+	}
+}
+
+func TestSearchRetriesByStatus(t *testing.T) {
+	tests := []struct {
+		status   int
+		requests int32
+		wantErr  error
+	}{
+		{http.StatusTooManyRequests, 2, nil},
+		{http.StatusInternalServerError, 2, nil},
+		{http.StatusBadGateway, 2, nil},
+		{http.StatusServiceUnavailable, 2, nil},
+		{http.StatusGatewayTimeout, 2, nil},
+		{http.StatusBadRequest, 1, ErrUnauthorized},
+		{http.StatusUnauthorized, 1, ErrUnauthorized},
+		{http.StatusForbidden, 1, ErrInsufficientCredits},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			server, requests := scriptedServer(t, "", tt.status)
+			client := newTestClient(server.URL, NewRetryPolicy(3, time.Millisecond, 10*time.Millisecond))
+
+			sink := &countingSink{}
+			_, err := client.Search(context.Background(), testSearch(), sink)
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("Search: %v", err)
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Fatalf("Search error = %v, want %v", err, tt.wantErr)
+			}
+			if got := requests.Load(); got != tt.requests {
+				t.Errorf("sent %d requests, want %d", got, tt.requests)
+			}
+			if tt.wantErr == nil && len(sink.results) != 1 {
+				t.Errorf("sink received %d results, want 1", len(sink.results))
+			}
+		})
+	}
+}
+
+func TestSearchStopsAtMaxRetries(t *testing.T) {
+	server, requests := scriptedServer(t, "", http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	client := newTestClient(server.URL, NewRetryPolicy(2, time.Millisecond, 10*time.Millisecond))
+
+	_, err := client.Search(context.Background(), testSearch(), &countingSink{})
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Search error = %v, want ErrUnavailable", err)
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("sent %d requests, want the first attempt and 2 retries", got)
+	}
+}
+
+func TestSearchCancelledDuringWait(t *testing.T) {
+	server, requests := scriptedServer(t, "", http.StatusServiceUnavailable)
+	client := newTestClient(server.URL, NewRetryPolicy(3, 10*time.Second, 10*time.Second))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := client.Search(ctx, testSearch(), &countingSink{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Search error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("returned after %s, want soon after cancellation", elapsed)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("sent %d requests, want 1", got)
+	}
+}
@@ -0,0 +1,391 @@
+package query
+
+import (
+	"Dehash/internal/export"
+	"Dehash/internal/files"
+	"Dehash/internal/sqlite"
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+)
+
+// sinkBatchSize is how many results are stored in the database at once
+const sinkBatchSize = 500
+
+// Sink receives search results one at a time as they are decoded from a response, so
+// pages never have to be held in memory
+type Sink interface {
+	Write(ctx context.Context, result sqlite.Result) error
+	// Flush persists buffered results, it is called once a page is complete
+	Flush(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
+// pipeline passes every result to each of its sinks. Pages retrieved concurrently write
+// to it at the same time, so calls are serialized.
+type pipeline struct {
+	mu    sync.Mutex
+	sinks []Sink
+}
+
+func newPipeline(sinks ...Sink) *pipeline {
+	return &pipeline{sinks: sinks}
+}
+
+func (p *pipeline) Write(ctx context.Context, result sqlite.Result) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, sink := range p.sinks {
+		if err := sink.Write(ctx, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *pipeline) Flush(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, sink := range p.sinks {
+		if err := sink.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes every sink, returning the first error
+func (p *pipeline) Close(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var firstErr error
+	for _, sink := range p.sinks {
+		if err := sink.Close(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// discardSink drops every result, for requests only sent for what their response reports
+type discardSink struct{}
+
+func (discardSink) Write(ctx context.Context, result sqlite.Result) error { return nil }
+func (discardSink) Flush(ctx context.Context) error                       { return nil }
+func (discardSink) Close(ctx context.Context) error                       { return nil }
+
+// sharedSink is a sink written to by several queries, such as the combined output of a
+// batch. Closing it only flushes, the owner closes the underlying sink once every query is done.
+type sharedSink struct {
+	mu       sync.Mutex
+	sink     Sink
+	accepted int // Results the underlying sink accepted
+}
+
+func newSharedSink(sink Sink) *sharedSink {
+	return &sharedSink{sink: sink}
+}
+
+func (s *sharedSink) Write(ctx context.Context, result sqlite.Result) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.sink.Write(ctx, result); err != nil {
+		return err
+	}
+	s.accepted++
+	return nil
+}
+
+// Accepted returns the number of results the underlying sink accepted
+func (s *sharedSink) Accepted() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+func (s *sharedSink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sink.Flush(ctx)
+}
+
+func (s *sharedSink) Close(ctx context.Context) error {
+	return s.Flush(ctx)
+}
+
+// orderedSink writes the results of numbered parts, such as the pages of a query or the
+// targets of a batch, in the order of their numbers whatever order they are retrieved in.
+// Results of the lowest unfinished part pass straight through; results of later parts are
+// held in memory until every part before them is finished, or the sink is closed.
+type orderedSink struct {
+	mu       sync.Mutex
+	sink     Sink
+	next     int                     // Part whose results pass straight through
+	pending  map[int][]sqlite.Result // Results held for parts after next
+	finished map[int]bool            // Parts after next that are finished
+}
+
+func newOrderedSink(sink Sink, first int) *orderedSink {
+	return &orderedSink{sink: sink, next: first, pending: make(map[int][]sqlite.Result), finished: make(map[int]bool)}
+}
+
+// Part returns the sink of a single part. Closing it finishes the part, the owner closes
+// the ordered sink once every part is done.
+func (o *orderedSink) Part(n int) Sink {
+	return &orderedPart{ordered: o, n: n}
+}
+
+func (o *orderedSink) write(ctx context.Context, n int, result sqlite.Result) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if n != o.next {
+		o.pending[n] = append(o.pending[n], result)
+		return nil
+	}
+	return o.sink.Write(ctx, result)
+}
+
+// finish marks a part as finished, writing the held results of the parts it was blocking
+func (o *orderedSink) finish(ctx context.Context, n int) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	switch {
+	case n < o.next:
+		return nil // Already finished
+	case n > o.next:
+		o.finished[n] = true
+		return nil
+	}
+
+	for {
+		o.next++
+		if err := o.release(ctx, o.next); err != nil {
+			return err
+		}
+		if !o.finished[o.next] {
+			break
+		}
+		delete(o.finished, o.next)
+	}
+	return o.sink.Flush(ctx)
+}
+
+// release writes the results held for a part
+func (o *orderedSink) release(ctx context.Context, n int) error {
+	results := o.pending[n]
+	delete(o.pending, n)
+	for _, result := range results {
+		if err := o.sink.Write(ctx, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *orderedSink) flush(ctx context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.sink.Flush(ctx)
+}
+
+// Close writes the results held for parts that never got their turn, such as those after
+// a failed page, in order and closes the underlying sink
+func (o *orderedSink) Close(ctx context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	parts := slices.Sorted(maps.Keys(o.pending))
+	var firstErr error
+	for _, n := range parts {
+		if err := o.release(ctx, n); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := o.sink.Close(ctx); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// orderedPart is the sink of a single part of an orderedSink
+type orderedPart struct {
+	ordered *orderedSink
+	n       int
+}
+
+func (p *orderedPart) Write(ctx context.Context, result sqlite.Result) error {
+	return p.ordered.write(ctx, p.n, result)
+}
+
+func (p *orderedPart) Flush(ctx context.Context) error {
+	return p.ordered.flush(ctx)
+}
+
+func (p *orderedPart) Close(ctx context.Context) error {
+	return p.ordered.finish(ctx, p.n)
+}
+
+// sqliteSink stores results, their credentials and their link to the run in batches.
+// Storing is best effort as before: failures are reported, but never fail the query.
+type sqliteSink struct {
+	runID   uint
+	pending []sqlite.Result
+	results int
+	creds   int
+}
+
+func newSQLiteSink(runID uint) *sqliteSink {
+	return &sqliteSink{runID: runID, pending: make([]sqlite.Result, 0, sinkBatchSize)}
+}
+
+func (s *sqliteSink) Write(ctx context.Context, result sqlite.Result) error {
+	s.pending = append(s.pending, result)
+	if len(s.pending) >= sinkBatchSize {
+		return s.Flush(ctx)
+	}
+	return nil
+}
+
+func (s *sqliteSink) Flush(ctx context.Context) error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	// A batch that was paid for is stored even when the query is being cancelled
+	ctx = context.WithoutCancel(ctx)
+
+	var creds []sqlite.Creds
+	for _, r := range s.pending {
+		creds = append(creds, r.Credentials()...)
+	}
+	if err := sqlite.StoreCreds(ctx, creds); err != nil {
+		zap.L().Error("store_creds",
+			zap.String("message", "failed to store creds"),
+			zap.Error(err),
+		)
+		fmt.Printf("\n\t[!] Error storing credentials: %v", err)
+	}
+	s.creds += len(creds)
+
+	results := sqlite.DehashedResults{Results: s.pending}
+	if err := sqlite.StoreResults(ctx, results); err != nil {
+		zap.L().Error("store_results",
+			zap.String("message", "failed to store results"),
+			zap.Error(err),
+		)
+		fmt.Printf("\n\t[!] Error storing results: %v", err)
+	}
+	if err := sqlite.LinkRunResults(ctx, s.runID, results); err != nil {
+		fmt.Printf("\n\t[!] Error linking results to run: %v", err)
+	}
+	s.results += len(s.pending)
+	s.pending = s.pending[:0]
+	return nil
+}
+
+func (s *sqliteSink) Close(ctx context.Context) error {
+	err := s.Flush(ctx)
+	fmt.Printf("\n\t[*] Discovered %d Credentials", s.creds)
+	zap.L().Info("creds_stored", zap.Int("count", s.creds))
+	zap.L().Info("results_stored", zap.Int("count", s.results))
+	return err
+}
+
+// exportSink writes results, or only their credentials, to the output file. When the
+// file cannot be created the entries are written to the terminal instead, so they are not lost.
+type exportSink struct {
+	credsOnly bool
+	results   *export.Writer[sqlite.Result]
+	creds     *export.Writer[sqlite.Creds]
+	terminal  bool
+}
+
+func newExportSink(outputFile string, fileType files.FileType, mode export.Mode, credsOnly bool) (*exportSink, error) {
+	sink := &exportSink{credsOnly: credsOnly}
+	var err error
+	if credsOnly {
+		sink.creds, err = export.NewCredsWriter(outputFile, fileType, mode)
+	} else {
+		sink.results, err = export.NewResultWriter(outputFile, fileType, mode)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (s *exportSink) Write(ctx context.Context, result sqlite.Result) error {
+	err := s.write(result)
+	if err == nil || s.terminal || s.count() > 0 {
+		return err
+	}
+
+	// Fall back to the terminal when the file could not be created
+	fmt.Printf("\n[!] Error Writing to file: %v\n\tOutputting to terminal.\n", err)
+	s.terminal = true
+	if s.credsOnly {
+		s.creds = export.NewTerminalWriter[sqlite.Creds](os.Stdout)
+	} else {
+		s.results = export.NewTerminalWriter[sqlite.Result](os.Stdout)
+	}
+	return s.write(result)
+}
+
+func (s *exportSink) write(result sqlite.Result) error {
+	if !s.credsOnly {
+		return s.results.Write(result)
+	}
+	for _, c := range result.Credentials() {
+		if err := s.creds.Write(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *exportSink) count() int {
+	if s.credsOnly {
+		return s.creds.Count()
+	}
+	return s.results.Count()
+}
+
+func (s *exportSink) path() string {
+	if s.credsOnly {
+		return s.creds.Path()
+	}
+	return s.results.Path()
+}
+
+func (s *exportSink) Flush(ctx context.Context) error {
+	if s.credsOnly {
+		return s.creds.Flush()
+	}
+	return s.results.Flush()
+}
+
+func (s *exportSink) Close(ctx context.Context) error {
+	var err error
+	if s.credsOnly {
+		err = s.creds.Close()
+	} else {
+		err = s.results.Close()
+	}
+	if s.terminal {
+		fmt.Println()
+		return err
+	}
+	if s.count() == 0 {
+		return err
+	}
+
+	fmt.Printf("\n\t[*] Writing entries to file: %s", s.path())
+	if err != nil {
+		return wrapError("failed to write output file", err)
+	}
+	fmt.Print("\n\t\t[*] Success\n\n")
+	return nil
+}
@@ -0,0 +1,76 @@
+package query
+
+import (
+	"Dehash/internal/sqlite"
+	"Dehash/pkg/dehashed"
+	"context"
+	"errors"
+	"testing"
+)
+
+func testResult(id string) sqlite.Result {
+	return sqlite.NewResult(dehashed.Result{DehashedId: id}, sqlite.SourceAPI)
+}
+
+func TestOrderedSinkWritesPartsInOrder(t *testing.T) {
+	out := &countingSink{}
+	ordered := newOrderedSink(out, 1)
+	ctx := context.Background()
+	write := func(part int, ids ...string) {
+		for _, id := range ids {
+			if err := ordered.Part(part).Write(ctx, testResult(id)); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+		}
+	}
+
+	write(3, "3a", "3b")
+	write(1, "1a")
+	write(2, "2a")
+	ordered.Part(3).Close(ctx)
+	write(1, "1b")
+	ordered.Part(1).Close(ctx)
+	write(2, "2b")
+	write(4, "4a")
+	if err := ordered.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	var got []string
+	for _, r := range out.results {
+		got = append(got, r.DehashedId)
+	}
+	want := []string{"1a", "1b", "2a", "2b", "3a", "3b", "4a"}
+	if len(got) != len(want) {
+		t.Fatalf("wrote %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("wrote %v, want %v", got, want)
+		}
+	}
+}
+
+// rejectingSink fails every write of a result whose id is reject
+type rejectingSink struct {
+	countingSink
+	reject string
+}
+
+func (s *rejectingSink) Write(ctx context.Context, result sqlite.Result) error {
+	if result.DehashedId == s.reject {
+		return errors.New("rejected")
+	}
+	return s.countingSink.Write(ctx, result)
+}
+
+func TestSharedSinkCountsAcceptedResults(t *testing.T) {
+	shared := newSharedSink(&rejectingSink{reject: "bad"})
+	ctx := context.Background()
+	for _, id := range []string{"a", "bad", "b"} {
+		shared.Write(ctx, testResult(id))
+	}
+	if got := shared.Accepted(); got != 2 {
+		t.Errorf("Accepted() = %d, want 2", got)
+	}
+}
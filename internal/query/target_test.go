@@ -0,0 +1,163 @@
+package query
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// describeTargets renders targets as line:field=value lists for comparison
+func describeTargets(targets []Target) string {
+	parts := make([]string, 0, len(targets))
+	for _, target := range targets {
+		fields := make([]string, 0, len(target.Fields))
+		for _, f := range target.Fields {
+			fields = append(fields, string(f.Param)+"="+f.Value)
+		}
+		parts = append(parts, strconv.Itoa(target.Line)+":"+strings.Join(fields, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestParseParameter(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    DehashedParameter
+		wantErr bool
+	}{
+		{name: "email", want: Email},
+		{name: " Username ", want: Username},
+		{name: "IP", want: IpAddress},
+		{name: "ip_address", want: IpAddress},
+		{name: "hash", want: HashedPassword},
+		{name: "license", want: LicensePlate},
+		{name: "crypto", want: CryptoAddress},
+		{name: "url", wantErr: true},
+		{name: "nickname", wantErr: true},
+		{name: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseParameter(tt.name)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseParameter(%q) = %s, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseParameter(%q) = %s, %v; want %s", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestReadTargets(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		defaultField string
+		want         string
+		wantErr      string
+	}{
+		{name: "default field", input: "a@corp.com\nb@corp.com\n", defaultField: "email", want: "1:email=a@corp.com 2:email=b@corp.com"},
+		{name: "named fields", input: "username:admin\nip: 10.0.0.1\n", defaultField: "email", want: "1:username=admin 2:ip_address=10.0.0.1"},
+		{name: "blank lines and comments", input: "\n# targets\n  admin  \n\n", defaultField: "username", want: "3:username=admin"},
+		{name: "unknown prefix is part of the value", input: "https://corp.com/login\n", defaultField: "domain", want: "1:domain=https://corp.com/login"},
+		{name: "ipv6 address", input: "fe80::1\n", defaultField: "ip", want: "1:ip_address=fe80::1"},
+		{name: "url is not a field", input: "url:corp.com\n", defaultField: "domain", want: "1:domain=url:corp.com"},
+		{name: "crlf line endings", input: "a@corp.com\r\nb@corp.com\r\n", defaultField: "email", want: "1:email=a@corp.com 2:email=b@corp.com"},
+		{name: "no targets", input: "# nothing\n", defaultField: "email", want: ""},
+		{name: "empty value", input: "a@corp.com\nemail:\n", defaultField: "email", wantErr: "line 2: empty value for field email"},
+		{name: "unknown default field", input: "admin\n", defaultField: "nickname", wantErr: `unknown field "nickname"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets, err := ReadTargets(strings.NewReader(tt.input), tt.defaultField)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ReadTargets error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadTargets: %v", err)
+			}
+			if got := describeTargets(targets); got != tt.want {
+				t.Errorf("ReadTargets = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCSVTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		columns map[string]string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "header names as fields",
+			input: "email,username\na@corp.com,admin\n,root\n",
+			want:  "2:email=a@corp.com,username=admin 3:username=root",
+		},
+		{
+			name:  "aliases in the header",
+			input: "IP, hash\n10.0.0.1, 5f4dcc3b\n",
+			want:  "2:ip_address=10.0.0.1,hashed_password=5f4dcc3b",
+		},
+		{
+			name:    "mapping by header name",
+			input:   "Full Name,Mail,Notes\nJohn Smith,john@corp.com,vip\n",
+			columns: map[string]string{"full name": "name", "Mail": "email"},
+			want:    "2:name=John Smith,email=john@corp.com",
+		},
+		{
+			name:    "mapping by column index",
+			input:   "a,b\nx,admin\n",
+			columns: map[string]string{"1": "username"},
+			want:    "2:username=admin",
+		},
+		{
+			name:  "rows without values are skipped",
+			input: "email\n\n \na@corp.com\n",
+			want:  "4:email=a@corp.com",
+		},
+		{
+			name:  "short rows",
+			input: "email,username\na@corp.com\n",
+			want:  "2:email=a@corp.com",
+		},
+		{
+			name:  "quoted values",
+			input: "name\n\"Smith, John\"\n",
+			want:  "2:name=Smith, John",
+		},
+		{name: "unknown header", input: "email,notes\na@corp.com,x\n", wantErr: `csv column "notes": unknown field "notes", use a column mapping`},
+		{name: "url header", input: "url\ncorp.com\n", wantErr: `unknown field "url"`},
+		{name: "unknown mapped field", input: "a\nx\n", columns: map[string]string{"a": "nickname"}, wantErr: `unknown field "nickname"`},
+		{name: "missing mapped column", input: "a\nx\n", columns: map[string]string{"b": "email"}, wantErr: `csv column "b" not found`},
+		{name: "column index out of range", input: "a\nx\n", columns: map[string]string{"3": "email"}, wantErr: `csv column "3" not found`},
+		{name: "empty input", input: "", wantErr: "failed to read csv header"},
+		{name: "malformed quotes", input: "name\n\"Smith\n", wantErr: "failed to read csv"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets, err := ReadCSVTargets(strings.NewReader(tt.input), tt.columns)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ReadCSVTargets error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadCSVTargets: %v", err)
+			}
+			if got := describeTargets(targets); got != tt.want {
+				t.Errorf("ReadCSVTargets = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
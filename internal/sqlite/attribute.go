@@ -0,0 +1,275 @@
+package sqlite
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"regexp"
+	"strings"
+)
+
+// MatchMode is how a filter value is compared to the values of a field
+type MatchMode int
+
+const (
+	MatchContains MatchMode = iota // The value appears anywhere in an element
+	MatchExact                     // An element equals the value
+	MatchPrefix                    // An element starts with the value
+	MatchSuffix                    // An element ends with the value
+	MatchGlob                      // An element matches the glob pattern (* and ? wildcards, [...] sets)
+	MatchRegex                     // An element matches the regular expression as a whole
+)
+
+// matchModes are the names of the match modes
+var matchModes = map[string]MatchMode{
+	"contains": MatchContains,
+	"exact":    MatchExact,
+	"prefix":   MatchPrefix,
+	"suffix":   MatchSuffix,
+	"glob":     MatchGlob,
+	"regex":    MatchRegex,
+}
+
+// ParseMatchMode returns the match mode with the given name, e.g. exact or regex
+func ParseMatchMode(name string) (MatchMode, error) {
+	mode, ok := matchModes[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return 0, fmt.Errorf("unknown match mode %q (contains, exact, prefix, suffix, glob or regex)", name)
+	}
+	return mode, nil
+}
+
+// ResultAttribute is a single value of a multi-valued result field. Results keep their
+// fields as JSON for reading, the attributes index each element so filters can match it.
+type ResultAttribute struct {
+	ID              uint   `gorm:"primaryKey"`
+	ResultID        uint   `gorm:"not null;uniqueIndex:idx_attribute_value"`
+	Field           string `gorm:"not null;uniqueIndex:idx_attribute_value;index:idx_attribute_normalized;index:idx_attribute_reversed"`
+	Value           string `gorm:"not null;uniqueIndex:idx_attribute_value"`
+	NormalizedValue string `gorm:"not null;index:idx_attribute_normalized"`
+	ReversedValue   string `gorm:"not null;index:idx_attribute_reversed"` // NormalizedValue reversed, for suffix matches
+}
+
+// attributeFields are the multi-valued fields of a result by column name
+var attributeFields = map[string]func(r Result) []string{
+	"email":                  func(r Result) []string { return r.Email },
+	"ip_address":             func(r Result) []string { return r.IpAddress },
+	"username":               func(r Result) []string { return r.Username },
+	"password":               func(r Result) []string { return r.Password },
+	"hashed_password":        func(r Result) []string { return r.HashedPassword },
+	"name":                   func(r Result) []string { return r.Name },
+	"vin":                    func(r Result) []string { return r.Vin },
+	"license_plate":          func(r Result) []string { return r.LicensePlate },
+	"url":                    func(r Result) []string { return r.Url },
+	"social":                 func(r Result) []string { return r.Social },
+	"cryptocurrency_address": func(r Result) []string { return r.CryptoCurrencyAddress },
+	"address":                func(r Result) []string { return r.Address },
+	"phone":                  func(r Result) []string { return r.Phone },
+	"company":                func(r Result) []string { return r.Company },
+}
+
+// normalize is the form values are matched in, so matches ignore case and surrounding space
+func normalize(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+func reverse(value string) string {
+	runes := []rune(value)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// Attributes returns an attribute for every distinct value of the multi-valued fields of
+// a stored result
+func (r Result) Attributes() []ResultAttribute {
+	var attributes []ResultAttribute
+	for field, values := range attributeFields {
+		for _, value := range distinct(values(r)) {
+			normalized := normalize(value)
+			attributes = append(attributes, ResultAttribute{
+				ResultID:        r.ID,
+				Field:           field,
+				Value:           value,
+				NormalizedValue: normalized,
+				ReversedValue:   reverse(normalized),
+			})
+		}
+	}
+	return attributes
+}
+
+// storeAttributes indexes the values of the results with the given dehashed ids. Results
+// that are already indexed are left as they are.
+func storeAttributes(tx *gorm.DB, dehashedIds []string) error {
+	var stored []Result
+	if err := tx.Where("dehashed_id IN ?", dehashedIds).Find(&stored).Error; err != nil {
+		return err
+	}
+	return insertAttributes(tx, stored)
+}
+
+func insertAttributes(tx *gorm.DB, results []Result) error {
+	var attributes []ResultAttribute
+	for _, r := range results {
+		attributes = append(attributes, r.Attributes()...)
+	}
+	if len(attributes) == 0 {
+		return nil
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&attributes, 100).Error
+}
+
+// attributeMatch returns the ids of results with a value of the field matching value
+func attributeMatch(db *gorm.DB, field, value string, mode MatchMode) *gorm.DB {
+	query := db.Session(&gorm.Session{NewDB: true}).Model(&ResultAttribute{}).
+		Select("result_id").
+		Where("field = ?", field)
+
+	normalized := normalize(value)
+	switch mode {
+	case MatchExact:
+		return query.Where("normalized_value = ?", normalized)
+	case MatchPrefix:
+		// A range over the index, LIKE cannot use it as values are case sensitive
+		return query.Where("normalized_value >= ? AND normalized_value < ?", normalized, normalized+"\U0010FFFF")
+	case MatchSuffix:
+		reversed := reverse(normalized)
+		return query.Where("reversed_value >= ? AND reversed_value < ?", reversed, reversed+"\U0010FFFF")
+	case MatchGlob:
+		// The literal prefix narrows the values over the index, the pattern matches the rest
+		pattern, prefix := globPattern(strings.TrimSpace(value))
+		if prefix != "" {
+			query = query.Where("normalized_value >= ? AND normalized_value < ?", prefix, prefix+"\U0010FFFF")
+		}
+		return query.Where("value REGEXP ?", pattern)
+	case MatchRegex:
+		return query.Where("value REGEXP ?", regexPattern(value))
+	default:
+		return query.Where("normalized_value LIKE ? ESCAPE '\\'", "%"+escapeLike(normalized)+"%")
+	}
+}
+
+// regexPattern makes a regular expression match whole values ignoring case, like the other
+// match modes and like regex queries of the API
+func regexPattern(value string) string {
+	return "(?i)^(?:" + value + ")$"
+}
+
+// globPattern translates a GLOB pattern (* and ? wildcards, [...] sets, [^...] negated sets)
+// into a regular expression matching whole values ignoring case, and returns the normalized
+// literal prefix of the pattern. Lowercasing the pattern instead would change its sets, e.g.
+// the range [Z-a] would become the empty [z-a].
+func globPattern(glob string) (pattern, prefix string) {
+	var re strings.Builder
+	literal := true
+	runes := []rune(glob)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '*':
+			re.WriteString(".*")
+		case '?':
+			re.WriteString(".")
+		case '[':
+			end := setEnd(runes, i)
+			if end < 0 {
+				// An unterminated set is matched literally
+				re.WriteString(regexp.QuoteMeta(string(c)))
+				if literal {
+					prefix += string(c)
+				}
+				continue
+			}
+			re.WriteString(globSet(runes[i+1 : end]))
+			i = end
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+			if literal {
+				prefix += string(c)
+			}
+			continue
+		}
+		literal = false
+	}
+	return regexPattern(re.String()), normalize(prefix)
+}
+
+// setEnd returns the index of the ] closing the set opened at start, or -1. A ] right
+// after the [ or [^ is a member of the set.
+func setEnd(runes []rune, start int) int {
+	i := start + 1
+	if i < len(runes) && runes[i] == '^' {
+		i++
+	}
+	if i < len(runes) && runes[i] == ']' {
+		i++
+	}
+	for ; i < len(runes); i++ {
+		if runes[i] == ']' {
+			return i
+		}
+	}
+	return -1
+}
+
+// globSet translates the members of a GLOB set into a regular expression character class
+func globSet(members []rune) string {
+	var class strings.Builder
+	class.WriteByte('[')
+	if len(members) > 0 && members[0] == '^' {
+		class.WriteByte('^')
+		members = members[1:]
+	}
+	for i, c := range members {
+		switch {
+		case c == '-' && i > 0 && i < len(members)-1:
+			class.WriteRune(c) // A range
+		case c == '\\' || c == '[' || c == ']' || c == '^' || c == '-':
+			class.WriteRune('\\')
+			class.WriteRune(c)
+		default:
+			class.WriteRune(c)
+		}
+	}
+	class.WriteByte(']')
+	return class.String()
+}
+
+// escapeLike escapes the LIKE wildcards in a value so it matches literally
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// migrateAttributes creates the attribute table and indexes the values of the results
+// stored before it existed. The table is committed together with the backfill and results
+// are stored with their attributes from then on, so the backfill runs once, before the
+// table exists.
+func migrateAttributes(db *gorm.DB) error {
+	if db.Migrator().HasTable(&ResultAttribute{}) {
+		return db.AutoMigrate(&ResultAttribute{})
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&ResultAttribute{}); err != nil {
+			return err
+		}
+
+		indexed := 0
+		var results []Result
+		err := tx.Model(&Result{}).
+			FindInBatches(&results, 500, func(batch *gorm.DB, _ int) error {
+				indexed += len(results)
+				return insertAttributes(tx, results)
+			}).Error
+		if err != nil {
+			return fmt.Errorf("failed to index stored results: %w", err)
+		}
+		if indexed > 0 {
+			zap.L().Info("results_indexed", zap.Int("count", indexed))
+			fmt.Printf("[*] Indexed %d stored results in the local database\n", indexed)
+		}
+		return nil
+	})
+}
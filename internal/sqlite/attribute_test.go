@@ -0,0 +1,115 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+)
+
+// openTestDB opens the database in dir, it is closed by the returned function or when the test ends
+func openTestDB(t *testing.T, dir string) func() {
+	t.Helper()
+	db, err := InitDB(dir)
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	closed := false
+	closeDB := func() {
+		if closed {
+			return
+		}
+		closed = true
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+		DB = nil
+	}
+	t.Cleanup(closeDB)
+	return closeDB
+}
+
+func countAttributes(t *testing.T) int64 {
+	t.Helper()
+	var count int64
+	if err := DB.Model(&ResultAttribute{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestMigrateAttributesBackfillsOnce(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	// A database with results stored before the attribute table existed
+	closeDB := openTestDB(t, dir)
+	results := DehashedResults{Results: []Result{
+		{ResultFields: ResultFields{DehashedId: "a", Email: []string{"a@corp.com"}, Username: []string{"admin"}}},
+		{ResultFields: ResultFields{DehashedId: "b", Email: []string{"b@corp.com"}}},
+	}}
+	if err := StoreResults(ctx, results); err != nil {
+		t.Fatalf("StoreResults: %v", err)
+	}
+	if got := countAttributes(t); got != 3 {
+		t.Fatalf("StoreResults indexed %d attributes, want 3", got)
+	}
+	if err := DB.Migrator().DropTable(&ResultAttribute{}); err != nil {
+		t.Fatal(err)
+	}
+	closeDB()
+
+	closeDB = openTestDB(t, dir)
+	if got := countAttributes(t); got != 3 {
+		t.Fatalf("backfill indexed %d attributes, want 3", got)
+	}
+
+	// Once the table exists the stored results are not scanned again
+	if err := DB.Where("field = ?", "username").Delete(&ResultAttribute{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	closeDB()
+
+	openTestDB(t, dir)
+	if got := countAttributes(t); got != 2 {
+		t.Errorf("reopening indexed the results again, %d attributes, want 2", got)
+	}
+}
+
+func TestGlobPattern(t *testing.T) {
+	tests := []struct {
+		glob       string
+		wantPrefix string
+		matches    []string
+		misses     []string
+	}{
+		{glob: "*@corp.com", wantPrefix: "", matches: []string{"a@corp.com", "A@Corp.COM"}, misses: []string{"a@corp.co", "a@corpxcom"}},
+		{glob: "Admin?", wantPrefix: "admin", matches: []string{"admin1", "ADMINx"}, misses: []string{"admin", "admin12"}},
+		{glob: "[A-Z]*", wantPrefix: "", matches: []string{"Admin", "admin"}, misses: []string{"1admin", "_admin"}},
+		{glob: "user[0-9][0-9]", wantPrefix: "user", matches: []string{"user42", "USER07"}, misses: []string{"user4", "userab"}},
+		{glob: "[^0-9]*", wantPrefix: "", matches: []string{"admin"}, misses: []string{"1admin"}},
+		{glob: "[Z-a]", wantPrefix: "", matches: []string{"_", "z", "A"}, misses: []string{"b"}},
+		{glob: "[]x]", wantPrefix: "", matches: []string{"]", "X"}, misses: []string{"y"}},
+		{glob: "a[-.]b", wantPrefix: "a", matches: []string{"a-b", "a.b"}, misses: []string{"a_b"}},
+		{glob: "a.b", wantPrefix: "a.b", matches: []string{"A.B"}, misses: []string{"axb"}},
+		{glob: "a[b", wantPrefix: "a[b", matches: []string{"a[b"}, misses: []string{"ab"}},
+		{glob: "(x)+", wantPrefix: "(x)+", matches: []string{"(X)+"}, misses: []string{"xx"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.glob, func(t *testing.T) {
+			pattern, prefix := globPattern(tt.glob)
+			if prefix != tt.wantPrefix {
+				t.Errorf("prefix = %q, want %q", prefix, tt.wantPrefix)
+			}
+			for _, value := range tt.matches {
+				if ok, err := regexpMatch(pattern, value); err != nil || !ok {
+					t.Errorf("%q (%s) does not match %q, error %v", tt.glob, pattern, value, err)
+				}
+			}
+			for _, value := range tt.misses {
+				if ok, err := regexpMatch(pattern, value); err != nil || ok {
+					t.Errorf("%q (%s) matches %q, error %v", tt.glob, pattern, value, err)
+				}
+			}
+		})
+	}
+}
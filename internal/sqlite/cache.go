@@ -0,0 +1,216 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
+	"time"
+)
+
+// ErrCacheMiss is returned when no unexpired response is cached for a request
+var ErrCacheMiss = errors.New("response not cached")
+
+// cacheChunkSize is how much of a response body is stored per row, so bodies are never
+// held in memory as a whole when they are cached or read back
+const cacheChunkSize = 1 << 20
+
+// CachedResponse is a raw search response stored under the hash of its request. Its body
+// is stored apart, in chunks, see OpenCachedBody.
+type CachedResponse struct {
+	Key          string    `json:"key" gorm:"primaryKey"`
+	BaseURL      string    `json:"base_url"`
+	Query        string    `json:"query" gorm:"index"`
+	Page         int       `json:"page"`
+	Size         int       `json:"size"`
+	Regex        bool      `json:"regex"`
+	Wildcard     bool      `json:"wildcard"`
+	DeDupe       bool      `json:"de_dupe"`
+	TotalResults int       `json:"total_results"`
+	Entries      int       `json:"entries"`
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at" gorm:"index"`
+}
+
+// Expired reports whether the cached response is past its expiry
+func (cr *CachedResponse) Expired() bool {
+	return !cr.ExpiresAt.After(time.Now())
+}
+
+// GetCachedResponse returns the unexpired response cached under key, or ErrCacheMiss
+func GetCachedResponse(ctx context.Context, key string) (*CachedResponse, error) {
+	db, err := GetDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var cached CachedResponse
+	err = db.WithContext(ctx).Where("key = ? AND expires_at > ?", key, time.Now()).First(&cached).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCacheMiss
+	}
+	if err != nil {
+		zap.L().Error("get_cached_response",
+			zap.String("message", "failed to read cached response"),
+			zap.Error(err),
+		)
+		return nil, fmt.Errorf("failed to read cached response: %w", err)
+	}
+	return &cached, nil
+}
+
+// HasCachedResponse reports whether an unexpired response is cached under key
+func HasCachedResponse(ctx context.Context, key string) bool {
+	db, err := GetDB()
+	if err != nil {
+		return false
+	}
+
+	var count int64
+	db.WithContext(ctx).Model(&CachedResponse{}).Where("key = ? AND expires_at > ?", key, time.Now()).Count(&count)
+	return count > 0
+}
+
+// CachedResponseChunk is a part of the body of a cached response
+type CachedResponseChunk struct {
+	Key  string `gorm:"primaryKey"`
+	Seq  int    `gorm:"primaryKey;autoIncrement:false"`
+	Data []byte
+}
+
+// StoreCachedResponse stores a response and the body read from body, replacing any
+// response cached under the same key. The body is read and stored a chunk at a time.
+func StoreCachedResponse(ctx context.Context, cached *CachedResponse, body io.Reader) error {
+	db, err := GetDB()
+	if err != nil {
+		return err
+	}
+
+	err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(cached).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("key = ?", cached.Key).Delete(&CachedResponseChunk{}).Error; err != nil {
+			return err
+		}
+
+		buf := make([]byte, cacheChunkSize)
+		for seq := 0; ; seq++ {
+			n, err := io.ReadFull(body, buf)
+			if n > 0 {
+				chunk := CachedResponseChunk{Key: cached.Key, Seq: seq, Data: buf[:n]}
+				if err := tx.Create(&chunk).Error; err != nil {
+					return err
+				}
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+	if err != nil {
+		zap.L().Error("store_cached_response",
+			zap.String("message", "failed to cache response"),
+			zap.Error(err),
+		)
+		return fmt.Errorf("failed to cache response: %w", err)
+	}
+	return nil
+}
+
+// OpenCachedBody returns a reader of the body cached under key, which reads the body a
+// chunk at a time as it is consumed
+func OpenCachedBody(ctx context.Context, key string) (io.Reader, error) {
+	db, err := GetDB()
+	if err != nil {
+		return nil, err
+	}
+	return &cachedBody{db: db.WithContext(ctx), key: key}, nil
+}
+
+// cachedBody reads the chunks of a cached body in order
+type cachedBody struct {
+	db    *gorm.DB
+	key   string
+	seq   int
+	chunk bytes.Reader
+	done  bool
+}
+
+func (cb *cachedBody) Read(p []byte) (int, error) {
+	for cb.chunk.Len() == 0 {
+		if cb.done {
+			return 0, io.EOF
+		}
+		var chunks []CachedResponseChunk
+		if err := cb.db.Where("key = ? AND seq = ?", cb.key, cb.seq).Limit(1).Find(&chunks).Error; err != nil {
+			return 0, fmt.Errorf("failed to read cached response: %w", err)
+		}
+		if len(chunks) == 0 {
+			cb.done = true
+			continue
+		}
+		cb.chunk.Reset(chunks[0].Data)
+		cb.seq++
+	}
+	return cb.chunk.Read(p)
+}
+
+// ListCachedResponses returns every cached response without its body, newest first
+func ListCachedResponses(ctx context.Context) ([]CachedResponse, error) {
+	db, err := GetDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var cached []CachedResponse
+	err = db.WithContext(ctx).Order("created_at DESC").Find(&cached).Error
+	if err != nil {
+		zap.L().Error("list_cached_responses",
+			zap.String("message", "failed to list cached responses"),
+			zap.Error(err),
+		)
+		return nil, fmt.Errorf("failed to list cached responses: %w", err)
+	}
+	return cached, nil
+}
+
+// PurgeCache deletes cached responses, only the expired ones when expiredOnly is set,
+// and returns how many were deleted
+func PurgeCache(ctx context.Context, expiredOnly bool) (int64, error) {
+	db, err := GetDB()
+	if err != nil {
+		return 0, err
+	}
+
+	var result *gorm.DB
+	err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		query := tx.Session(&gorm.Session{AllowGlobalUpdate: true})
+		chunks := tx.Session(&gorm.Session{AllowGlobalUpdate: true})
+		if expiredOnly {
+			now := time.Now()
+			query = query.Where("expires_at <= ?", now)
+			chunks = chunks.Where("key IN (?)", tx.Session(&gorm.Session{NewDB: true}).Model(&CachedResponse{}).Select("key").Where("expires_at <= ?", now))
+		}
+		if err := chunks.Delete(&CachedResponseChunk{}).Error; err != nil {
+			return err
+		}
+		result = query.Delete(&CachedResponse{})
+		return result.Error
+	})
+	if err != nil {
+		zap.L().Error("purge_cache",
+			zap.String("message", "failed to purge cache"),
+			zap.Error(err),
+		)
+		return 0, fmt.Errorf("failed to purge cache: %w", err)
+	}
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,100 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+func initTestDB(t *testing.T) {
+	t.Helper()
+	db, err := InitDB(t.TempDir())
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+		DB = nil
+	})
+}
+
+func readCachedBody(t *testing.T, key string) []byte {
+	t.Helper()
+	body, err := OpenCachedBody(context.Background(), key)
+	if err != nil {
+		t.Fatalf("OpenCachedBody: %v", err)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("reading cached body: %v", err)
+	}
+	return data
+}
+
+func TestCachedBodyRoundTripsInChunks(t *testing.T) {
+	initTestDB(t)
+	ctx := context.Background()
+
+	body := make([]byte, 2*cacheChunkSize+12345)
+	for i := range body {
+		body[i] = byte(rand.N(256))
+	}
+	cached := &CachedResponse{Key: "chunked", CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := StoreCachedResponse(ctx, cached, bytes.NewReader(body)); err != nil {
+		t.Fatalf("StoreCachedResponse: %v", err)
+	}
+
+	var chunks int64
+	DB.Model(&CachedResponseChunk{}).Where("key = ?", "chunked").Count(&chunks)
+	if chunks != 3 {
+		t.Errorf("body stored in %d chunks, want 3", chunks)
+	}
+	if got := readCachedBody(t, "chunked"); !bytes.Equal(got, body) {
+		t.Fatalf("read back %d bytes, want the %d stored", len(got), len(body))
+	}
+
+	// Storing again replaces every chunk of the previous body
+	if err := StoreCachedResponse(ctx, cached, bytes.NewReader([]byte("short"))); err != nil {
+		t.Fatalf("StoreCachedResponse: %v", err)
+	}
+	if got := readCachedBody(t, "chunked"); string(got) != "short" {
+		t.Fatalf("read back %q after replacing, want %q", got, "short")
+	}
+}
+
+func TestPurgeCacheRemovesChunks(t *testing.T) {
+	initTestDB(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	for key, expires := range map[string]time.Time{"fresh": now.Add(time.Hour), "stale": now.Add(-time.Hour)} {
+		cached := &CachedResponse{Key: key, CreatedAt: now, ExpiresAt: expires}
+		if err := StoreCachedResponse(ctx, cached, bytes.NewReader([]byte(key))); err != nil {
+			t.Fatalf("StoreCachedResponse: %v", err)
+		}
+	}
+
+	if n, err := PurgeCache(ctx, true); err != nil || n != 1 {
+		t.Fatalf("PurgeCache(expired) = %d, %v; want 1 response", n, err)
+	}
+	if got := readCachedBody(t, "stale"); len(got) != 0 {
+		t.Errorf("expired body still readable: %q", got)
+	}
+	if got := readCachedBody(t, "fresh"); string(got) != "fresh" {
+		t.Errorf("fresh body = %q, want it kept", got)
+	}
+
+	if n, err := PurgeCache(ctx, false); err != nil || n != 1 {
+		t.Fatalf("PurgeCache(all) = %d, %v; want 1 response", n, err)
+	}
+	var chunks int64
+	DB.Model(&CachedResponseChunk{}).Count(&chunks)
+	if chunks != 0 {
+		t.Errorf("%d chunks left after purging the cache", chunks)
+	}
+}
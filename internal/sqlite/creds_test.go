@@ -0,0 +1,262 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// describeCreds renders credentials as identity:secret pairs for comparison
+func describeCreds(creds []Creds) []string {
+	out := make([]string, len(creds))
+	for i, c := range creds {
+		out[i] = c.Email + "|" + c.Username + ":" + c.Password + "|" + c.HashedPassword
+	}
+	return out
+}
+
+func TestResultCredentials(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields ResultFields
+		want   []string
+	}{
+		{
+			name:   "email and password",
+			fields: ResultFields{Email: []string{"a@corp.com"}, Password: []string{"hunter2"}},
+			want:   []string{"a@corp.com|:hunter2|"},
+		},
+		{
+			name: "every identity with every secret",
+			fields: ResultFields{
+				Email:          []string{"a@corp.com", "b@corp.com"},
+				Username:       []string{"admin"},
+				Password:       []string{"hunter2"},
+				HashedPassword: []string{"5f4dcc3b"},
+			},
+			want: []string{
+				"a@corp.com|:hunter2|", "a@corp.com|:|5f4dcc3b",
+				"b@corp.com|:hunter2|", "b@corp.com|:|5f4dcc3b",
+				"|admin:hunter2|", "|admin:|5f4dcc3b",
+			},
+		},
+		{
+			name:   "hash only",
+			fields: ResultFields{Username: []string{"root"}, HashedPassword: []string{"5f4dcc3b"}},
+			want:   []string{"|root:|5f4dcc3b"},
+		},
+		{
+			name:   "secret without identity",
+			fields: ResultFields{Password: []string{"hunter2"}},
+			want:   []string{"|:hunter2|"},
+		},
+		{
+			name:   "repeated and blank values",
+			fields: ResultFields{Email: []string{"a@corp.com", " a@corp.com ", ""}, Password: []string{"hunter2", "hunter2", "  "}},
+			want:   []string{"a@corp.com|:hunter2|"},
+		},
+		{
+			name:   "identity without secret",
+			fields: ResultFields{Email: []string{"a@corp.com"}, Username: []string{"admin"}},
+			want:   []string{},
+		},
+		{
+			name:   "empty result",
+			fields: ResultFields{},
+			want:   []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := describeCreds(Result{ResultFields: tt.fields}.Credentials())
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Credentials() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResultCredentialsCarrySource(t *testing.T) {
+	seen := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	result := Result{ResultFields: ResultFields{
+		DehashedId:     "id-1",
+		Email:          []string{"a@corp.com"},
+		HashedPassword: []string{"5f4dcc3b"},
+		HashType:       "md5",
+		DatabaseName:   "breach",
+	}}
+	result.UpdatedAt = seen
+
+	creds := result.Credentials()
+	if len(creds) != 1 {
+		t.Fatalf("Credentials() returned %d pairs, want 1", len(creds))
+	}
+	c := creds[0]
+	if c.DehashedId != "id-1" || c.HashType != "md5" || c.DatabaseName != "breach" || c.Occurrences != 1 || !c.LastSeen.Equal(seen) {
+		t.Errorf("Credentials()[0] = %+v, want the id, hash type, database, one occurrence and last seen of the result", c)
+	}
+}
+
+func TestStoreCredsUpserts(t *testing.T) {
+	early := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	late := early.Add(24 * time.Hour)
+	pair := func(email, password, id string, seen time.Time) Creds {
+		return Creds{Email: email, Password: password, DehashedId: id, LastSeen: seen}
+	}
+
+	tests := []struct {
+		name            string
+		batches         [][]Creds
+		wantRows        int64
+		wantOccurrences int
+		wantLastSeen    time.Time
+	}{
+		{
+			name:            "same pair stored twice",
+			batches:         [][]Creds{{pair("a@corp.com", "x", "id-1", early)}, {pair("a@corp.com", "x", "id-1", early)}},
+			wantRows:        1,
+			wantOccurrences: 2,
+			wantLastSeen:    early,
+		},
+		{
+			name:            "same pair twice in one batch",
+			batches:         [][]Creds{{pair("a@corp.com", "x", "id-1", early), pair("a@corp.com", "x", "id-1", early)}},
+			wantRows:        1,
+			wantOccurrences: 2,
+			wantLastSeen:    early,
+		},
+		{
+			name:            "latest sighting is kept",
+			batches:         [][]Creds{{pair("a@corp.com", "x", "id-1", late)}, {pair("a@corp.com", "x", "id-1", early)}},
+			wantRows:        1,
+			wantOccurrences: 2,
+			wantLastSeen:    late,
+		},
+		{
+			name:            "other source is another pair",
+			batches:         [][]Creds{{pair("a@corp.com", "x", "id-1", early)}, {pair("a@corp.com", "x", "id-2", early)}},
+			wantRows:        2,
+			wantOccurrences: 1,
+			wantLastSeen:    early,
+		},
+		{
+			name:            "other secret is another pair",
+			batches:         [][]Creds{{pair("a@corp.com", "x", "id-1", early), pair("a@corp.com", "y", "id-1", early)}},
+			wantRows:        2,
+			wantOccurrences: 1,
+			wantLastSeen:    early,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			initTestDB(t)
+			ctx := context.Background()
+			for _, batch := range tt.batches {
+				if err := StoreCreds(ctx, batch); err != nil {
+					t.Fatalf("StoreCreds: %v", err)
+				}
+			}
+
+			var rows int64
+			if err := DB.Model(&Creds{}).Count(&rows).Error; err != nil {
+				t.Fatal(err)
+			}
+			if rows != tt.wantRows {
+				t.Errorf("stored %d rows, want %d", rows, tt.wantRows)
+			}
+			var first Creds
+			if err := DB.Order("id").First(&first).Error; err != nil {
+				t.Fatal(err)
+			}
+			if first.Occurrences != tt.wantOccurrences {
+				t.Errorf("occurrences = %d, want %d", first.Occurrences, tt.wantOccurrences)
+			}
+			if !first.LastSeen.Equal(tt.wantLastSeen) {
+				t.Errorf("last seen = %s, want %s", first.LastSeen, tt.wantLastSeen)
+			}
+		})
+	}
+}
+
+func TestDedupeCredsMergesLegacyDuplicates(t *testing.T) {
+	dir := t.TempDir()
+
+	// A creds table as it was created before credentials had a natural key
+	legacy, err := sql.Open(driverName, filepath.Join(dir, "dehashed.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = legacy.Exec(`CREATE TABLE creds (
+		id integer PRIMARY KEY AUTOINCREMENT,
+		created_at datetime, updated_at datetime, deleted_at datetime,
+		email text, username text, password text)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = legacy.Exec(`INSERT INTO creds (created_at, updated_at, email, username, password) VALUES
+		('2026-01-01 00:00:00', '2026-01-01 00:00:00', 'a@corp.com', NULL, 'x'),
+		('2026-01-02 00:00:00', '2026-01-03 00:00:00', 'a@corp.com', '', 'x'),
+		('2026-01-02 00:00:00', '2026-01-02 00:00:00', 'a@corp.com', NULL, 'x'),
+		('2026-01-01 00:00:00', '2026-01-01 00:00:00', 'a@corp.com', NULL, 'y'),
+		('2026-01-01 00:00:00', '2026-01-01 00:00:00', NULL, 'root', 'z')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy.Close()
+
+	db, err := InitDB(dir)
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+		DB = nil
+	})
+	if !db.Migrator().HasIndex(&Creds{}, credsKeyIndex) {
+		t.Fatalf("index %s was not created", credsKeyIndex)
+	}
+
+	var creds []Creds
+	if err := db.Order("id").Find(&creds).Error; err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		id          uint
+		identity    string
+		secret      string
+		occurrences int
+		lastSeen    string
+	}{
+		{id: 1, identity: "a@corp.com", secret: "x", occurrences: 3, lastSeen: "2026-01-03"},
+		{id: 4, identity: "a@corp.com", secret: "y", occurrences: 1, lastSeen: "2026-01-01"},
+		{id: 5, identity: "root", secret: "z", occurrences: 1, lastSeen: "2026-01-01"},
+	}
+	if len(creds) != len(tests) {
+		t.Fatalf("%d credentials after deduplication, want %d: %q", len(creds), len(tests), describeCreds(creds))
+	}
+	for i, tt := range tests {
+		c := creds[i]
+		if c.ID != tt.id || c.Identity() != tt.identity || c.Secret() != tt.secret || c.Occurrences != tt.occurrences || c.LastSeen.Format(time.DateOnly) != tt.lastSeen {
+			t.Errorf("credential %d = id %d %s:%s, %d occurrences, last seen %s; want id %d %s:%s, %d occurrences, last seen %s",
+				i, c.ID, c.Identity(), c.Secret(), c.Occurrences, c.LastSeen.Format(time.DateOnly),
+				tt.id, tt.identity, tt.secret, tt.occurrences, tt.lastSeen)
+		}
+	}
+
+	// Storing a merged pair again counts it up instead of adding a row
+	if err := StoreCreds(context.Background(), []Creds{{Email: "a@corp.com", Password: "x"}}); err != nil {
+		t.Fatalf("StoreCreds: %v", err)
+	}
+	var count int64
+	db.Model(&Creds{}).Where("email = ? AND password = ?", "a@corp.com", "x").Count(&count)
+	if count != 1 {
+		t.Errorf("%d rows for a merged pair after storing it again, want 1", count)
+	}
+}
@@ -1,24 +1,28 @@
 package sqlite
 
 import (
+	"context"
 	"fmt"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"regexp"
+	"strings"
+	"time"
 )
 
 // QueryResults queries the database for results based on the provided options
-func QueryResults(options *DBOptions) ([]Result, error) {
-	db := GetDB()
+func QueryResults(ctx context.Context, options *DBOptions) ([]Result, error) {
+	db, err := GetDB()
+	if err != nil {
+		return nil, err
+	}
 	var results []Result
-	query := db.Model(&Result{})
+	query := db.WithContext(ctx).Model(&Result{})
 
-	// Apply filters based on the provided options
+	// Apply filters, order and page based on the provided options
 	query = applyFilters(query, options)
-
-	// Apply limit
-	if options.Limit > 0 {
-		query = query.Limit(options.Limit)
-	}
+	query = applyPage(query, options)
 
 	// Execute the query
 	if err := query.Find(&results).Error; err != nil {
@@ -34,112 +38,231 @@ func QueryResults(options *DBOptions) ([]Result, error) {
 
 // applyFilters applies filters to the query based on the provided options
 func applyFilters(query *gorm.DB, options *DBOptions) *gorm.DB {
-	// Helper function to apply filter based on exact match setting
-	applyFilter := func(field, value string) *gorm.DB {
-		if value == "" {
-			return query
-		}
+	if err := options.Validate(); err != nil {
+		query.AddError(err)
+		return query
+	}
 
-		if options.ExactMatch {
-			return query.Where(field+" = ?", value)
-		} else {
-			return query.Where(field+" LIKE ?", "%"+value+"%")
+	// Each field filter matches a single value of the field, see ResultAttribute
+	for _, filter := range options.filters() {
+		if filter.value == "" {
+			continue
 		}
+		query = query.Where("results.id IN (?)", attributeMatch(query, filter.field, filter.value, options.matchMode(filter.field)))
 	}
 
-	// Apply filters for each field if provided
-	if options.Email != "" {
-		query = applyFilter("email", options.Email)
+	// Apply date range filters on when results were stored
+	if !options.CreatedAfter.IsZero() {
+		query = query.Where("results.created_at >= ?", options.CreatedAfter)
 	}
-
-	if options.Username != "" {
-		query = applyFilter("username", options.Username)
+	if !options.CreatedBefore.IsZero() {
+		query = query.Where("results.created_at < ?", options.CreatedBefore)
 	}
 
-	if options.IPAddress != "" {
-		query = applyFilter("ip_address", options.IPAddress)
+	// Apply provenance filters using the run links
+	if len(options.RunIDs) > 0 || !options.Since.IsZero() {
+		runResults := query.Session(&gorm.Session{NewDB: true}).Model(&RunResult{}).Select("dehashed_id")
+		if len(options.RunIDs) > 0 {
+			runResults = runResults.Where("query_run_id IN ?", options.RunIDs)
+		}
+		if !options.Since.IsZero() {
+			runResults = runResults.Where("last_seen >= ?", options.Since)
+		}
+		query = query.Where("dehashed_id IN (?)", runResults)
 	}
 
-	if options.Password != "" {
-		query = applyFilter("password", options.Password)
+	// Apply non-empty field filters
+	for _, field := range options.NonEmptyFields {
+		if field = fieldColumn(field); field != "" {
+			values := query.Session(&gorm.Session{NewDB: true}).Model(&ResultAttribute{}).Select("result_id").Where("field = ?", field)
+			query = query.Where("results.id IN (?)", values)
+		}
 	}
 
-	if options.HashedPassword != "" {
-		query = applyFilter("hashed_password", options.HashedPassword)
-	}
+	return query
+}
 
-	if options.Name != "" {
-		query = applyFilter("name", options.Name)
+// applyPage orders the results and selects the page of them given by the limit and offset.
+// Results are ordered by when they were stored after the sort fields, so pages are stable.
+func applyPage(query *gorm.DB, options *DBOptions) *gorm.DB {
+	for _, order := range options.Sort {
+		query = query.Order(clause.OrderByColumn{
+			Column: clause.Column{Name: sortExpr(order.Field), Raw: true},
+			Desc:   order.Desc,
+		})
 	}
+	query = query.Order("results.id")
 
-	if options.Vin != "" {
-		query = applyFilter("vin", options.Vin)
+	if options.Limit > 0 {
+		query = query.Limit(options.Limit)
 	}
-
-	if options.LicensePlate != "" {
-		query = applyFilter("license_plate", options.LicensePlate)
+	if options.Offset > 0 {
+		query = query.Offset(options.Offset)
 	}
+	return query
+}
+
+// SortOrder orders results by a field
+type SortOrder struct {
+	Field string
+	Desc  bool
+}
 
-	if options.Address != "" {
-		query = applyFilter("address", options.Address)
+// ParseSort parses a comma-separated list of fields to sort by, each optionally followed by
+// :asc or :desc (e.g., email,created:desc)
+func ParseSort(spec string) ([]SortOrder, error) {
+	var orders []SortOrder
+	for _, part := range strings.Split(spec, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		field, direction, _ := strings.Cut(part, ":")
+		order := SortOrder{Field: strings.TrimSpace(field)}
+		switch strings.ToLower(strings.TrimSpace(direction)) {
+		case "", "asc":
+		case "desc":
+			order.Desc = true
+		default:
+			return nil, fmt.Errorf("invalid sort direction %q for %s, expected asc or desc", direction, order.Field)
+		}
+		if sortExpr(order.Field) == "" {
+			return nil, fmt.Errorf("unknown sort field %q", order.Field)
+		}
+		orders = append(orders, order)
 	}
+	return orders, nil
+}
 
-	if options.Phone != "" {
-		query = applyFilter("phone", options.Phone)
+// sortExpr returns the expression results are sorted by for a field. Multi-valued fields
+// sort by their first value in normalized order.
+func sortExpr(field string) string {
+	switch strings.ToLower(strings.TrimSpace(field)) {
+	case "created", "created_at":
+		return "results.created_at"
+	case "updated", "updated_at":
+		return "results.updated_at"
+	case "database", "database_name":
+		return "results.database_name"
+	case "hash_type":
+		return "results.hash_type"
+	case "dehashed_id":
+		return "results.dehashed_id"
 	}
+	if column := fieldColumn(field); column != "" {
+		return fmt.Sprintf("(SELECT MIN(normalized_value) FROM result_attributes WHERE result_id = results.id AND field = '%s')", column)
+	}
+	return ""
+}
 
-	if options.Social != "" {
-		query = applyFilter("social", options.Social)
+// Validate reports options that cannot be applied: per-field match modes of unknown fields,
+// invalid regular expressions, unknown sort fields, a negative offset and an empty date range
+func (o *DBOptions) Validate() error {
+	for field := range o.FieldMatch {
+		if fieldColumn(field) == "" {
+			return fmt.Errorf("unknown field %q", field)
+		}
 	}
+	for _, filter := range o.filters() {
+		if filter.value == "" || o.matchMode(filter.field) != MatchRegex {
+			continue
+		}
+		if _, err := regexp.Compile(filter.value); err != nil {
+			return fmt.Errorf("invalid regex for %s: %w", filter.field, err)
+		}
+	}
+	for _, order := range o.Sort {
+		if sortExpr(order.Field) == "" {
+			return fmt.Errorf("unknown sort field %q", order.Field)
+		}
+	}
+	if o.Offset < 0 {
+		return fmt.Errorf("invalid offset %d", o.Offset)
+	}
+	if !o.CreatedAfter.IsZero() && !o.CreatedBefore.IsZero() && !o.CreatedAfter.Before(o.CreatedBefore) {
+		return fmt.Errorf("created after %s is not before created before %s",
+			o.CreatedAfter.Format(time.DateTime), o.CreatedBefore.Format(time.DateTime))
+	}
+	return nil
+}
 
-	if options.CryptoCurrencyAddress != "" {
-		query = applyFilter("cryptocurrency_address", options.CryptoCurrencyAddress)
+// HasFilters reports whether the options restrict which results match, rather than
+// matching every stored result
+func (o *DBOptions) HasFilters() bool {
+	for _, filter := range o.filters() {
+		if filter.value != "" {
+			return true
+		}
 	}
+	return len(o.NonEmptyFields) > 0 || len(o.RunIDs) > 0 || !o.Since.IsZero() ||
+		!o.CreatedAfter.IsZero() || !o.CreatedBefore.IsZero()
+}
+
+// fieldFilter is the filter value of a field
+type fieldFilter struct {
+	field string
+	value string
+}
 
-	if options.Domain != "" {
-		query = applyFilter("url", options.Domain)
+// filters returns the filter value of every field by column
+func (o *DBOptions) filters() []fieldFilter {
+	return []fieldFilter{
+		{"email", o.Email},
+		{"username", o.Username},
+		{"ip_address", o.IPAddress},
+		{"password", o.Password},
+		{"hashed_password", o.HashedPassword},
+		{"name", o.Name},
+		{"vin", o.Vin},
+		{"license_plate", o.LicensePlate},
+		{"address", o.Address},
+		{"phone", o.Phone},
+		{"social", o.Social},
+		{"cryptocurrency_address", o.CryptoCurrencyAddress},
+		{"url", o.Domain},
 	}
+}
 
-	// Apply non-empty field filters
-	for _, field := range options.NonEmptyFields {
-		switch field {
-		case "username":
-			query = query.Where("JSON_ARRAY_LENGTH(username) > 0")
-		case "email":
-			query = query.Where("JSON_ARRAY_LENGTH(email) > 0")
-		case "ip_address", "ipaddress", "ip":
-			query = query.Where("JSON_ARRAY_LENGTH(ip_address) > 0")
-		case "password":
-			query = query.Where("JSON_ARRAY_LENGTH(password) > 0")
-		case "hashed_password", "hash":
-			query = query.Where("JSON_ARRAY_LENGTH(hashed_password) > 0")
-		case "name":
-			query = query.Where("JSON_ARRAY_LENGTH(name) > 0")
-		case "vin":
-			query = query.Where("JSON_ARRAY_LENGTH(vin) > 0")
-		case "license_plate", "license":
-			query = query.Where("JSON_ARRAY_LENGTH(license_plate) > 0")
-		case "address":
-			query = query.Where("JSON_ARRAY_LENGTH(address) > 0")
-		case "phone":
-			query = query.Where("JSON_ARRAY_LENGTH(phone) > 0")
-		case "social":
-			query = query.Where("JSON_ARRAY_LENGTH(social) > 0")
-		case "cryptocurrency_address", "crypto":
-			query = query.Where("JSON_ARRAY_LENGTH(cryptocurrency_address) > 0")
-		case "url", "domain":
-			query = query.Where("JSON_ARRAY_LENGTH(url) > 0")
+// matchMode returns how the filter of a field matches values
+func (o *DBOptions) matchMode(column string) MatchMode {
+	for field, mode := range o.FieldMatch {
+		if fieldColumn(field) == column {
+			return mode
 		}
 	}
+	if o.ExactMatch {
+		return MatchExact
+	}
+	return o.Match
+}
 
-	return query
+// fieldColumn returns the column of a field name or alias, such as ip or hash
+func fieldColumn(field string) string {
+	field = strings.ToLower(strings.TrimSpace(field))
+	switch field {
+	case "username", "email", "password", "name", "vin", "address", "phone", "social":
+		return field
+	case "ip_address", "ipaddress", "ip":
+		return "ip_address"
+	case "hashed_password", "hash":
+		return "hashed_password"
+	case "license_plate", "license":
+		return "license_plate"
+	case "cryptocurrency_address", "crypto":
+		return "cryptocurrency_address"
+	case "url", "domain":
+		return "url"
+	}
+	return ""
 }
 
 // GetResultsCount returns the count of results matching the provided options
-func GetResultsCount(options *DBOptions) (int64, error) {
-	db := GetDB()
+func GetResultsCount(ctx context.Context, options *DBOptions) (int64, error) {
+	db, err := GetDB()
+	if err != nil {
+		return 0, err
+	}
 	var count int64
-	query := db.Model(&Result{})
+	query := db.WithContext(ctx).Model(&Result{})
 
 	// Apply filters based on the provided options
 	query = applyFilters(query, options)
@@ -0,0 +1,237 @@
+package sqlite
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// testResults are stored in this order by seedResults
+var testResults = []ResultFields{
+	{DehashedId: "r1", Email: []string{"Admin@Corp.com"}, Username: []string{"admin"}, Password: []string{"hunter2"}, IpAddress: []string{"10.0.0.1"}, DatabaseName: "alpha"},
+	{DehashedId: "r2", Email: []string{"bob@corp.com", "bob@home.net"}, Username: []string{"Bob_99"}, Name: []string{"Bob Smith"}, DatabaseName: "beta"},
+	{DehashedId: "r3", Email: []string{"carol@example.org"}, Username: []string{"carol"}, HashedPassword: []string{"5f4dcc3b"}, Phone: []string{"+1 555 0100"}, DatabaseName: "alpha"},
+	{DehashedId: "r4", Email: []string{"dave@corp.co"}, Username: []string{"100%_sure"}},
+}
+
+// seedResults opens a test database holding testResults
+func seedResults(t *testing.T) {
+	t.Helper()
+	initTestDB(t)
+	var results DehashedResults
+	for _, fields := range testResults {
+		results.Results = append(results.Results, Result{ResultFields: fields})
+	}
+	if err := StoreResults(context.Background(), results); err != nil {
+		t.Fatalf("StoreResults: %v", err)
+	}
+}
+
+func resultIDs(results []Result) []string {
+	ids := make([]string, len(results))
+	for i, r := range results {
+		ids[i] = r.DehashedId
+	}
+	return ids
+}
+
+func queryIDs(t *testing.T, options *DBOptions) []string {
+	t.Helper()
+	results, err := QueryResults(context.Background(), options)
+	if err != nil {
+		t.Fatalf("QueryResults: %v", err)
+	}
+	return resultIDs(results)
+}
+
+func TestAttributeMatch(t *testing.T) {
+	seedResults(t)
+
+	tests := []struct {
+		name  string
+		field string
+		value string
+		mode  MatchMode
+		want  []string
+	}{
+		{name: "contains", field: "email", value: "corp", mode: MatchContains, want: []string{"r1", "r2", "r4"}},
+		{name: "contains ignores case", field: "email", value: "ADMIN@", mode: MatchContains, want: []string{"r1"}},
+		{name: "contains matches LIKE wildcards literally", field: "username", value: "0%_", mode: MatchContains, want: []string{"r4"}},
+		{name: "contains percent", field: "username", value: "%", mode: MatchContains, want: []string{"r4"}},
+		{name: "exact", field: "email", value: "admin@corp.com", mode: MatchExact, want: []string{"r1"}},
+		{name: "exact ignores surrounding space", field: "email", value: " admin@corp.com ", mode: MatchExact, want: []string{"r1"}},
+		{name: "exact matches any element", field: "email", value: "bob@home.net", mode: MatchExact, want: []string{"r2"}},
+		{name: "exact needs the whole element", field: "email", value: "corp.com", mode: MatchExact, want: []string{}},
+		{name: "exact with spaces", field: "name", value: "bob smith", mode: MatchExact, want: []string{"r2"}},
+		{name: "prefix", field: "username", value: "BOB", mode: MatchPrefix, want: []string{"r2"}},
+		{name: "prefix of another element", field: "email", value: "bob@home", mode: MatchPrefix, want: []string{"r2"}},
+		{name: "suffix", field: "email", value: "@corp.com", mode: MatchSuffix, want: []string{"r1", "r2"}},
+		{name: "suffix ignores case", field: "email", value: "@CORP.CO", mode: MatchSuffix, want: []string{"r4"}},
+		{name: "glob", field: "email", value: "*@corp.co", mode: MatchGlob, want: []string{"r4"}},
+		{name: "glob with literal prefix", field: "email", value: "Bob@*.net", mode: MatchGlob, want: []string{"r2"}},
+		{name: "glob set", field: "username", value: "[A-C]*", mode: MatchGlob, want: []string{"r1", "r2", "r3"}},
+		{name: "glob single character", field: "username", value: "bob_??", mode: MatchGlob, want: []string{"r2"}},
+		{name: "regex", field: "username", value: `bob_\d+`, mode: MatchRegex, want: []string{"r2"}},
+		{name: "regex ignores case", field: "username", value: "ADMIN", mode: MatchRegex, want: []string{"r1"}},
+		{name: "regex matches whole values", field: "username", value: "adm", mode: MatchRegex, want: []string{}},
+		{name: "other field", field: "username", value: "corp", mode: MatchContains, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids []string
+			err := DB.Model(&Result{}).
+				Where("id IN (?)", attributeMatch(DB, tt.field, tt.value, tt.mode)).
+				Order("id").
+				Pluck("dehashed_id", &ids).Error
+			if err != nil {
+				t.Fatalf("query: %v", err)
+			}
+			if ids == nil {
+				ids = []string{}
+			}
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("matched %q, want %q", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryResultsFilters(t *testing.T) {
+	seedResults(t)
+	ctx := context.Background()
+
+	// r3 and r4 were stored a day later
+	later := time.Now().Add(24 * time.Hour)
+	if err := DB.Model(&Result{}).Where("dehashed_id IN ?", []string{"r3", "r4"}).Update("created_at", later).Error; err != nil {
+		t.Fatal(err)
+	}
+	run, err := CreateRun(ctx, &QueryOptions{}, "email:@corp.com")
+	if err != nil {
+		t.Fatalf("CreateRun: %v", err)
+	}
+	linked := DehashedResults{Results: []Result{{ResultFields: testResults[1]}, {ResultFields: testResults[2]}}}
+	if err := LinkRunResults(ctx, run.ID, linked); err != nil {
+		t.Fatalf("LinkRunResults: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		options DBOptions
+		want    []string
+	}{
+		{name: "no filters", want: []string{"r1", "r2", "r3", "r4"}},
+		{name: "fields are combined", options: DBOptions{Email: "corp", Username: "admin"}, want: []string{"r1"}},
+		{name: "match mode of a single field", options: DBOptions{Email: "@corp.com", Username: "o", FieldMatch: map[string]MatchMode{"email": MatchSuffix}}, want: []string{"r2"}},
+		{name: "field match by alias", options: DBOptions{IPAddress: "10.0.0.1", FieldMatch: map[string]MatchMode{"ip": MatchExact}}, want: []string{"r1"}},
+		{name: "exact match", options: DBOptions{Username: "admin", ExactMatch: true}, want: []string{"r1"}},
+		{name: "exact match needs the whole value", options: DBOptions{Username: "adm", ExactMatch: true}, want: []string{}},
+		{name: "match mode for every field", options: DBOptions{Email: "bob@", Match: MatchPrefix}, want: []string{"r2"}},
+		{name: "domain filters urls", options: DBOptions{Domain: "corp"}, want: []string{}},
+		{name: "non-empty field", options: DBOptions{NonEmptyFields: []string{"hash"}}, want: []string{"r3"}},
+		{name: "non-empty fields", options: DBOptions{NonEmptyFields: []string{"phone", "username"}}, want: []string{"r3"}},
+		{name: "created after", options: DBOptions{CreatedAfter: later.Add(-time.Hour)}, want: []string{"r3", "r4"}},
+		{name: "created before", options: DBOptions{CreatedBefore: later.Add(-time.Hour)}, want: []string{"r1", "r2"}},
+		{name: "run", options: DBOptions{RunIDs: []uint{run.ID}}, want: []string{"r2", "r3"}},
+		{name: "other run", options: DBOptions{RunIDs: []uint{run.ID + 1}}, want: []string{}},
+		{name: "seen since", options: DBOptions{Since: time.Now().Add(-time.Hour)}, want: []string{"r2", "r3"}},
+		{name: "sort by multi-valued field", options: DBOptions{Sort: []SortOrder{{Field: "username"}}}, want: []string{"r4", "r1", "r2", "r3"}},
+		{name: "sort descending", options: DBOptions{Sort: []SortOrder{{Field: "username", Desc: true}}}, want: []string{"r3", "r2", "r1", "r4"}},
+		{name: "ties keep stored order", options: DBOptions{Sort: []SortOrder{{Field: "database"}}}, want: []string{"r4", "r1", "r3", "r2"}},
+		{name: "page", options: DBOptions{Sort: []SortOrder{{Field: "username"}}, Limit: 2, Offset: 1}, want: []string{"r1", "r2"}},
+		{name: "filters with page", options: DBOptions{Email: "corp", Limit: 1, Offset: 2}, want: []string{"r4"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := queryIDs(t, &tt.options)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("QueryResults = %q, want %q", got, tt.want)
+			}
+
+			// Counts ignore the page
+			count, err := GetResultsCount(ctx, &tt.options)
+			if err != nil {
+				t.Fatalf("GetResultsCount: %v", err)
+			}
+			if tt.options.Limit == 0 && tt.options.Offset == 0 && count != int64(len(tt.want)) {
+				t.Errorf("GetResultsCount = %d, want %d", count, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestQueryResultsInvalidOptions(t *testing.T) {
+	seedResults(t)
+
+	tests := []struct {
+		name    string
+		options DBOptions
+		wantErr string
+	}{
+		{name: "unknown field match", options: DBOptions{FieldMatch: map[string]MatchMode{"nickname": MatchExact}}, wantErr: `unknown field "nickname"`},
+		{name: "invalid regex", options: DBOptions{Username: "adm(", Match: MatchRegex}, wantErr: "invalid regex for username"},
+		{name: "unknown sort field", options: DBOptions{Sort: []SortOrder{{Field: "nickname"}}}, wantErr: `unknown sort field "nickname"`},
+		{name: "negative offset", options: DBOptions{Offset: -1}, wantErr: "invalid offset -1"},
+		{name: "empty date range", options: DBOptions{CreatedAfter: time.Now(), CreatedBefore: time.Now().Add(-time.Hour)}, wantErr: "is not before created before"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := QueryResults(context.Background(), &tt.options)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("QueryResults error = %v, want %q", err, tt.wantErr)
+			}
+			if _, err := GetResultsCount(context.Background(), &tt.options); err == nil {
+				t.Error("GetResultsCount accepted the options")
+			}
+		})
+	}
+}
+
+// TestFilterSQLUsesIndexes checks the query plans of the filters that are meant to be
+// answered from the attribute indexes rather than by scanning every value
+func TestFilterSQLUsesIndexes(t *testing.T) {
+	seedResults(t)
+
+	tests := []struct {
+		name    string
+		options DBOptions
+		want    string
+	}{
+		{name: "exact", options: DBOptions{Email: "bob@corp.com", Match: MatchExact}, want: "idx_attribute_normalized (field=? AND normalized_value=?)"},
+		{name: "prefix", options: DBOptions{Email: "bob", Match: MatchPrefix}, want: "idx_attribute_normalized (field=? AND normalized_value>? AND normalized_value<?)"},
+		{name: "suffix", options: DBOptions{Email: "@corp.com", Match: MatchSuffix}, want: "idx_attribute_reversed (field=? AND reversed_value>? AND reversed_value<?)"},
+		{name: "glob with literal prefix", options: DBOptions{Email: "bob@*", Match: MatchGlob}, want: "idx_attribute_normalized (field=? AND normalized_value>? AND normalized_value<?)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql := DB.ToSQL(func(tx *gorm.DB) *gorm.DB {
+				return applyFilters(tx.Model(&Result{}), &tt.options).Find(&[]Result{})
+			})
+			rows, err := DB.Raw("EXPLAIN QUERY PLAN " + sql).Rows()
+			if err != nil {
+				t.Fatalf("EXPLAIN QUERY PLAN %s: %v", sql, err)
+			}
+			defer rows.Close()
+
+			var plan []string
+			for rows.Next() {
+				var id, parent, unused int
+				var detail string
+				if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+					t.Fatal(err)
+				}
+				plan = append(plan, detail)
+			}
+			if !slices.ContainsFunc(plan, func(detail string) bool { return strings.Contains(detail, tt.want) }) {
+				t.Errorf("plan of %s does not use %s:\n%s", sql, tt.want, strings.Join(plan, "\n"))
+			}
+		})
+	}
+}
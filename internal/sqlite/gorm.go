@@ -1,11 +1,14 @@
 package sqlite
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"go.uber.org/zap"
 	"gorm.io/gorm/clause"
 	"os"
 	"path/filepath"
+	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -14,6 +17,9 @@ import (
 
 var DB *gorm.DB
 
+// ErrDatabaseNotInitialized is returned when the database is used before InitDB
+var ErrDatabaseNotInitialized = errors.New("sqlite database not initialized")
+
 // InitDB initializes the database connection
 func InitDB(dbDir string) (*gorm.DB, error) {
 	zap.L().Info("Initializing database")
@@ -25,7 +31,7 @@ func InitDB(dbDir string) (*gorm.DB, error) {
 	}
 
 	dbPath := filepath.Join(dbDir, "dehashed.sqlite")
-	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{
+	db, err := gorm.Open(sqlite.New(sqlite.Config{DriverName: driverName, DSN: dbPath}), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Silent),
 	})
 	if err != nil {
@@ -33,34 +39,60 @@ func InitDB(dbDir string) (*gorm.DB, error) {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
+	// SQLite allows a single writer, so concurrent queries share one connection
+	sqlDB, err := db.DB()
+	if err != nil {
+		zap.L().Error("Failed to get database handle", zap.Error(err))
+		return nil, fmt.Errorf("failed to get database handle: %w", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	// Duplicate credentials of older databases would keep their unique index from being created
+	if err := dedupeCreds(db); err != nil {
+		zap.L().Error("Failed to deduplicate credentials", zap.Error(err))
+		return nil, fmt.Errorf("failed to deduplicate credentials: %w", err)
+	}
+
 	// Auto migrate your models
-	err = db.AutoMigrate(&Result{}, &Creds{}, QueryOptions{}, Creds{})
+	err = db.AutoMigrate(&Result{}, &Creds{}, &QueryOptions{}, &QueryRun{}, &RunResult{}, &CachedResponse{}, &CachedResponseChunk{})
 	if err != nil {
 		zap.L().Error("Failed to migrate database", zap.Error(err))
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
+	if err := migrateAttributes(db); err != nil {
+		zap.L().Error("Failed to index stored results", zap.Error(err))
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+	if err := migrateSearch(db); err != nil {
+		zap.L().Error("Failed to index stored results for search", zap.Error(err))
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
 
 	DB = db
 	return db, nil
 }
 
 // GetDB returns the database connection
-func GetDB() *gorm.DB {
+func GetDB() (*gorm.DB, error) {
 	if DB == nil {
 		zap.L().Error("database not initialized")
-		fmt.Println("sqlite database not initialized")
-		os.Exit(1)
+		return nil, ErrDatabaseNotInitialized
 	}
-	return DB
+	return DB, nil
 }
 
-func StoreResults(results DehashedResults) error {
+// StoreResults stores results and indexes their values, skipping entries that already exist
+func StoreResults(ctx context.Context, results DehashedResults) error {
 	if len(results.Results) == 0 {
 		return nil
 	}
 
 	zap.L().Info("Storing results", zap.Int("count", len(results.Results)))
-	db := GetDB()
+	db, err := GetDB()
+	if err != nil {
+		return err
+	}
+	db = db.WithContext(ctx)
 
 	// Use batch insert with conflict handling
 	const batchSize = 100
@@ -76,8 +108,21 @@ func StoreResults(results DehashedResults) error {
 		}
 
 		batch := resultSlice[i:end]
-		// Use Clauses with OnConflict DoNothing to skip conflicts
-		err := db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&batch, batchSize).Error
+		ids := make([]string, len(batch))
+		for j, r := range batch {
+			ids[j] = r.DehashedId
+		}
+		// Results that already exist are skipped, their values are indexed along with them,
+		// for filters and for full-text search
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&batch, batchSize).Error; err != nil {
+				return err
+			}
+			if err := storeAttributes(tx, ids); err != nil {
+				return err
+			}
+			return storeSearch(tx, ids)
+		})
 		if err != nil {
 			zap.L().Warn("Error storing some results", zap.Error(err))
 			lastErr = err
@@ -88,13 +133,30 @@ func StoreResults(results DehashedResults) error {
 	return lastErr
 }
 
-func StoreCreds(creds []Creds) error {
+// credsKey is the natural key of a credential pair, see Creds
+var credsKey = []clause.Column{{Name: "email"}, {Name: "username"}, {Name: "password"}, {Name: "hashed_password"}, {Name: "dehashed_id"}}
+
+// StoreCreds stores credentials. Pairs that are already stored have their occurrences
+// counted up and their last seen time refreshed instead.
+func StoreCreds(ctx context.Context, creds []Creds) error {
 	if len(creds) == 0 {
 		return nil
 	}
 
 	zap.L().Info("Storing credentials", zap.Int("count", len(creds)))
-	db := GetDB()
+	db, err := GetDB()
+	if err != nil {
+		return err
+	}
+	db = db.WithContext(ctx)
+
+	now := time.Now()
+	for i := range creds {
+		creds[i].Occurrences = max(1, creds[i].Occurrences)
+		if creds[i].LastSeen.IsZero() {
+			creds[i].LastSeen = now
+		}
+	}
 
 	// Use batch insert with conflict handling
 	// This will insert records in batches and continue even if some fail
@@ -108,8 +170,15 @@ func StoreCreds(creds []Creds) error {
 		}
 
 		batch := creds[i:end]
-		// Use Clauses with OnConflict DoNothing to skip conflicts
-		err := db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&batch, batchSize).Error
+		err := db.Clauses(clause.OnConflict{
+			Columns: credsKey,
+			DoUpdates: clause.Assignments(map[string]any{
+				"occurrences": gorm.Expr("creds.occurrences + excluded.occurrences"),
+				"last_seen":   gorm.Expr("max(creds.last_seen, excluded.last_seen)"),
+				"updated_at":  gorm.Expr("excluded.updated_at"),
+				"hash_type":   gorm.Expr("excluded.hash_type"),
+			}),
+		}).CreateInBatches(&batch, batchSize).Error
 		if err != nil {
 			zap.L().Warn("Error storing some credentials", zap.Error(err))
 			lastErr = err
@@ -0,0 +1,120 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Iterator streams the rows of a query from the database in batches, so they are never
+// all held in memory. It holds the database connection until it is closed, so the database
+// cannot be used while iterating.
+type Iterator[T any] struct {
+	db    *gorm.DB
+	rows  *sql.Rows
+	size  int
+	batch []T
+	err   error
+}
+
+// QueryResultsIter queries the database for results like QueryResults, reading them in
+// batches of batchSize as the iterator advances
+func QueryResultsIter(ctx context.Context, options *DBOptions, batchSize int) (*Iterator[Result], error) {
+	db, err := GetDB()
+	if err != nil {
+		return nil, err
+	}
+	query := db.WithContext(ctx).Model(&Result{})
+
+	// Apply filters, order and page based on the provided options
+	query = applyFilters(query, options)
+	query = applyPage(query, options)
+
+	return newIterator[Result](query, batchSize, "query_results", "failed to query results")
+}
+
+// QueryCredsIter queries the database for the credentials found in the results matching
+// the options, in the order they were stored, reading them in batches of batchSize. Options
+// without filters match every credential, including those stored without their result.
+func QueryCredsIter(ctx context.Context, options *DBOptions, batchSize int) (*Iterator[Creds], error) {
+	db, err := GetDB()
+	if err != nil {
+		return nil, err
+	}
+	query := db.WithContext(ctx).Model(&Creds{})
+
+	// Apply filters to the results the credentials were found in
+	if options.HasFilters() {
+		results := applyFilters(db.WithContext(ctx).Model(&Result{}), options).Select("results.dehashed_id")
+		if results.Error != nil {
+			return nil, fmt.Errorf("failed to query credentials: %w", results.Error)
+		}
+		query = query.Where("creds.dehashed_id IN (?)", results)
+	}
+
+	// Apply page based on the provided options
+	query = query.Order("creds.id")
+	if options.Limit > 0 {
+		query = query.Limit(options.Limit)
+	}
+	if options.Offset > 0 {
+		query = query.Offset(options.Offset)
+	}
+
+	return newIterator[Creds](query, batchSize, "query_creds", "failed to query credentials")
+}
+
+func newIterator[T any](query *gorm.DB, batchSize int, event, message string) (*Iterator[T], error) {
+	rows, err := query.Rows()
+	if err != nil {
+		zap.L().Error(event,
+			zap.String("message", message),
+			zap.Error(err),
+		)
+		return nil, fmt.Errorf("%s: %w", message, err)
+	}
+
+	return &Iterator[T]{db: query, rows: rows, size: max(1, batchSize)}, nil
+}
+
+// Next reads the next batch of rows, reporting whether there is one
+func (it *Iterator[T]) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	it.batch = make([]T, 0, it.size)
+	for len(it.batch) < it.size && it.rows.Next() {
+		var row T
+		if err := it.db.ScanRows(it.rows, &row); err != nil {
+			it.err = err
+			return false
+		}
+		it.batch = append(it.batch, row)
+	}
+	if err := it.rows.Err(); err != nil {
+		it.err = err
+		return false
+	}
+	return len(it.batch) > 0
+}
+
+// Batch returns the batch of rows read by the last call to Next
+func (it *Iterator[T]) Batch() []T {
+	return it.batch
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *Iterator[T]) Err() error {
+	if it.err != nil {
+		return fmt.Errorf("failed to read rows: %w", it.err)
+	}
+	return nil
+}
+
+// Close releases the database connection
+func (it *Iterator[T]) Close() error {
+	return it.rows.Close()
+}
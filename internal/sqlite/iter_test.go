@@ -0,0 +1,144 @@
+package sqlite
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// drain reads every batch of an iterator, returning the batch sizes and the rows
+func drain[T any](t *testing.T, it *Iterator[T]) ([]int, []T) {
+	t.Helper()
+	defer it.Close()
+	var sizes []int
+	var rows []T
+	for it.Next() {
+		sizes = append(sizes, len(it.Batch()))
+		rows = append(rows, it.Batch()...)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("iterating: %v", err)
+	}
+	return sizes, rows
+}
+
+func TestQueryResultsIter(t *testing.T) {
+	seedResults(t)
+
+	tests := []struct {
+		name      string
+		options   DBOptions
+		batchSize int
+		wantSizes []int
+		want      []string
+	}{
+		{name: "single batch", batchSize: 10, wantSizes: []int{4}, want: []string{"r1", "r2", "r3", "r4"}},
+		{name: "exact batches", batchSize: 2, wantSizes: []int{2, 2}, want: []string{"r1", "r2", "r3", "r4"}},
+		{name: "short last batch", batchSize: 3, wantSizes: []int{3, 1}, want: []string{"r1", "r2", "r3", "r4"}},
+		{name: "batch size below one", batchSize: 0, wantSizes: []int{1, 1, 1, 1}, want: []string{"r1", "r2", "r3", "r4"}},
+		{name: "filters and sort", options: DBOptions{Email: "corp", Sort: []SortOrder{{Field: "username", Desc: true}}}, batchSize: 2, wantSizes: []int{2, 1}, want: []string{"r2", "r1", "r4"}},
+		{name: "page", options: DBOptions{Limit: 2, Offset: 1}, batchSize: 1, wantSizes: []int{1, 1}, want: []string{"r2", "r3"}},
+		{name: "no matches", options: DBOptions{Email: "nobody"}, batchSize: 2, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			it, err := QueryResultsIter(context.Background(), &tt.options, tt.batchSize)
+			if err != nil {
+				t.Fatalf("QueryResultsIter: %v", err)
+			}
+			sizes, results := drain(t, it)
+			if !slices.Equal(sizes, tt.wantSizes) {
+				t.Errorf("batch sizes = %v, want %v", sizes, tt.wantSizes)
+			}
+			if got := resultIDs(results); !slices.Equal(got, tt.want) {
+				t.Errorf("results = %q, want %q", got, tt.want)
+			}
+
+			// The rows match QueryResults, and the connection is free again once closed
+			if want := queryIDs(t, &tt.options); !slices.Equal(resultIDs(results), want) {
+				t.Errorf("iterated %q, QueryResults returned %q", resultIDs(results), want)
+			}
+		})
+	}
+}
+
+func TestQueryResultsIterInvalidOptions(t *testing.T) {
+	seedResults(t)
+	_, err := QueryResultsIter(context.Background(), &DBOptions{Username: "adm(", Match: MatchRegex}, 10)
+	if err == nil || !strings.Contains(err.Error(), "invalid regex") {
+		t.Fatalf("QueryResultsIter error = %v, want an invalid regex", err)
+	}
+}
+
+func TestQueryResultsIterCancelled(t *testing.T) {
+	seedResults(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	it, err := QueryResultsIter(ctx, &DBOptions{}, 1)
+	if err != nil {
+		t.Fatalf("QueryResultsIter: %v", err)
+	}
+	defer it.Close()
+	if !it.Next() {
+		t.Fatalf("first batch: %v", it.Err())
+	}
+	cancel()
+	for it.Next() {
+	}
+	if it.Err() == nil {
+		t.Error("iteration finished after the context was cancelled")
+	}
+}
+
+func TestQueryCredsIter(t *testing.T) {
+	seedResults(t)
+	ctx := context.Background()
+
+	var creds []Creds
+	for _, fields := range testResults {
+		creds = append(creds, Result{ResultFields: fields}.Credentials()...)
+	}
+	// A credential stored without its result
+	creds = append(creds, Creds{Email: "orphan@corp.com", Password: "x"})
+	if err := StoreCreds(ctx, creds); err != nil {
+		t.Fatalf("StoreCreds: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		options   DBOptions
+		batchSize int
+		wantSizes []int
+		want      []string
+	}{
+		{name: "no filters include orphans", batchSize: 2, wantSizes: []int{2, 2, 1}, want: []string{"Admin@Corp.com:hunter2", "admin:hunter2", "carol@example.org:5f4dcc3b", "carol:5f4dcc3b", "orphan@corp.com:x"}},
+		{name: "filters match the results", options: DBOptions{Email: "corp"}, batchSize: 2, wantSizes: []int{2}, want: []string{"Admin@Corp.com:hunter2", "admin:hunter2"}},
+		{name: "page", options: DBOptions{Limit: 2, Offset: 1}, batchSize: 5, wantSizes: []int{2}, want: []string{"admin:hunter2", "carol@example.org:5f4dcc3b"}},
+		{name: "filters without credentials", options: DBOptions{Username: "bob"}, batchSize: 2, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			it, err := QueryCredsIter(ctx, &tt.options, tt.batchSize)
+			if err != nil {
+				t.Fatalf("QueryCredsIter: %v", err)
+			}
+			sizes, rows := drain(t, it)
+			var got []string
+			for _, c := range rows {
+				got = append(got, c.Identity()+":"+c.Secret())
+			}
+			if !slices.Equal(sizes, tt.wantSizes) {
+				t.Errorf("batch sizes = %v, want %v", sizes, tt.wantSizes)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("creds = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := QueryCredsIter(ctx, &DBOptions{Username: "adm(", Match: MatchRegex}, 10); err == nil {
+		t.Error("QueryCredsIter accepted an invalid regex")
+	}
+}
@@ -0,0 +1,67 @@
+package sqlite
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// credsKeyIndex is the unique index over the natural key of credentials
+const credsKeyIndex = "idx_creds_natural_key"
+
+// dedupeCreds merges the duplicate credentials older databases collected before the
+// natural key was unique, keeping the first row of each pair with the occurrences of all
+// of them and the latest time any was seen. It runs once, before the index exists.
+func dedupeCreds(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&Creds{}) || migrator.HasIndex(&Creds{}, credsKeyIndex) {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		// Columns added since the table was created are needed to group rows by key
+		migrator := tx.Migrator()
+		for _, field := range []string{"HashedPassword", "HashType", "DatabaseName", "DehashedId", "Occurrences", "LastSeen"} {
+			if migrator.HasColumn(&Creds{}, field) {
+				continue
+			}
+			if err := migrator.AddColumn(&Creds{}, field); err != nil {
+				return fmt.Errorf("failed to add column %s: %w", field, err)
+			}
+		}
+
+		// NULLs never conflict in a unique index, so the key columns must hold empty strings
+		for _, column := range []string{"email", "username", "password", "hashed_password", "dehashed_id"} {
+			if err := tx.Exec(fmt.Sprintf("UPDATE creds SET %[1]s = '' WHERE %[1]s IS NULL", column)).Error; err != nil {
+				return err
+			}
+		}
+		err := tx.Exec(`UPDATE creds SET
+			occurrences = CASE WHEN occurrences IS NULL OR occurrences < 1 THEN 1 ELSE occurrences END,
+			last_seen = COALESCE(last_seen, updated_at, created_at)`).Error
+		if err != nil {
+			return err
+		}
+
+		const sameKey = `d.email = creds.email AND d.username = creds.username AND d.password = creds.password
+			AND d.hashed_password = creds.hashed_password AND d.dehashed_id = creds.dehashed_id`
+		err = tx.Exec(`UPDATE creds SET
+			occurrences = (SELECT SUM(d.occurrences) FROM creds d WHERE ` + sameKey + `),
+			last_seen = (SELECT MAX(d.last_seen) FROM creds d WHERE ` + sameKey + `)
+			WHERE id IN (SELECT MIN(id) FROM creds GROUP BY email, username, password, hashed_password, dehashed_id HAVING COUNT(*) > 1)`).Error
+		if err != nil {
+			return err
+		}
+
+		result := tx.Exec(`DELETE FROM creds WHERE id NOT IN
+			(SELECT MIN(id) FROM creds GROUP BY email, username, password, hashed_password, dehashed_id)`)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			zap.L().Info("creds_deduplicated", zap.Int64("removed", result.RowsAffected))
+			fmt.Printf("[*] Merged %d duplicate credentials in the local database\n", result.RowsAffected)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,36 @@
+package sqlite
+
+import (
+	"database/sql"
+	"github.com/mattn/go-sqlite3"
+	"regexp"
+	"sync"
+)
+
+// driverName is the SQLite driver with the functions dehasher adds registered on every connection
+const driverName = "sqlite3_dehasher"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("regexp", regexpMatch, true)
+		},
+	})
+}
+
+// patterns caches compiled regular expressions, as REGEXP is called for every row
+var patterns sync.Map
+
+// regexpMatch implements X REGEXP Y, which SQLite calls as regexp(Y, X): it reports whether
+// the Go regular expression pattern matches any part of value
+func regexpMatch(pattern, value string) (bool, error) {
+	re, ok := patterns.Load(pattern)
+	if !ok {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return false, err
+		}
+		re, _ = patterns.LoadOrStore(pattern, compiled)
+	}
+	return re.(*regexp.Regexp).MatchString(value), nil
+}
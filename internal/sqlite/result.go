@@ -1,12 +1,20 @@
 package sqlite
 
 import (
+	"Dehash/pkg/dehashed"
 	"encoding/json"
 	"fmt"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 	"io"
-	"os"
+	"strings"
+)
+
+// Sources a result can be served from
+const (
+	SourceAPI   = "api"   // Requested from the Dehashed API
+	SourceCache = "cache" // A cached response of an earlier request
+	SourceLocal = "local" // Results stored by earlier runs
 )
 
 type DehashedResponse struct {
@@ -17,8 +25,9 @@ type DehashedResponse struct {
 	TotalResults int      `json:"total"`
 }
 
-type Result struct {
-	gorm.Model
+// ResultFields are the fields of a dehashed.Result as database columns. Both types have
+// the same fields, so a conversion keeps them in step.
+type ResultFields struct {
 	DehashedId            string   `json:"id" xml:"id" yaml:"id" gorm:"uniqueIndex"`
 	Email                 []string `json:"email,omitempty" xml:"email,omitempty" yaml:"email,omitempty" gorm:"serializer:json"`
 	IpAddress             []string `json:"ip_address,omitempty" xml:"ip_address,omitempty" yaml:"ip_address,omitempty" gorm:"serializer:json"`
@@ -38,53 +47,111 @@ type Result struct {
 	DatabaseName          string   `json:"database_name,omitempty" xml:"database_name,omitempty" yaml:"database_name,omitempty"`
 }
 
-type DehashedResults struct {
-	Results []Result `json:"results"`
+// Result is a search result as stored in the database
+type Result struct {
+	gorm.Model
+	ResultFields `gorm:"embedded" yaml:",inline"`
+	Source       string `json:"source,omitempty" xml:"source,omitempty" yaml:"source,omitempty" gorm:"-"`
 }
 
-func (dr *DehashedResults) ExtractCredentials() []Creds {
-	var creds []Creds
+// NewResult returns a search result returned by the API from source as it is stored
+func NewResult(entry dehashed.Result, source string) Result {
+	return Result{ResultFields: ResultFields(entry), Source: source}
+}
 
-	results := dr.Results
+// APIResult returns the stored result as returned by the API
+func (r Result) APIResult() dehashed.Result {
+	return dehashed.Result(r.ResultFields)
+}
 
-	for _, r := range results {
-		if len(r.Password) > 0 {
-			// Get first email if available
-			email := ""
-			if len(r.Email) > 0 {
-				email = r.Email[0]
-			}
+// Credentials returns every credential pair found in a result, pairing each email and
+// username with each password and password hash. A secret without any identity is kept
+// with an empty identity; a result without secrets has none. The pairs carry the hash type,
+// database and id of the result, and its timestamps.
+func (r Result) Credentials() []Creds {
+	var secrets []Creds
+	for _, password := range distinct(r.Password) {
+		secrets = append(secrets, Creds{Password: password})
+	}
+	for _, hash := range distinct(r.HashedPassword) {
+		secrets = append(secrets, Creds{HashedPassword: hash, HashType: r.HashType})
+	}
+	if len(secrets) == 0 {
+		return nil
+	}
 
-			// Get first password
-			password := r.Password[0]
+	var identities []Creds
+	for _, email := range distinct(r.Email) {
+		identities = append(identities, Creds{Email: email})
+	}
+	for _, username := range distinct(r.Username) {
+		identities = append(identities, Creds{Username: username})
+	}
+	if len(identities) == 0 {
+		identities = []Creds{{}}
+	}
 
-			cred := Creds{Email: email, Password: password}
+	creds := make([]Creds, 0, len(identities)*len(secrets))
+	for _, identity := range identities {
+		for _, secret := range secrets {
+			cred := Creds{
+				Email:          identity.Email,
+				Username:       identity.Username,
+				Password:       secret.Password,
+				HashedPassword: secret.HashedPassword,
+				HashType:       secret.HashType,
+				DatabaseName:   r.DatabaseName,
+				DehashedId:     r.DehashedId,
+				Occurrences:    1,
+				LastSeen:       r.UpdatedAt,
+			}
+			cred.CreatedAt, cred.UpdatedAt = r.CreatedAt, r.UpdatedAt
 			creds = append(creds, cred)
 		}
 	}
+	return creds
+}
 
-	go func() {
-		err := StoreCreds(creds)
-		if err != nil {
-			zap.L().Error("store_creds",
-				zap.String("message", "failed to store creds"),
-				zap.Error(err),
-			)
-			fmt.Printf("Error Storing Results: %v", err)
+// distinct returns the non-empty values in order, without repeats
+func distinct(values []string) []string {
+	var out []string
+	seen := make(map[string]bool, len(values))
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" || seen[v] {
+			continue
 		}
-	}()
+		seen[v] = true
+		out = append(out, v)
+	}
+	return out
+}
 
+type DehashedResults struct {
+	Results []Result `json:"results"`
+}
+
+// ExtractCredentials returns the credential pairs of every result, see Result.Credentials
+func (dr *DehashedResults) ExtractCredentials() []Creds {
+	var creds []Creds
+	for _, r := range dr.Results {
+		creds = append(creds, r.Credentials()...)
+	}
 	return creds
 }
 
-func NewDehashedResults(body io.Reader) ([]Result, int, int) {
+// NewDehashedResults decodes a search response body into its entries, balance and total
+func NewDehashedResults(body io.Reader) ([]Result, int, int, error) {
 	var response DehashedResponse
 
 	err := json.NewDecoder(body).Decode(&response)
 	if err != nil {
-		fmt.Printf("Error Parsing Response Body: %v", err)
-		os.Exit(-1)
+		zap.L().Error("parse_response",
+			zap.String("message", "failed to parse response body"),
+			zap.Error(err),
+		)
+		return nil, 0, 0, fmt.Errorf("failed to parse response body: %w", err)
 	}
 
-	return response.Entries, response.Balance, response.TotalResults
+	return response.Entries, response.Balance, response.TotalResults, nil
 }
@@ -0,0 +1,182 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+type RunStatus string
+
+const (
+	RunRunning  RunStatus = "running"
+	RunComplete RunStatus = "complete"
+	RunPartial  RunStatus = "partial"
+	RunFailed   RunStatus = "failed"
+)
+
+// ErrRunNotFound is returned when a query run does not exist
+var ErrRunNotFound = errors.New("query run not found")
+
+// QueryRun records a single dehasher query invocation and its pagination progress
+type QueryRun struct {
+	gorm.Model
+	QueryOptionsID uint         `json:"query_options_id"`
+	QueryOptions   QueryOptions `json:"query_options"`
+	Query          string       `json:"query"`
+	Status         RunStatus    `json:"status" gorm:"index"`
+	PagesCompleted int          `json:"pages_completed"`
+	LastPage       int          `json:"last_page"`
+	TotalResults   int          `json:"total_results"`
+	Retrieved      int          `json:"retrieved"`
+	Balance        int          `json:"balance"`
+	Error          string       `json:"error,omitempty"`
+}
+
+// Resumable reports whether the run stopped before all of its pages were retrieved
+func (qr *QueryRun) Resumable() bool {
+	return qr.Status != RunComplete
+}
+
+// CreateRun stores a new run along with the options it was started with
+func CreateRun(ctx context.Context, options *QueryOptions, query string) (*QueryRun, error) {
+	db, err := GetDB()
+	if err != nil {
+		return nil, err
+	}
+
+	run := &QueryRun{
+		QueryOptions: *options,
+		Query:        query,
+		Status:       RunRunning,
+	}
+	if err := db.WithContext(ctx).Create(run).Error; err != nil {
+		zap.L().Error("create_run",
+			zap.String("message", "failed to create query run"),
+			zap.Error(err),
+		)
+		return nil, fmt.Errorf("failed to create query run: %w", err)
+	}
+
+	return run, nil
+}
+
+// UpdateRun saves the progress of a run
+func UpdateRun(ctx context.Context, run *QueryRun) error {
+	db, err := GetDB()
+	if err != nil {
+		return err
+	}
+
+	if err := db.WithContext(ctx).Omit("QueryOptions").Save(run).Error; err != nil {
+		zap.L().Error("update_run",
+			zap.String("message", "failed to update query run"),
+			zap.Uint("run_id", run.ID),
+			zap.Error(err),
+		)
+		return fmt.Errorf("failed to update query run: %w", err)
+	}
+
+	return nil
+}
+
+// GetRun returns the run with the given id along with its options
+func GetRun(ctx context.Context, id uint) (*QueryRun, error) {
+	db, err := GetDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var run QueryRun
+	err = db.WithContext(ctx).Preload("QueryOptions").First(&run, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %d", ErrRunNotFound, id)
+	}
+	if err != nil {
+		zap.L().Error("get_run",
+			zap.String("message", "failed to get query run"),
+			zap.Uint("run_id", id),
+			zap.Error(err),
+		)
+		return nil, fmt.Errorf("failed to get query run: %w", err)
+	}
+
+	return &run, nil
+}
+
+// RunResult links a stored result to a query run that returned it
+type RunResult struct {
+	QueryRunID uint      `json:"run_id" gorm:"primaryKey"`
+	DehashedId string    `json:"dehashed_id" gorm:"primaryKey;index"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen" gorm:"index"`
+}
+
+// RunSummary is a query run along with the number of results linked to it
+type RunSummary struct {
+	QueryRun `gorm:"embedded"`
+	Hits     int64 `json:"hits"`
+}
+
+// LinkRunResults records that the given results were returned by a run, refreshing
+// the last seen time of results the run had already returned
+func LinkRunResults(ctx context.Context, runID uint, results DehashedResults) error {
+	if len(results.Results) == 0 {
+		return nil
+	}
+
+	db, err := GetDB()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	links := make([]RunResult, 0, len(results.Results))
+	for _, r := range results.Results {
+		links = append(links, RunResult{QueryRunID: runID, DehashedId: r.DehashedId, FirstSeen: now, LastSeen: now})
+	}
+
+	err = db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "query_run_id"}, {Name: "dehashed_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_seen"}),
+	}).CreateInBatches(&links, 100).Error
+	if err != nil {
+		zap.L().Error("link_run_results",
+			zap.String("message", "failed to link results to run"),
+			zap.Uint("run_id", runID),
+			zap.Error(err),
+		)
+		return fmt.Errorf("failed to link results to run: %w", err)
+	}
+
+	return nil
+}
+
+// ListRuns returns the most recent runs with their hit counts, newest first
+func ListRuns(ctx context.Context, limit int) ([]RunSummary, error) {
+	db, err := GetDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []RunSummary
+	query := db.WithContext(ctx).Model(&QueryRun{}).
+		Select("query_runs.*, (SELECT COUNT(*) FROM run_results WHERE run_results.query_run_id = query_runs.id) AS hits").
+		Order("query_runs.id DESC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.Find(&runs).Error; err != nil {
+		zap.L().Error("list_runs",
+			zap.String("message", "failed to list query runs"),
+			zap.Error(err),
+		)
+		return nil, fmt.Errorf("failed to list query runs: %w", err)
+	}
+
+	return runs, nil
+}
@@ -0,0 +1,54 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLinkRunResultsIsIdempotent(t *testing.T) {
+	initTestDB(t)
+	ctx := context.Background()
+	first, err := CreateRun(ctx, &QueryOptions{MaxRecords: 100}, "email:a")
+	if err != nil {
+		t.Fatalf("CreateRun: %v", err)
+	}
+	second, err := CreateRun(ctx, &QueryOptions{MaxRecords: 100}, "email:b")
+	if err != nil {
+		t.Fatalf("CreateRun: %v", err)
+	}
+
+	results := func(ids ...string) DehashedResults {
+		var r DehashedResults
+		for _, id := range ids {
+			r.Results = append(r.Results, Result{ResultFields: ResultFields{DehashedId: id}})
+		}
+		return r
+	}
+	for _, link := range []struct {
+		run uint
+		ids []string
+	}{
+		{first.ID, []string{"a", "b"}},
+		{first.ID, []string{"b", "c"}}, // A resumed run returning b again
+		{second.ID, []string{"a"}},
+	} {
+		if err := LinkRunResults(ctx, link.run, results(link.ids...)); err != nil {
+			t.Fatalf("LinkRunResults: %v", err)
+		}
+	}
+
+	runs, err := ListRuns(ctx, 0)
+	if err != nil {
+		t.Fatalf("ListRuns: %v", err)
+	}
+	hits := make(map[uint]int64)
+	for _, run := range runs {
+		hits[run.ID] = run.Hits
+	}
+	if hits[first.ID] != 3 || hits[second.ID] != 1 {
+		t.Errorf("hits = %v, want 3 for run %d and 1 for run %d", hits, first.ID, second.ID)
+	}
+	if runs[0].ID != second.ID {
+		t.Errorf("ListRuns starts with run %d, want the newest run %d", runs[0].ID, second.ID)
+	}
+}
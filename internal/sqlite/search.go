@@ -0,0 +1,147 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// ErrSearchUnavailable is returned by full-text searches when SQLite was built without FTS5
+var ErrSearchUnavailable = errors.New("full-text search requires a build with FTS5 (go build -tags sqlite_fts5)")
+
+// searchColumns are the result columns indexed for full-text search, all but database_name
+// hold JSON arrays whose values are indexed as text
+var searchColumns = []string{
+	"email", "username", "name", "password", "hashed_password", "ip_address", "url", "address",
+	"phone", "social", "company", "cryptocurrency_address", "vin", "license_plate", "database_name",
+}
+
+// searchEnabled reports whether the full-text index exists and is kept in sync
+var searchEnabled bool
+
+// SearchMatch is a result matching a full-text search
+type SearchMatch struct {
+	Result  `gorm:"embedded"`
+	Rank    float64 `json:"rank"`    // bm25 rank, lower is a better match
+	Snippet string  `json:"snippet"` // Matching text with the matched terms in [brackets]
+}
+
+// migrateSearch creates the full-text index of results when SQLite supports FTS5, and
+// indexes stored results that are missing from it
+func migrateSearch(db *gorm.DB) error {
+	var fts5 bool
+	if err := db.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5).Error; err != nil {
+		return err
+	}
+	if !fts5 {
+		zap.L().Warn("full-text search unavailable, SQLite was built without FTS5")
+		return nil
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		create := fmt.Sprintf("CREATE VIRTUAL TABLE IF NOT EXISTS results_fts USING fts5(%s, tokenize = 'unicode61 remove_diacritics 2')",
+			strings.Join(searchColumns, ", "))
+		if err := tx.Exec(create).Error; err != nil {
+			return err
+		}
+
+		result := tx.Exec(indexSearchSQL("results.id NOT IN (SELECT rowid FROM results_fts)"))
+		if result.Error != nil {
+			return fmt.Errorf("failed to index stored results for search: %w", result.Error)
+		}
+		if result.RowsAffected > 0 {
+			zap.L().Info("results_search_indexed", zap.Int64("count", result.RowsAffected))
+			fmt.Printf("[*] Indexed %d stored results for full-text search\n", result.RowsAffected)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	searchEnabled = true
+	return nil
+}
+
+// indexSearchSQL returns the statement adding the results matching where to the full-text index
+func indexSearchSQL(where string) string {
+	values := make([]string, len(searchColumns))
+	for i, column := range searchColumns {
+		switch column {
+		case "database_name":
+			values[i] = "results.database_name"
+		case "cryptocurrency_address":
+			values[i] = "(SELECT group_concat(value, ' ') FROM json_each(results.crypto_currency_address))"
+		default:
+			values[i] = fmt.Sprintf("(SELECT group_concat(value, ' ') FROM json_each(results.%s))", column)
+		}
+	}
+	return fmt.Sprintf("INSERT INTO results_fts (rowid, %s) SELECT results.id, %s FROM results WHERE %s",
+		strings.Join(searchColumns, ", "), strings.Join(values, ", "), where)
+}
+
+// storeSearch adds the results with the given dehashed ids to the full-text index, results
+// that are already indexed are left as they are
+func storeSearch(tx *gorm.DB, dehashedIds []string) error {
+	if !searchEnabled {
+		return nil
+	}
+	return tx.Exec(indexSearchSQL("results.dehashed_id IN ? AND results.id NOT IN (SELECT rowid FROM results_fts)"), dehashedIds).Error
+}
+
+// SearchResults returns the stored results matching a full-text search, best matches first,
+// along with the number of matches. Terms use the FTS5 query syntax, e.g. smith AND
+// email:corp, "john smith" or admin*.
+func SearchResults(ctx context.Context, terms string, limit int) ([]SearchMatch, int64, error) {
+	db, err := GetDB()
+	if err != nil {
+		return nil, 0, err
+	}
+	if !searchEnabled {
+		return nil, 0, ErrSearchUnavailable
+	}
+	db = db.WithContext(ctx)
+
+	var count int64
+	err = db.Raw("SELECT COUNT(*) FROM results_fts JOIN results ON results.id = results_fts.rowid WHERE results_fts MATCH ? AND results.deleted_at IS NULL", terms).
+		Scan(&count).Error
+	if err != nil {
+		return nil, 0, searchError(err)
+	}
+
+	var matches []SearchMatch
+	query := db.Table("results_fts").
+		Select("results.*, results_fts.rank AS rank, snippet(results_fts, -1, '[', ']', '...', 12) AS snippet").
+		Joins("JOIN results ON results.id = results_fts.rowid").
+		Where("results_fts MATCH ? AND results.deleted_at IS NULL", terms).
+		Order("rank")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.Scan(&matches).Error; err != nil {
+		return nil, 0, searchError(err)
+	}
+
+	return matches, count, nil
+}
+
+// searchTermErrors are parts of the errors SQLite reports for invalid search terms, not all
+// of which mention FTS5
+var searchTermErrors = []string{"fts5", "unterminated string", "no such column"}
+
+// searchError reports an error of a full-text search, most are invalid search terms
+func searchError(err error) error {
+	zap.L().Error("search_results",
+		zap.String("message", "failed to search results"),
+		zap.Error(err),
+	)
+	for _, termError := range searchTermErrors {
+		if strings.Contains(err.Error(), termError) {
+			return fmt.Errorf("invalid search terms: %w", err)
+		}
+	}
+	return fmt.Errorf("failed to search results: %w", err)
+}
@@ -0,0 +1,127 @@
+//go:build sqlite_fts5
+
+package sqlite
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSearchResults(t *testing.T) {
+	seedResults(t)
+	if !searchEnabled {
+		t.Fatal("full-text search is not enabled in a build with FTS5")
+	}
+
+	tests := []struct {
+		name      string
+		terms     string
+		limit     int
+		want      []string // In any order, ranks are compared separately
+		wantCount int64
+	}{
+		{name: "term", terms: "carol", want: []string{"r3"}, wantCount: 1},
+		{name: "case insensitive", terms: "ADMIN", want: []string{"r1"}, wantCount: 1},
+		{name: "email domain", terms: `"corp.com"`, want: []string{"r1", "r2"}, wantCount: 2},
+		{name: "column filter", terms: "database_name:alpha", want: []string{"r1", "r3"}, wantCount: 2},
+		{name: "prefix", terms: "bo*", want: []string{"r2"}, wantCount: 1},
+		{name: "phrase", terms: `"bob smith"`, want: []string{"r2"}, wantCount: 1},
+		{name: "and", terms: "corp AND dave", want: []string{"r4"}, wantCount: 1},
+		{name: "limit keeps the count", terms: "corp", limit: 1, want: nil, wantCount: 3},
+		{name: "no matches", terms: "mallory", want: nil, wantCount: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, count, err := SearchResults(context.Background(), tt.terms, tt.limit)
+			if err != nil {
+				t.Fatalf("SearchResults(%q): %v", tt.terms, err)
+			}
+			if count != tt.wantCount {
+				t.Errorf("count = %d, want %d", count, tt.wantCount)
+			}
+			if tt.limit > 0 {
+				if len(matches) != tt.limit {
+					t.Errorf("%d matches, want the limit of %d", len(matches), tt.limit)
+				}
+				return
+			}
+			var ids []string
+			for _, m := range matches {
+				ids = append(ids, m.DehashedId)
+			}
+			slices.Sort(ids)
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("matched %q, want %q", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchResultsRankAndSnippet(t *testing.T) {
+	seedResults(t)
+
+	matches, _, err := SearchResults(context.Background(), "bob", 0)
+	if err != nil {
+		t.Fatalf("SearchResults: %v", err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("%d matches, want 1", len(matches))
+	}
+	if !strings.Contains(matches[0].Snippet, "[Bob]") && !strings.Contains(matches[0].Snippet, "[bob]") {
+		t.Errorf("snippet %q does not mark the match", matches[0].Snippet)
+	}
+	if matches[0].Rank >= 0 {
+		t.Errorf("rank = %f, want a negative bm25 rank", matches[0].Rank)
+	}
+
+	matches, _, err = SearchResults(context.Background(), "corp", 0)
+	if err != nil {
+		t.Fatalf("SearchResults: %v", err)
+	}
+	for i := 1; i < len(matches); i++ {
+		if matches[i].Rank < matches[i-1].Rank {
+			t.Errorf("match %d ranks %f, better than %f before it", i, matches[i].Rank, matches[i-1].Rank)
+		}
+	}
+}
+
+func TestSearchResultsInvalidTerms(t *testing.T) {
+	seedResults(t)
+
+	for _, terms := range []string{`"unterminated`, "admin AND", "nickname:admin", "(admin"} {
+		_, _, err := SearchResults(context.Background(), terms, 0)
+		if err == nil || !strings.Contains(err.Error(), "invalid search terms") {
+			t.Errorf("SearchResults(%q) error = %v, want invalid search terms", terms, err)
+		}
+	}
+}
+
+func TestSearchIndexesStoredResults(t *testing.T) {
+	dir := t.TempDir()
+	closeDB := openTestDB(t, dir)
+	ctx := context.Background()
+	results := DehashedResults{Results: []Result{{ResultFields: ResultFields{DehashedId: "a", Username: []string{"zelda"}}}}}
+	if err := StoreResults(ctx, results); err != nil {
+		t.Fatalf("StoreResults: %v", err)
+	}
+	// Storing a result again does not index it twice
+	if err := StoreResults(ctx, results); err != nil {
+		t.Fatalf("StoreResults: %v", err)
+	}
+	if _, count, err := SearchResults(ctx, "zelda", 0); err != nil || count != 1 {
+		t.Fatalf("SearchResults = %d matches, %v; want 1", count, err)
+	}
+
+	// Results stored before the index existed are indexed when the database is opened
+	if err := DB.Exec("DROP TABLE results_fts").Error; err != nil {
+		t.Fatal(err)
+	}
+	closeDB()
+	openTestDB(t, dir)
+	if _, count, err := SearchResults(ctx, "zelda", 0); err != nil || count != 1 {
+		t.Errorf("after reopening SearchResults = %d matches, %v; want 1", count, err)
+	}
+}
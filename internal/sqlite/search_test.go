@@ -0,0 +1,26 @@
+//go:build !sqlite_fts5
+
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestSearchResultsWithoutFTS5(t *testing.T) {
+	seedResults(t)
+	if searchEnabled {
+		t.Fatal("full-text search is enabled in a build without FTS5")
+	}
+
+	_, _, err := SearchResults(context.Background(), "admin", 0)
+	if !errors.Is(err, ErrSearchUnavailable) {
+		t.Fatalf("SearchResults error = %v, want ErrSearchUnavailable", err)
+	}
+
+	// Everything else works without the index
+	if got := queryIDs(t, &DBOptions{Username: "admin"}); len(got) != 1 || got[0] != "r1" {
+		t.Errorf("QueryResults = %q, want r1", got)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"Dehash/internal/files"
 	"fmt"
 	"gorm.io/gorm"
+	"time"
 )
 
 type DBOptions struct {
@@ -21,9 +22,17 @@ type DBOptions struct {
 	CryptoCurrencyAddress string
 	Domain                string
 	Limit                 int
-	ExactMatch            bool
-	NonEmptyFields        []string // Fields that should not be empty
-	DisplayFields         []string // Fields to display in output
+	Offset                int                  // Results to skip, after sorting
+	Sort                  []SortOrder          // Fields to sort by, in order; results are in stored order otherwise
+	ExactMatch            bool                 // Shorthand for Match: MatchExact
+	Match                 MatchMode            // How field filters match values, MatchContains by default
+	FieldMatch            map[string]MatchMode // How the filters of single fields match, overriding Match
+	NonEmptyFields        []string             // Fields that should not be empty
+	DisplayFields         []string             // Fields to display in output
+	RunIDs                []uint               // Only results returned by one of these query runs
+	Since                 time.Time            // Only results seen by a query run at or after this time
+	CreatedAfter          time.Time            // Only results stored at or after this time
+	CreatedBefore         time.Time            // Only results stored before this time
 }
 
 func NewDBOptions() *DBOptions {
@@ -59,6 +68,8 @@ type QueryOptions struct {
 	CryptoAddressQuery string         `json:"crypto_address_query"`
 	PrintBalance       bool           `json:"print_balance"`
 	CredsOnly          bool           `json:"creds_only"`
+	Budget             int            `json:"budget"`
+	Expression         string         `json:"expression"`
 }
 
 func NewQueryOptions(maxRecords, maxRequests, startingPage int, outputFormat, outputFile, usernameQuery, emailQuery, ipQuery, passQuery, hashQuery, nameQuery, domainQuery, vinQuery, licensePlateQuery, addressQuery, phoneQuery, socialQuery, cryptoAddressQuery string, regexMatch, wildcardMatch, printBalance, credsOnly bool) *QueryOptions {
@@ -88,13 +99,38 @@ func NewQueryOptions(maxRecords, maxRequests, startingPage int, outputFormat, ou
 	}
 }
 
+// Creds is a credential pair found in a result: one identity, an email or a username,
+// and one secret, a password or a password hash. The identity, secret and source result
+// form its natural key, so storing a pair again only updates when it was last seen.
 type Creds struct {
 	gorm.Model
-	Email    string `json:"email" yaml:"email" xml:"email"`
-	Username string `json:"username" yaml:"username" xml:"username"`
-	Password string `json:"password" yaml:"password" xml:"password"`
+	Email          string    `json:"email" yaml:"email" xml:"email" gorm:"uniqueIndex:idx_creds_natural_key"`
+	Username       string    `json:"username" yaml:"username" xml:"username" gorm:"uniqueIndex:idx_creds_natural_key"`
+	Password       string    `json:"password" yaml:"password" xml:"password" gorm:"uniqueIndex:idx_creds_natural_key"`
+	HashedPassword string    `json:"hashed_password,omitempty" yaml:"hashed_password,omitempty" xml:"hashed_password,omitempty" gorm:"uniqueIndex:idx_creds_natural_key"`
+	HashType       string    `json:"hash_type,omitempty" yaml:"hash_type,omitempty" xml:"hash_type,omitempty"`
+	DatabaseName   string    `json:"database_name,omitempty" yaml:"database_name,omitempty" xml:"database_name,omitempty"`
+	DehashedId     string    `json:"dehashed_id,omitempty" yaml:"dehashed_id,omitempty" xml:"dehashed_id,omitempty" gorm:"uniqueIndex:idx_creds_natural_key;index"`
+	Occurrences    int       `json:"occurrences,omitempty" yaml:"occurrences,omitempty" xml:"occurrences,omitempty" gorm:"default:1"`
+	LastSeen       time.Time `json:"last_seen" yaml:"last_seen" xml:"last_seen"`
+}
+
+// Identity returns the email or username of the pair
+func (c Creds) Identity() string {
+	if c.Email != "" {
+		return c.Email
+	}
+	return c.Username
+}
+
+// Secret returns the password or password hash of the pair
+func (c Creds) Secret() string {
+	if c.Password != "" {
+		return c.Password
+	}
+	return c.HashedPassword
 }
 
 func (c Creds) ToString() string {
-	return fmt.Sprintf("%s%s%s", c.Username, "%", c.Password)
+	return fmt.Sprintf("%s%s%s", c.Identity(), "%", c.Secret())
 }
@@ -0,0 +1,213 @@
+package dehashed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultBaseURL is the address of the Dehashed API
+const DefaultBaseURL = "https://api.dehashed.com"
+
+// Client sends requests to the Dehashed API. It is safe for concurrent use.
+type Client struct {
+	apiKey  string
+	baseURL string
+	client  *http.Client
+	limiter *RateLimiter
+	logger  *zap.Logger
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithAPIKey sets the API key sent with every request
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithBaseURL sets the API address, e.g. to use a mock server
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// WithHTTPClient sets the HTTP client requests are sent with, http.DefaultClient by default
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		if client == nil {
+			client = http.DefaultClient
+		}
+		c.client = client
+	}
+}
+
+// WithRateLimit limits the client to rate requests per second with bursts of up to burst
+// requests. A non-positive rate removes the limit.
+func WithRateLimit(rate float64, burst int) Option {
+	return WithRateLimiter(NewRateLimiter(rate, burst))
+}
+
+// WithRateLimiter sets a limiter every request waits on, which may be shared between clients
+func WithRateLimiter(limiter *RateLimiter) Option {
+	return func(c *Client) {
+		c.limiter = limiter
+	}
+}
+
+// WithLogger sets the logger failed requests are reported to, nothing is logged by default
+func WithLogger(logger *zap.Logger) Option {
+	return func(c *Client) {
+		if logger == nil {
+			logger = zap.NewNop()
+		}
+		c.logger = logger
+	}
+}
+
+// New creates a client for the Dehashed API
+func New(opts ...Option) *Client {
+	c := &Client{baseURL: DefaultBaseURL, client: http.DefaultClient, logger: zap.NewNop()}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// With returns a copy of the client with the options applied
+func (c *Client) With(opts ...Option) *Client {
+	clone := *c
+	for _, opt := range opts {
+		opt(&clone)
+	}
+	return &clone
+}
+
+// BaseURL returns the API address the client sends requests to
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// Search performs a single search request. Failures are returned as *Error; requests
+// are not retried.
+func (c *Client) Search(ctx context.Context, req *SearchRequest) (*SearchResponse, error) {
+	response, _, err := c.SearchRaw(ctx, req)
+	return response, err
+}
+
+// SearchRaw performs a single search request like Search, also returning the raw response body
+func (c *Client) SearchRaw(ctx context.Context, req *SearchRequest) (*SearchResponse, []byte, error) {
+	body, err := c.do(ctx, "v2_search", http.MethodPost, "/v2/search", req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var response SearchResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		c.logger.Error("v2_search",
+			zap.String("message", "failed to unmarshal response body"),
+			zap.Error(err),
+		)
+		return nil, nil, &Error{Message: "failed to unmarshal response body", Code: -1, Err: err}
+	}
+	return &response, body, nil
+}
+
+// SearchStream performs a single search request, decoding the response as it is read and
+// passing every entry to fn instead of collecting them, see DecodeSearchResponse
+func (c *Client) SearchStream(ctx context.Context, req *SearchRequest, fn func(Result) error) (*SearchResponse, error) {
+	body, err := c.SearchBody(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return DecodeSearchResponse(body, fn)
+}
+
+// SearchBody performs a single search request and returns the body of the successful
+// response unread, for callers decoding it with DecodeSearchResponse. It must be closed.
+func (c *Client) SearchBody(ctx context.Context, req *SearchRequest) (io.ReadCloser, error) {
+	res, err := c.send(ctx, "v2_search", http.MethodPost, "/v2/search", req)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// do sends a request with an optional JSON payload and returns the body of a successful response
+func (c *Client) do(ctx context.Context, event, method, path string, payload any) ([]byte, error) {
+	res, err := c.send(ctx, event, method, path, payload)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		c.logger.Error(event,
+			zap.String("message", "failed to read response body"),
+			zap.Error(err),
+		)
+		return nil, &Error{Message: "failed to read response body", Code: -1, Retryable: true, Err: err}
+	}
+	return body, nil
+}
+
+// send sends a request with an optional JSON payload and returns the successful response
+// with its body unread; failed responses are read and returned as *Error
+func (c *Client) send(ctx context.Context, event, method, path string, payload any) (*http.Response, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, &Error{Message: "request cancelled", Code: -1, Err: err}
+	}
+
+	var reqBody io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, &Error{Message: "failed to marshal request", Code: -1, Err: err}
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+	if err != nil {
+		return nil, &Error{Message: "failed to construct request", Code: -1, Err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Dehashed-Api-Key", c.apiKey)
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		c.logger.Error(event,
+			zap.String("message", "failed to perform request"),
+			zap.Error(err),
+		)
+		return nil, &Error{Message: "failed to perform request", Code: -1, Retryable: true, Err: err}
+	}
+	if res == nil {
+		c.logger.Error(event,
+			zap.String("message", "response was nil"),
+		)
+		return nil, &Error{Message: "response was nil", Code: -1, Retryable: true}
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		dhErr := NewResponseError(res, body)
+		c.logger.Error(event,
+			zap.String("message", "unexpected status code"),
+			zap.Int("status", res.StatusCode),
+			zap.String("api_message", dhErr.APIMessage),
+			zap.String("request_id", dhErr.RequestID),
+			zap.Bool("retryable", dhErr.Retryable),
+		)
+		return nil, dhErr
+	}
+	return res, nil
+}
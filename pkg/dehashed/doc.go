@@ -0,0 +1,22 @@
+// Package dehashed is a client for the Dehashed v2 API.
+//
+// Create a Client with New and the options it needs, build a SearchRequest and search:
+//
+//	client := dehashed.New(dehashed.WithAPIKey(key), dehashed.WithRateLimit(5, 1))
+//	req := dehashed.NewSearchRequest(1, 100, false, false, false).
+//		Add(dehashed.Email, "@example.com").
+//		Add(dehashed.Username, "admin")
+//	resp, err := client.Search(ctx, req)
+//
+// Search decodes a whole page into memory. SearchStream instead passes every entry to a
+// callback as it is decoded from the response, keeping memory flat for pages of up to
+// MaxPageSize entries:
+//
+//	_, err := client.SearchStream(ctx, req, func(r dehashed.Result) error {
+//		return store(r)
+//	})
+//
+// Failed requests return an *Error, which matches the sentinel errors such as
+// ErrUnauthorized and ErrRateLimited with errors.Is. Requests are sent once; retrying
+// is left to the caller, guided by Error.Retryable and Error.RetryAfter.
+package dehashed
@@ -0,0 +1,157 @@
+package dehashed
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sentinel errors for branching on API failures with errors.Is
+var (
+	ErrUnauthorized        = errors.New("authentication failed")
+	ErrInsufficientCredits = errors.New("insufficient credits")
+	ErrRateLimited         = errors.New("rate limited")
+	ErrInvalidQuery        = errors.New("invalid or missing query")
+	ErrNotPermitted        = errors.New("method not permitted")
+	ErrUnavailable         = errors.New("service unavailable")
+)
+
+// maxAPIMessageLength bounds how much of a non JSON error body is kept
+const maxAPIMessageLength = 256
+
+// Error is returned for failed requests. Use errors.Is with the sentinel errors to branch
+// on the kind of failure, or errors.As to inspect the status and API message.
+type Error struct {
+	Message    string        // Description of the failure
+	Code       int           // HTTP status returned by the API, or -1 when no response was received
+	APIMessage string        // Error message reported by the API in the response body
+	RequestID  string        // Request ID reported by the API, if any
+	Retryable  bool          // Whether the request may succeed if attempted again
+	RetryAfter time.Duration // Delay the API asked for before the request is attempted again
+	Err        error         // Underlying cause
+}
+
+// ErrorResponse is the error body returned by the API
+type ErrorResponse struct {
+	HttpResponse int    `json:"HTTP Response Code"`
+	Error        string `json:"error"`
+	Message      string `json:"message"`
+	RequestID    string `json:"request_id"`
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if e.APIMessage != "" && !strings.EqualFold(e.APIMessage, e.Message) {
+		msg = fmt.Sprintf("%s: %s", msg, e.APIMessage)
+	}
+	if e.RequestID != "" {
+		msg = fmt.Sprintf("%s (request id %s)", msg, e.RequestID)
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %v", msg, e.Err)
+	}
+	return msg
+}
+
+// Unwrap returns the underlying cause of the error, if any
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the error matches one of the package sentinel errors
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.Code == 400 || e.Code == 401
+	case ErrInsufficientCredits:
+		return e.Code == 403
+	case ErrNotPermitted:
+		return e.Code == 404
+	case ErrRateLimited:
+		return e.Code == 429
+	case ErrInvalidQuery:
+		return e.Code == 302
+	case ErrUnavailable:
+		return e.Code >= 500
+	default:
+		return false
+	}
+}
+
+// StatusError returns the error for an HTTP status returned by the API
+func StatusError(c int) Error {
+	switch c {
+	case 400:
+		return Error{Code: 400, Message: "There is an issue with authentication. Please check your API key and email. If you haven't, refresh your API Key "}
+	case 401:
+		return Error{Code: 401, Message: "You need a search subscription and API credits to use the API, please purchase a search subscription and add credits to your account."}
+	case 403:
+		return Error{Code: 403, Message: "Insufficient Credits"}
+	case 404:
+		return Error{Code: 404, Message: "Method not permitted"}
+	case 429:
+		return Error{Code: 429, Message: "Rate Limited", Retryable: true}
+	case 302:
+		return Error{Code: 302, Message: "Invalid/Missing Query"}
+	case 500, 502, 503, 504:
+		return Error{Code: c, Message: "The Dehashed API is temporarily unavailable", Retryable: true}
+	default:
+		return Error{Code: c, Message: fmt.Sprintf("An unknown error has occurred (HTTP %d)", c)}
+	}
+}
+
+// NewResponseError builds an Error from a failed API response and its body
+func NewResponseError(res *http.Response, body []byte) *Error {
+	dhErr := StatusError(res.StatusCode)
+	dhErr.RetryAfter = parseRetryAfter(res.Header.Get("Retry-After"), time.Now())
+
+	var payload ErrorResponse
+	if err := json.Unmarshal(body, &payload); err == nil {
+		dhErr.APIMessage = strings.TrimSpace(payload.Error)
+		if dhErr.APIMessage == "" {
+			dhErr.APIMessage = strings.TrimSpace(payload.Message)
+		}
+		dhErr.RequestID = payload.RequestID
+	} else if text := strings.TrimSpace(string(body)); text != "" && !strings.HasPrefix(text, "<") {
+		// Plain text bodies are kept, HTML error pages from proxies are not
+		if len(text) > maxAPIMessageLength {
+			text = text[:maxAPIMessageLength] + "..."
+		}
+		dhErr.APIMessage = text
+	}
+
+	if dhErr.RequestID == "" {
+		for _, header := range []string{"X-Request-Id", "Request-Id", "Cf-Ray"} {
+			if id := res.Header.Get(header); id != "" {
+				dhErr.RequestID = id
+				break
+			}
+		}
+	}
+
+	return &dhErr
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as an HTTP date
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
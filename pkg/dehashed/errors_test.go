@@ -0,0 +1,159 @@
+package dehashed
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewResponseError(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		header        http.Header
+		body          string
+		wantSentinel  error
+		wantRetryable bool
+		wantMessage   string
+		wantRequestID string
+		wantError     string
+	}{
+		{
+			name:         "json error field",
+			status:       http.StatusForbidden,
+			body:         `{"HTTP Response Code": 403, "error": "Insufficient Credits"}`,
+			wantSentinel: ErrInsufficientCredits,
+			wantMessage:  "Insufficient Credits",
+			wantError:    "Insufficient Credits",
+		},
+		{
+			name:          "json message field and request id",
+			status:        http.StatusUnauthorized,
+			body:          `{"message": " key revoked ", "request_id": "req-42"}`,
+			wantSentinel:  ErrUnauthorized,
+			wantMessage:   "key revoked",
+			wantRequestID: "req-42",
+			wantError:     ": key revoked (request id req-42)",
+		},
+		{
+			name:         "error field wins over message",
+			status:       http.StatusFound,
+			body:         `{"error": "Invalid/Missing Query", "message": "ignored"}`,
+			wantSentinel: ErrInvalidQuery,
+			wantMessage:  "Invalid/Missing Query",
+			wantError:    "Invalid/Missing Query",
+		},
+		{
+			name:          "plain text body",
+			status:        http.StatusBadGateway,
+			body:          "upstream connect error\n",
+			wantSentinel:  ErrUnavailable,
+			wantRetryable: true,
+			wantMessage:   "upstream connect error",
+		},
+		{
+			name:          "html body is dropped",
+			status:        http.StatusServiceUnavailable,
+			body:          "<html><body>503 Service Unavailable</body></html>",
+			wantSentinel:  ErrUnavailable,
+			wantRetryable: true,
+		},
+		{
+			name:          "long text body is truncated",
+			status:        http.StatusInternalServerError,
+			body:          strings.Repeat("x", 1000),
+			wantSentinel:  ErrUnavailable,
+			wantRetryable: true,
+			wantMessage:   strings.Repeat("x", maxAPIMessageLength) + "...",
+		},
+		{
+			name:         "empty body",
+			status:       http.StatusNotFound,
+			wantSentinel: ErrNotPermitted,
+			wantError:    "Method not permitted",
+		},
+		{
+			name:          "request id header",
+			status:        http.StatusTooManyRequests,
+			header:        http.Header{"Cf-Ray": {"8a1b2c"}},
+			body:          `{"error": "Rate Limited"}`,
+			wantSentinel:  ErrRateLimited,
+			wantRetryable: true,
+			wantMessage:   "Rate Limited",
+			wantRequestID: "8a1b2c",
+		},
+		{
+			name:      "unknown status",
+			status:    http.StatusTeapot,
+			body:      `{}`,
+			wantError: "An unknown error has occurred (HTTP 418)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &http.Response{StatusCode: tt.status, Header: tt.header}
+			if res.Header == nil {
+				res.Header = http.Header{}
+			}
+			err := NewResponseError(res, []byte(tt.body))
+
+			if err.Code != tt.status {
+				t.Errorf("Code = %d, want %d", err.Code, tt.status)
+			}
+			if tt.wantSentinel != nil && !errors.Is(err, tt.wantSentinel) {
+				t.Errorf("errors.Is(%v, %v) = false, want true", err, tt.wantSentinel)
+			}
+			if err.Retryable != tt.wantRetryable {
+				t.Errorf("Retryable = %v, want %v", err.Retryable, tt.wantRetryable)
+			}
+			if err.APIMessage != tt.wantMessage {
+				t.Errorf("APIMessage = %q, want %q", err.APIMessage, tt.wantMessage)
+			}
+			if err.RequestID != tt.wantRequestID {
+				t.Errorf("RequestID = %q, want %q", err.RequestID, tt.wantRequestID)
+			}
+			if !strings.Contains(err.Error(), tt.wantError) {
+				t.Errorf("Error() = %q, want it to contain %q", err.Error(), tt.wantError)
+			}
+		})
+	}
+}
+
+func TestNewResponseErrorRetryAfter(t *testing.T) {
+	res := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"7"}}}
+	if got := NewResponseError(res, nil).RetryAfter; got != 7*time.Second {
+		t.Errorf("RetryAfter = %s, want 7s", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+	}{
+		{name: "empty", header: "", want: 0},
+		{name: "seconds", header: "120", want: 2 * time.Minute},
+		{name: "seconds with spaces", header: " 5 ", want: 5 * time.Second},
+		{name: "zero seconds", header: "0", want: 0},
+		{name: "negative seconds", header: "-3", want: 0},
+		{name: "http date", header: now.Add(90 * time.Second).Format(http.TimeFormat), want: 90 * time.Second},
+		{name: "rfc 850 date", header: now.Add(time.Hour).Format(time.RFC850), want: time.Hour},
+		{name: "asctime date", header: now.Add(time.Minute).Format(time.ANSIC), want: time.Minute},
+		{name: "date in the past", header: now.Add(-time.Minute).Format(http.TimeFormat), want: 0},
+		{name: "garbage", header: "soon", want: 0},
+		{name: "fractional seconds", header: "1.5", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.header, now); got != tt.want {
+				t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.header, got, tt.want)
+			}
+		})
+	}
+}
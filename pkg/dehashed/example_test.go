@@ -0,0 +1,168 @@
+package dehashed_test
+
+import (
+	"Dehash/pkg/dehashed"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+const exampleAPIKey = "example-key"
+
+// newMockAPI serves a few canned responses in place of the Dehashed API
+func newMockAPI() *httptest.Server {
+	corp := []dehashed.Result{
+		{DehashedId: "mock-0001", Email: []string{"admin@corp.com"}, Username: []string{"admin"}},
+		{DehashedId: "mock-0002", Email: []string{"root@corp.com"}, Username: []string{"root"}},
+		{DehashedId: "mock-0003", Email: []string{"erin@corp.com"}, Username: []string{"erin"}},
+	}
+	writeJSON := func(w http.ResponseWriter, status int, v any) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v2/search", func(w http.ResponseWriter, r *http.Request) {
+		var req dehashed.SearchRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		entries := corp
+		if strings.Contains(req.Query, "username:admin") {
+			entries = corp[:1]
+		}
+		writeJSON(w, http.StatusOK, dehashed.SearchResponse{Balance: 9, Entries: entries, Success: true, TotalResults: len(entries)})
+	})
+	mux.HandleFunc("POST /v2/whois/search", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"domain": "corp.com", "subdomains": []string{"www.corp.com", "mail.corp.com", "vpn.corp.com"}})
+	})
+	mux.HandleFunc("GET /v2/whois/credits", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, dehashed.WhoisCreditsResponse{WhoisCredits: 5})
+	})
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Dehashed-Api-Key") != exampleAPIKey {
+			writeJSON(w, http.StatusUnauthorized, dehashed.ErrorResponse{HttpResponse: http.StatusUnauthorized, Error: "Invalid API key"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+}
+
+func ExampleSearchRequest_Add() {
+	req := dehashed.NewSearchRequest(1, 100, false, false, false).
+		Add(dehashed.Email, "@corp.com").
+		Add(dehashed.Username, "admin")
+	fmt.Println(req.Query)
+	// Output: email:@corp.com&username:admin
+}
+
+func ExampleSearchRequest_Add_password() {
+	// Passwords are searched by their SHA-256 hash unless ForcePlaintext is set
+	req := dehashed.NewSearchRequest(1, 100, false, false, false).Add(dehashed.Password, "password")
+	fmt.Println(req.Query)
+	// Output: hashed_password:5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8
+}
+
+func ExampleClient_Search() {
+	server := newMockAPI()
+	defer server.Close()
+
+	client := dehashed.New(
+		dehashed.WithAPIKey(exampleAPIKey),
+		dehashed.WithBaseURL(server.URL),
+		dehashed.WithRateLimit(5, 1),
+	)
+	req := dehashed.NewSearchRequest(1, 100, false, false, false).Add(dehashed.Username, "admin")
+	resp, err := client.Search(context.Background(), req)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println("total:", resp.TotalResults, "balance:", resp.Balance)
+	for _, entry := range resp.Entries {
+		fmt.Println(entry.DehashedId, entry.Email)
+	}
+	// Output:
+	// total: 1 balance: 9
+	// mock-0001 [admin@corp.com]
+}
+
+func ExampleClient_SearchStream() {
+	server := newMockAPI()
+	defer server.Close()
+
+	client := dehashed.New(dehashed.WithAPIKey(exampleAPIKey), dehashed.WithBaseURL(server.URL))
+	req := dehashed.NewSearchRequest(1, 100, false, false, false).Add(dehashed.Email, "@corp.com")
+
+	// Entries are handled one at a time as they are decoded, the response has none
+	count := 0
+	resp, err := client.SearchStream(context.Background(), req, func(entry dehashed.Result) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("streamed:", count, "total:", resp.TotalResults, "entries:", len(resp.Entries))
+	// Output: streamed: 3 total: 3 entries: 0
+}
+
+func ExampleClient_Search_errors() {
+	server := newMockAPI()
+	defer server.Close()
+
+	client := dehashed.New(dehashed.WithAPIKey("wrong-key"), dehashed.WithBaseURL(server.URL))
+	_, err := client.Search(context.Background(), dehashed.NewSearchRequest(1, 100, false, false, false).Add(dehashed.Email, "@corp.com"))
+
+	var apiErr *dehashed.Error
+	if errors.As(err, &apiErr) {
+		fmt.Println("status:", apiErr.Code, "retryable:", apiErr.Retryable)
+	}
+	fmt.Println("unauthorized:", errors.Is(err, dehashed.ErrUnauthorized))
+	// Output:
+	// status: 401 retryable: false
+	// unauthorized: true
+}
+
+func ExampleClient_WhoisCredits() {
+	server := newMockAPI()
+	defer server.Close()
+
+	client := dehashed.New(dehashed.WithAPIKey(exampleAPIKey), dehashed.WithBaseURL(server.URL))
+	credits, err := client.WhoisCredits(context.Background())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(credits)
+	// Output: 5
+}
+
+func ExampleClient_WhoisSubdomainScan() {
+	server := newMockAPI()
+	defer server.Close()
+
+	client := dehashed.New(dehashed.WithAPIKey(exampleAPIKey), dehashed.WithBaseURL(server.URL))
+	resp, err := client.WhoisSubdomainScan(context.Background(), "corp.com")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// WHOIS responses differ by search type, so they are decoded into a matching type
+	var scan struct {
+		Subdomains []string `json:"subdomains"`
+	}
+	if err := resp.Decode(&scan); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(scan.Subdomains)
+	// Output: [www.corp.com mail.corp.com vpn.corp.com]
+}
@@ -0,0 +1,72 @@
+package dehashed
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket limiting how often requests are sent. It is safe for
+// concurrent use and may be shared by several clients so the limit applies to all of them.
+type RateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration // Time to refill a single token
+	burst    float64
+	tokens   float64
+	last     time.Time
+}
+
+// NewRateLimiter creates a limiter allowing rate requests per second with bursts of up to
+// burst requests. A non-positive rate returns nil, which never limits.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		interval: time.Duration(float64(time.Second) / rate),
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// Wait blocks until a request may be sent or ctx is done
+func (rl *RateLimiter) Wait(ctx context.Context) error {
+	if rl == nil {
+		return ctx.Err()
+	}
+
+	for {
+		wait := rl.reserve()
+		if wait <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve takes a token if one is available, otherwise it returns how long until one is
+func (rl *RateLimiter) reserve() time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	rl.tokens = min(rl.burst, rl.tokens+float64(now.Sub(rl.last))/float64(rl.interval))
+	rl.last = now
+
+	if rl.tokens >= 1 {
+		rl.tokens--
+		return 0
+	}
+	return time.Duration((1 - rl.tokens) * float64(rl.interval))
+}
@@ -0,0 +1,135 @@
+package dehashed
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Parameter is a field of the search API
+type Parameter string
+
+const (
+	Username       Parameter = "username"
+	Email          Parameter = "email"
+	Password       Parameter = "password"
+	HashedPassword Parameter = "hashed_password"
+	Name           Parameter = "name"
+	IpAddress      Parameter = "ip_address"
+	Domain         Parameter = "domain"
+	Vin            Parameter = "vin"
+	LicensePlate   Parameter = "license_plate"
+	Address        Parameter = "address"
+	Phone          Parameter = "phone"
+	Social         Parameter = "social"
+	CryptoAddress  Parameter = "cryptocurrency_address"
+)
+
+// Parameters lists every field of the search API
+var Parameters = []Parameter{Username, Email, Password, HashedPassword, Name, IpAddress, Domain, Vin, LicensePlate, Address, Phone, Social, CryptoAddress}
+
+// GetArgumentString returns the field:value term searching the parameter for arg
+func (p Parameter) GetArgumentString(arg string) string {
+	return fmt.Sprintf("%s:%s", string(p), arg)
+}
+
+// MaxPageSize is the largest number of entries the API returns per page
+const MaxPageSize = 10000
+
+// SearchRequest is the body of a search. Terms added with Add, or one of the typed
+// Add*Query methods, are combined with AND.
+type SearchRequest struct {
+	ForcePlaintext bool   `json:"-"` // Search passwords as given instead of by their SHA-256 hash
+	Page           int    `json:"page"`
+	Query          string `json:"query"`
+	Size           int    `json:"size"`
+	Wildcard       bool   `json:"wildcard"`
+	Regex          bool   `json:"regex"`
+	DeDupe         bool   `json:"de_dupe"`
+}
+
+// NewSearchRequest creates an empty search request for a page of size entries
+func NewSearchRequest(page, size int, wildcard, regex, forcePlaintext bool) *SearchRequest {
+	return &SearchRequest{Page: page, Query: "", Size: size, Wildcard: wildcard, Regex: regex, DeDupe: true, ForcePlaintext: forcePlaintext}
+}
+
+// Add adds a term searching param for value and returns the request for chaining.
+// Passwords are searched by their SHA-256 hash unless ForcePlaintext is set.
+func (r *SearchRequest) Add(param Parameter, value string) *SearchRequest {
+	switch param {
+	case Password:
+		r.AddPasswordQuery(value)
+	case HashedPassword:
+		r.AddHashedPasswordQuery(value)
+	default:
+		r.buildQuery(param.GetArgumentString(strings.TrimSpace(value)))
+	}
+	return r
+}
+
+func (r *SearchRequest) buildQuery(query string) {
+	if len(r.Query) > 0 {
+		r.Query = fmt.Sprintf("%s&%s", strings.TrimSpace(r.Query), strings.TrimSpace(query))
+	} else {
+		r.Query = query
+	}
+}
+
+func (r *SearchRequest) AddUsernameQuery(query string) {
+	r.Add(Username, query)
+}
+
+func (r *SearchRequest) AddEmailQuery(query string) {
+	r.Add(Email, query)
+}
+
+func (r *SearchRequest) AddIpAddressQuery(query string) {
+	r.Add(IpAddress, query)
+}
+
+func (r *SearchRequest) AddDomainQuery(query string) {
+	r.Add(Domain, query)
+}
+
+func (r *SearchRequest) AddPasswordQuery(query string) {
+	if r.ForcePlaintext {
+		r.buildQuery(Password.GetArgumentString(query))
+		return
+	}
+	hash := sha256.Sum256([]byte(query))
+	query = hex.EncodeToString(hash[:])
+	r.AddHashedPasswordQuery(query)
+}
+
+func (r *SearchRequest) AddVinQuery(query string) {
+	r.Add(Vin, query)
+}
+
+func (r *SearchRequest) AddLicensePlateQuery(query string) {
+	r.Add(LicensePlate, query)
+}
+
+func (r *SearchRequest) AddAddressQuery(query string) {
+	r.Add(Address, query)
+}
+
+func (r *SearchRequest) AddPhoneQuery(query string) {
+	r.Add(Phone, query)
+}
+
+func (r *SearchRequest) AddSocialQuery(query string) {
+	r.Add(Social, query)
+}
+
+func (r *SearchRequest) AddCryptoAddressQuery(query string) {
+	r.Add(CryptoAddress, query)
+}
+
+func (r *SearchRequest) AddHashedPasswordQuery(query string) {
+	r.buildQuery(HashedPassword.GetArgumentString(query))
+}
+
+func (r *SearchRequest) AddNameQuery(query string) {
+	r.Add(Name, query)
+}
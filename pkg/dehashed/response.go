@@ -0,0 +1,53 @@
+package dehashed
+
+import (
+	"encoding/json"
+)
+
+// Result is a single entry returned by a search
+type Result struct {
+	DehashedId            string   `json:"id" xml:"id" yaml:"id"`
+	Email                 []string `json:"email,omitempty" xml:"email,omitempty" yaml:"email,omitempty"`
+	IpAddress             []string `json:"ip_address,omitempty" xml:"ip_address,omitempty" yaml:"ip_address,omitempty"`
+	Username              []string `json:"username,omitempty" xml:"username,omitempty" yaml:"username,omitempty"`
+	Password              []string `json:"password,omitempty" xml:"password,omitempty" yaml:"password,omitempty"`
+	HashedPassword        []string `json:"hashed_password,omitempty" xml:"hashed_password,omitempty" yaml:"hashed_password,omitempty"`
+	HashType              string   `json:"hash_type,omitempty" xml:"hash_type,omitempty" yaml:"hash_type,omitempty"`
+	Name                  []string `json:"name,omitempty" xml:"name,omitempty" yaml:"name,omitempty"`
+	Vin                   []string `json:"vin,omitempty" xml:"vin,omitempty" yaml:"vin,omitempty"`
+	LicensePlate          []string `json:"license_plate,omitempty" xml:"license_plate,omitempty" yaml:"license_plate,omitempty"`
+	Url                   []string `json:"url,omitempty" xml:"url,omitempty" yaml:"url,omitempty"`
+	Social                []string `json:"social,omitempty" xml:"social,omitempty" yaml:"social,omitempty"`
+	CryptoCurrencyAddress []string `json:"cryptocurrency_address,omitempty" xml:"cryptocurrency_address,omitempty" yaml:"cryptocurrency_address,omitempty"`
+	Address               []string `json:"address,omitempty" xml:"address,omitempty" yaml:"address,omitempty"`
+	Phone                 []string `json:"phone,omitempty" xml:"phone,omitempty" yaml:"phone,omitempty"`
+	Company               []string `json:"company,omitempty" xml:"company,omitempty" yaml:"company,omitempty"`
+	DatabaseName          string   `json:"database_name,omitempty" xml:"database_name,omitempty" yaml:"database_name,omitempty"`
+}
+
+// SearchResponse is a page of search results
+type SearchResponse struct {
+	Balance      int      `json:"balance"`
+	Entries      []Result `json:"entries"`
+	Success      bool     `json:"success"`
+	Took         string   `json:"took"`
+	TotalResults int      `json:"total"`
+}
+
+// WhoisResponse is the JSON document returned by a WHOIS search. Its shape depends on
+// the search type, so it is kept as is; Decode unmarshals it into a matching type.
+type WhoisResponse json.RawMessage
+
+// Decode unmarshals the response into v
+func (wr WhoisResponse) Decode(v any) error {
+	return json.Unmarshal(wr, v)
+}
+
+func (wr WhoisResponse) String() string {
+	return string(wr)
+}
+
+// WhoisCreditsResponse reports the remaining WHOIS credits
+type WhoisCreditsResponse struct {
+	WhoisCredits int `json:"whois_credits"`
+}
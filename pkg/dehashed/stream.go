@@ -0,0 +1,111 @@
+package dehashed
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// errMalformed reports a response body that is valid JSON but not a search response
+var errMalformed = errors.New("unexpected response structure")
+
+// DecodeSearchResponse decodes a search response from r, walking the entries array token
+// by token and passing every entry to fn as soon as it is decoded, so a page never has to
+// be held in memory. The returned response carries everything but the entries.
+//
+// Errors returned by fn stop decoding and are returned as is; malformed or truncated
+// responses are returned as *Error.
+func DecodeSearchResponse(r io.Reader, fn func(Result) error) (*SearchResponse, error) {
+	decoder := json.NewDecoder(r)
+	var response SearchResponse
+
+	if err := expectDelim(decoder, '{'); err != nil {
+		return nil, decodeError(err)
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, decodeError(err)
+		}
+		key, _ := token.(string)
+
+		switch key {
+		case "entries":
+			if err := decodeEntries(decoder, fn); err != nil {
+				return nil, err
+			}
+			continue
+		case "balance":
+			err = decoder.Decode(&response.Balance)
+		case "success":
+			err = decoder.Decode(&response.Success)
+		case "took":
+			err = decoder.Decode(&response.Took)
+		case "total":
+			err = decoder.Decode(&response.TotalResults)
+		default:
+			var skip json.RawMessage
+			err = decoder.Decode(&skip)
+		}
+		if err != nil {
+			return nil, decodeError(err)
+		}
+	}
+	if err := expectDelim(decoder, '}'); err != nil {
+		return nil, decodeError(err)
+	}
+	return &response, nil
+}
+
+// decodeEntries decodes the entries array one result at a time. A null array has no entries.
+func decodeEntries(decoder *json.Decoder, fn func(Result) error) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return decodeError(err)
+	}
+	if token == nil {
+		return nil
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return decodeError(fmt.Errorf("%w: expected entries array, found %v", errMalformed, token))
+	}
+
+	for decoder.More() {
+		var result Result
+		if err := decoder.Decode(&result); err != nil {
+			return decodeError(err)
+		}
+		if err := fn(result); err != nil {
+			return err
+		}
+	}
+	if err := expectDelim(decoder, ']'); err != nil {
+		return decodeError(err)
+	}
+	return nil
+}
+
+// expectDelim reads the next token and fails unless it is the given delimiter
+func expectDelim(decoder *json.Decoder, want json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != want {
+		return fmt.Errorf("%w: expected %v, found %v", errMalformed, want, token)
+	}
+	return nil
+}
+
+// decodeError wraps a failure to decode a response body. Bodies cut off while reading may
+// succeed when requested again, malformed ones will not.
+func decodeError(err error) *Error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	retryable := !errors.As(err, &syntaxErr) && !errors.As(err, &typeErr) && !errors.Is(err, errMalformed)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return &Error{Message: "failed to decode response body", Code: -1, Retryable: retryable, Err: err}
+}
@@ -0,0 +1,87 @@
+package dehashed
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// HTTPConfig describes how requests reach the API
+type HTTPConfig struct {
+	Proxy      string        // http, https or socks5 proxy URL; the environment proxy is used when empty
+	Timeout    time.Duration // Limit for waiting on the response headers, 0 for none; reading a large body is not limited
+	CACertFile string        // PEM file of additional trusted certificate authorities, e.g. of an intercepting proxy
+	Insecure   bool          // Skip TLS certificate verification
+	UserAgent  string        // User-Agent header sent with every request
+}
+
+// NewTransport creates a transport routing requests according to the config
+func NewTransport(config HTTPConfig) (http.RoundTripper, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if config.Proxy != "" {
+		proxyURL, err := url.Parse(config.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", config.Proxy)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q, expected http, https or socks5", proxyURL.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if config.CACertFile != "" || config.Insecure {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: config.Insecure}
+		if config.CACertFile != "" {
+			pem, err := os.ReadFile(config.CACertFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+			}
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no PEM certificates found in %s", config.CACertFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	// The limit ends once the headers arrive, so a page of 10000 entries may take as long
+	// as it needs to download while a request the API never answers still fails
+	transport.ResponseHeaderTimeout = config.Timeout
+
+	if config.UserAgent == "" {
+		return transport, nil
+	}
+	return &userAgentTransport{userAgent: config.UserAgent, transport: transport}, nil
+}
+
+// NewHTTPClient creates an HTTP client with a transport from NewTransport
+func NewHTTPClient(config HTTPConfig) (*http.Client, error) {
+	transport, err := NewTransport(config)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// userAgentTransport sets the User-Agent header of every request
+type userAgentTransport struct {
+	userAgent string
+	transport http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.transport.RoundTrip(req)
+}
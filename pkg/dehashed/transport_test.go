@@ -0,0 +1,50 @@
+package dehashed
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPConfigTimeout(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	tests := []struct {
+		name        string
+		headerDelay time.Duration
+		bodyDelay   time.Duration
+		wantErr     bool
+	}{
+		{name: "fast response"},
+		{name: "slow body download", bodyDelay: 3 * timeout},
+		{name: "slow headers", headerDelay: 3 * timeout, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tt.headerDelay)
+				w.WriteHeader(http.StatusOK)
+				io.WriteString(w, `{"entries": [`)
+				w.(http.Flusher).Flush()
+				time.Sleep(tt.bodyDelay)
+				io.WriteString(w, `]}`)
+			}))
+			defer server.Close()
+
+			client, err := NewHTTPClient(HTTPConfig{Timeout: timeout})
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := client.Get(server.URL)
+			if err == nil {
+				_, err = io.ReadAll(res.Body)
+				res.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package dehashed
+
+import (
+	"context"
+	"encoding/json"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// WhoisSearchType selects the kind of WHOIS search
+type WhoisSearchType string
+
+const (
+	WhoisLookup        WhoisSearchType = "whois"
+	WhoisHistoryLookup WhoisSearchType = "whois-history"
+	ReverseWhoisLookup WhoisSearchType = "reverse-whois"
+	ReverseIPLookup    WhoisSearchType = "reverse-ip"
+	ReverseMXLookup    WhoisSearchType = "reverse-mx"
+	ReverseNSLookup    WhoisSearchType = "reverse-ns"
+	SubdomainScan      WhoisSearchType = "subdomain-scan"
+)
+
+// WhoisSearchRequest is the body of a WHOIS search
+type WhoisSearchRequest struct {
+	Include     []string        `json:"include,omitempty"`
+	Exclude     []string        `json:"exclude,omitempty"`
+	IPAddress   string          `json:"ip_address,omitempty"`
+	ReverseType string          `json:"reverse_type,omitempty"`
+	Domain      string          `json:"domain,omitempty"`
+	MXAddress   string          `json:"mx_address,omitempty"`
+	NSAddress   string          `json:"ns_address,omitempty"`
+	SearchType  WhoisSearchType `json:"search_type,omitempty"`
+}
+
+// WhoisSearch performs a WHOIS search, spending one WHOIS credit
+func (c *Client) WhoisSearch(ctx context.Context, req WhoisSearchRequest) (WhoisResponse, error) {
+	body, err := c.do(ctx, string(req.SearchType), http.MethodPost, "/v2/whois/search", req)
+	if err != nil {
+		return nil, err
+	}
+	return WhoisResponse(body), nil
+}
+
+// Whois looks up the WHOIS record of a domain
+func (c *Client) Whois(ctx context.Context, domain string) (WhoisResponse, error) {
+	return c.WhoisSearch(ctx, WhoisSearchRequest{Domain: domain, SearchType: WhoisLookup})
+}
+
+// WhoisHistory looks up the historical WHOIS records of a domain
+func (c *Client) WhoisHistory(ctx context.Context, domain string) (WhoisResponse, error) {
+	return c.WhoisSearch(ctx, WhoisSearchRequest{Domain: domain, SearchType: WhoisHistoryLookup})
+}
+
+// ReverseWhois finds domains whose WHOIS records contain all include terms and none of the exclude terms
+func (c *Client) ReverseWhois(ctx context.Context, include, exclude []string, reverseType string) (WhoisResponse, error) {
+	return c.WhoisSearch(ctx, WhoisSearchRequest{Include: include, Exclude: exclude, ReverseType: reverseType, SearchType: ReverseWhoisLookup})
+}
+
+// WhoisIP finds domains hosted on an IP address
+func (c *Client) WhoisIP(ctx context.Context, ipAddress string) (WhoisResponse, error) {
+	return c.WhoisSearch(ctx, WhoisSearchRequest{IPAddress: ipAddress, SearchType: ReverseIPLookup})
+}
+
+// WhoisMX finds domains using a mail server
+func (c *Client) WhoisMX(ctx context.Context, mxAddress string) (WhoisResponse, error) {
+	return c.WhoisSearch(ctx, WhoisSearchRequest{MXAddress: mxAddress, SearchType: ReverseMXLookup})
+}
+
+// WhoisNS finds domains using a name server
+func (c *Client) WhoisNS(ctx context.Context, nsAddress string) (WhoisResponse, error) {
+	return c.WhoisSearch(ctx, WhoisSearchRequest{NSAddress: nsAddress, SearchType: ReverseNSLookup})
+}
+
+// WhoisSubdomainScan finds the subdomains of a domain
+func (c *Client) WhoisSubdomainScan(ctx context.Context, domain string) (WhoisResponse, error) {
+	return c.WhoisSearch(ctx, WhoisSearchRequest{Domain: domain, SearchType: SubdomainScan})
+}
+
+// WhoisCredits returns the remaining WHOIS credits
+func (c *Client) WhoisCredits(ctx context.Context) (int, error) {
+	body, err := c.do(ctx, "whois_credits", http.MethodGet, "/v2/whois/credits", nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var response WhoisCreditsResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		c.logger.Error("whois_credits",
+			zap.String("message", "failed to unmarshal response body"),
+			zap.Error(err),
+		)
+		return 0, &Error{Message: "failed to unmarshal response body", Code: -1, Err: err}
+	}
+	return response.WhoisCredits, nil
+}